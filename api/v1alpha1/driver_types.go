@@ -18,23 +18,106 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+const (
+	ResourceCodeDriver     = "drv"
+	ResourceKindDriver     = "Driver"
+	ResourceSingularDriver = "driver"
+	ResourcePluralDriver   = "drivers"
 )
 
 // DriverSpec defines the desired state of Driver
 type DriverSpec struct {
 	Builtin bool `json:"builtin"`
+	// DownloadURL is the location of the docker-machine-driver-<name> binary.
+	// It is required for non-builtin drivers.
 	// +optional
 	DownloadURL string `json:"downloadURL,omitempty"`
+	// Checksum is the hex encoded sha256 digest of the binary served from DownloadURL,
+	// optionally prefixed with "sha256:". It is required for non-builtin drivers.
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+	// Version of the driver binary. It is only used for reporting.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Parameters declare the flags accepted by the driver. They are merged by
+	// name into the parameters discovered from docker-machine, so that a field
+	// set here, e.g. required or enum, overrides the discovered one.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []DriverParameter `json:"parameters,omitempty"`
+}
+
+// DriverParameterType is the type of the value of a driver flag.
+// +kubebuilder:validation:Enum=string;int;bool;stringSlice
+type DriverParameterType string
+
+const (
+	DriverParameterTypeString      DriverParameterType = "string"
+	DriverParameterTypeInt         DriverParameterType = "int"
+	DriverParameterTypeBool        DriverParameterType = "bool"
+	DriverParameterTypeStringSlice DriverParameterType = "stringSlice"
+)
+
+// DriverParameter describes a flag of docker-machine create for the driver.
+type DriverParameter struct {
+	// Name of the flag without the leading dashes, e.g. amazonec2-instance-type.
+	Name string `json:"name"`
+	// +optional
+	Type DriverParameterType `json:"type,omitempty"`
+	// Required parameters must be set in the Machine spec.parameters.
+	// +optional
+	Required bool `json:"required,omitempty"`
+	// Default is the value used by the driver when the parameter is not set.
+	// +optional
+	Default string `json:"default,omitempty"`
+	// Enum lists the allowed values of the parameter.
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+	// Secret parameters carry credentials. They are read from the auth Secret
+	// of the Machine and rejected in spec.parameters.
+	// +optional
+	Secret bool `json:"secret,omitempty"`
+	// +optional
+	Description string `json:"description,omitempty"`
 }
 
 // DriverStatus defines the observed state of Driver
 type DriverStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Phase DriverPhase `json:"phase,omitempty"`
+	// InstalledPath is the location of the installed driver binary.
+	// +optional
+	InstalledPath string `json:"installedPath,omitempty"`
+	// Version of the installed driver binary.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Checksum of the installed driver binary.
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+	// Parameters are the flags of the driver discovered from docker-machine create --help.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []DriverParameter `json:"parameters,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +kubebuilder:validation:MaxItems=8
+	Conditions []kmapi.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Builtin",type="boolean",JSONPath=".spec.builtin"
+//+kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.version"
+//+kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Driver is the Schema for the drivers API
 type Driver struct {
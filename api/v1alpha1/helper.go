@@ -25,12 +25,86 @@ import (
 
 type MachinePhase string
 
+const (
+	// ScriptResultVersionV1 is the version of the JSON script result format.
+	ScriptResultVersionV1 = "v1"
+
+	ScriptOutputKindSecret    = "Secret"
+	ScriptOutputKindConfigMap = "ConfigMap"
+)
+
+// MachineOperationType is the kind of a long running docker-machine operation.
+// +kubebuilder:validation:Enum=Create;Delete;Start;Stop;Restart;Recreate;Replace
+type MachineOperationType string
+
+const (
+	MachineOperationCreate  MachineOperationType = "Create"
+	MachineOperationDelete  MachineOperationType = "Delete"
+	MachineOperationStart   MachineOperationType = "Start"
+	MachineOperationStop    MachineOperationType = "Stop"
+	MachineOperationRestart MachineOperationType = "Restart"
+	// MachineOperationRecreate removes a lost machine, so that it is created again.
+	MachineOperationRecreate MachineOperationType = "Recreate"
+	// MachineOperationReplace removes the machine along with its cloud resources,
+	// so that it is created again with the changed spec.
+	MachineOperationReplace MachineOperationType = "Replace"
+)
+
+// MachineUpdateStrategyType is how changes of the create arguments are applied to a created machine.
+// +kubebuilder:validation:Enum=OnDelete;Recreate
+type MachineUpdateStrategyType string
+
+const (
+	// MachineUpdateStrategyOnDelete only reports the changes in the SpecDrifted condition,
+	// they apply once the Machine is deleted and created again.
+	MachineUpdateStrategyOnDelete MachineUpdateStrategyType = "OnDelete"
+	// MachineUpdateStrategyRecreate replaces the machine.
+	MachineUpdateStrategyRecreate MachineUpdateStrategyType = "Recreate"
+)
+
+// MachineRemediationPolicy is what the operator does once the instance of a
+// created machine is lost.
+// +kubebuilder:validation:Enum=None;Recreate;MarkFailed
+type MachineRemediationPolicy string
+
+const (
+	// MachineRemediationNone only reports the lost machine in the MachineHealthy condition.
+	MachineRemediationNone MachineRemediationPolicy = "None"
+	// MachineRemediationRecreate removes the lost machine and creates it again.
+	MachineRemediationRecreate MachineRemediationPolicy = "Recreate"
+	// MachineRemediationMarkFailed moves the Machine to the Failed phase.
+	MachineRemediationMarkFailed MachineRemediationPolicy = "MarkFailed"
+)
+
+// MachinePowerState is the power state of a created machine.
+type MachinePowerState string
+
+const (
+	MachinePowerStateRunning MachinePowerState = "Running"
+	MachinePowerStateStopped MachinePowerState = "Stopped"
+)
+
+// MachineRestartAnnotation restarts a running machine whenever its value changes,
+// e.g. to the current time.
+const MachineRestartAnnotation = "docker-machine.klusters.dev/restart"
+
 const (
 	MachineConditionTypeMachineReady             kmapi.ConditionType = "MachineReady"
 	MachineConditionTypeScriptReady              kmapi.ConditionType = "ScriptReady"
 	MachineConditionTypeAuthDataReady            kmapi.ConditionType = "AuthDataReady"
 	MachineConditionTypeClusterOperationComplete kmapi.ConditionType = "ClusterOperationComplete"
 	MachineConditionTypeMachineCreating          kmapi.ConditionType = "MachineCreating"
+	MachineConditionTypeDriverReady              kmapi.ConditionType = "DriverReady"
+	MachineConditionTypeParametersValid          kmapi.ConditionType = "ParametersValid"
+	// MachineConditionTypePowerStateSynced is informational, it does not affect
+	// the readiness of the Machine.
+	MachineConditionTypePowerStateSynced kmapi.ConditionType = "PowerStateSynced"
+	// MachineConditionTypeMachineHealthy is informational, a lost machine only
+	// affects the readiness of the Machine through spec.remediation.
+	MachineConditionTypeMachineHealthy kmapi.ConditionType = "MachineHealthy"
+	// MachineConditionTypeSpecDrifted is True while the machine was created with
+	// other create arguments than the spec renders now. It is informational.
+	MachineConditionTypeSpecDrifted kmapi.ConditionType = "SpecDrifted"
 )
 
 const (
@@ -41,6 +115,21 @@ const (
 	ReasonAuthDataNotFound           = "AuthDataNotFound"
 	ReasonScriptDataNotFound         = "ScriptDataNotFound"
 	ReasonMachineCreating            = "MachineCreating"
+	ReasonDriverNotFound             = "DriverNotFound"
+	ReasonDriverNotReady             = "DriverNotReady"
+	ReasonInvalidParameters          = "InvalidParameters"
+	ReasonPowerStateChanging         = "PowerStateChanging"
+	ReasonPowerOperationFailed       = "PowerOperationFailed"
+	ReasonPowerStateUnknown          = "PowerStateUnknown"
+	ReasonMachineNotFound            = "MachineNotFound"
+	ReasonInstanceNotFound           = "InstanceNotFound"
+	ReasonMachineError               = "MachineError"
+	ReasonMachineLost                = "MachineLost"
+	ReasonRecreateFailed             = "RecreateFailed"
+	ReasonHealthCheckFailed          = "HealthCheckFailed"
+	ReasonCreateArgsChanged          = "CreateArgsChanged"
+	ReasonMachineReplacing           = "MachineReplacing"
+	ReasonMachineClassNotFound       = "MachineClassNotFound"
 )
 
 const (
@@ -51,10 +140,13 @@ const (
 	MachinePhaseSuccess                    MachinePhase = "Success"
 	MachinePhaseTerminating                MachinePhase = "Terminating"
 	MachinePhaseFailed                     MachinePhase = "Failed"
+	MachinePhaseStopped                    MachinePhase = "Stopped"
 )
 
 func ConditionsOrder() []kmapi.ConditionType {
 	return []kmapi.ConditionType{
+		MachineConditionTypeDriverReady,
+		MachineConditionTypeParametersValid,
 		MachineConditionTypeMachineReady,
 		MachineConditionTypeClusterOperationComplete,
 		MachineConditionTypeAuthDataReady,
@@ -84,6 +176,9 @@ func GetPhase(obj *Machine) MachinePhase {
 	}
 
 	if cond.Status == metav1.ConditionTrue {
+		if obj.Status.PowerState == MachinePowerStateStopped {
+			return MachinePhaseStopped
+		}
 		return MachinePhaseSuccess
 	}
 
@@ -93,12 +188,79 @@ func GetPhase(obj *Machine) MachinePhase {
 	if cond.Reason == ReasonClusterOperationFailed {
 		return MachinePhaseClusterOperationFailed
 	}
-	if cond.Reason == ReasonMachineCreationFailed {
+	if cond.Reason == ReasonMachineCreationFailed || cond.Reason == ReasonInvalidParameters || cond.Reason == ReasonMachineLost {
 		return MachinePhaseFailed
 	}
+	if cond.Reason == ReasonDriverNotFound || cond.Reason == ReasonDriverNotReady || cond.Reason == ReasonMachineClassNotFound {
+		return MachinePhasePending
+	}
 	return MachinePhaseInProgress
 }
 
+type DriverPhase string
+
+const (
+	DriverConditionTypeDriverDownloaded kmapi.ConditionType = "DriverDownloaded"
+	DriverConditionTypeDriverVerified   kmapi.ConditionType = "DriverVerified"
+	DriverConditionTypeDriverInstalled  kmapi.ConditionType = "DriverInstalled"
+	// DriverConditionTypeParametersDiscovered is informational, it does not
+	// affect the readiness of the Driver.
+	DriverConditionTypeParametersDiscovered kmapi.ConditionType = "ParametersDiscovered"
+)
+
+const (
+	ReasonDownloadURLMissing   = "DownloadURLMissing"
+	ReasonDriverDownloadFailed = "DriverDownloadFailed"
+	ReasonChecksumMissing      = "ChecksumMissing"
+	ReasonChecksumMismatch     = "ChecksumMismatch"
+	ReasonDriverInstallFailed  = "DriverInstallFailed"
+	ReasonDriverDownloading    = "DriverDownloading"
+	ReasonDiscoveryFailed      = "DiscoveryFailed"
+)
+
+const (
+	DriverPhasePending     DriverPhase = "Pending"
+	DriverPhaseDownloading DriverPhase = "Downloading"
+	DriverPhaseReady       DriverPhase = "Ready"
+	DriverPhaseFailed      DriverPhase = "Failed"
+)
+
+func DriverConditionsOrder() []kmapi.ConditionType {
+	return []kmapi.ConditionType{
+		DriverConditionTypeDriverDownloaded,
+		DriverConditionTypeDriverVerified,
+		DriverConditionTypeDriverInstalled,
+	}
+}
+
+func GetDriverPhase(obj *Driver) DriverPhase {
+	conditions := obj.GetConditions()
+	if len(conditions) == 0 {
+		return DriverPhasePending
+	}
+	var cond kmapi.Condition
+	for i := range conditions {
+		c := conditions[i]
+		if c.Type == kmapi.ReadyCondition {
+			cond = c
+			break
+		}
+	}
+	if cond.Type != kmapi.ReadyCondition {
+		return DriverPhasePending
+	}
+
+	if cond.Status == metav1.ConditionTrue {
+		return DriverPhaseReady
+	}
+
+	switch cond.Reason {
+	case ReasonDownloadURLMissing, ReasonChecksumMissing, ReasonChecksumMismatch, ReasonDriverDownloadFailed, ReasonDriverInstallFailed:
+		return DriverPhaseFailed
+	}
+	return DriverPhaseDownloading
+}
+
 func GetFinalizer() string {
 	return GroupVersion.Group
 }
@@ -31,12 +31,136 @@ const (
 
 // MachineSpec defines the desired state of Machine
 type MachineSpec struct {
-	Driver *core.LocalObjectReference `json:"driver"`
+	// ClassRef is the MachineClass or ClusterMachineClass the Machine takes the
+	// fields it does not set from. The driver, the Secret references and the
+	// script key of the Machine win over the ones of the class. Parameters and
+	// flags are merged by name, the ones of the Machine win. A parameter of the
+	// Machine also replaces the flag of the same name of the class.
 	// +optional
-	ScriptRef  *kmapi.ObjectReference `json:"scriptRef"`
-	AuthSecret *kmapi.ObjectReference `json:"authSecret"`
+	ClassRef *MachineClassReference `json:"classRef,omitempty"`
+	// Driver is required unless it is set by the class.
+	// +optional
+	Driver *core.LocalObjectReference `json:"driver,omitempty"`
+	// +optional
+	ScriptRef *kmapi.ObjectReference `json:"scriptRef"`
+	// ScriptKey is the key of the script Secret that holds the startup script.
+	// The key is also the driver flag the script is passed with, e.g. google-userdata.
+	// Defaults to the first key of the Secret in sorted order.
+	// +optional
+	ScriptKey string `json:"scriptKey,omitempty"`
+	// AuthSecret is required unless it is set by the class.
+	// +optional
+	AuthSecret *kmapi.ObjectReference `json:"authSecret,omitempty"`
+	// Parameters are the driver flags without the leading dashes. Parameters of
+	// type bool in the schema of the Driver are passed as flags without a value,
+	// the comma separated values of stringSlice parameters as repeated flags.
 	// +optional
 	Parameters map[string]string `json:"parameters"`
+	// Flags are driver flags that can not be expressed as parameters, e.g.
+	// repeated flags like engine-opt. A flag takes precedence over the parameter
+	// of the same name.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Flags []MachineFlag `json:"flags,omitempty"`
+	// ScriptOutputRef is an optional Secret or ConfigMap in the Machine namespace
+	// where the outputs reported by the startup script are copied.
+	// +optional
+	ScriptOutputRef *ScriptOutputReference `json:"scriptOutputRef,omitempty"`
+	// WriteConnectionSecretToRef is the name of a Secret in the Machine namespace
+	// where the docker TLS credentials, the SSH key and the docker host are written.
+	// The Secret is created and owned by the Machine, an existing Secret of
+	// another owner is not written.
+	// +optional
+	WriteConnectionSecretToRef *core.LocalObjectReference `json:"writeConnectionSecretToRef,omitempty"`
+	// PowerState is the desired power state of the created machine. The machine
+	// is stopped and started with docker-machine, changes made out of band are reverted.
+	// +kubebuilder:validation:Enum=Running;Stopped
+	// +kubebuilder:default=Running
+	// +optional
+	PowerState MachinePowerState `json:"powerState,omitempty"`
+	// Remediation is what the operator does once the instance of the created
+	// machine is lost, e.g. deleted in the cloud console.
+	// +kubebuilder:validation:Enum=None;Recreate;MarkFailed
+	// +kubebuilder:default=None
+	// +optional
+	Remediation MachineRemediationPolicy `json:"remediation,omitempty"`
+	// UpdateStrategy is how changes of the create arguments, e.g. of the
+	// parameters or the script reference, are applied to the created machine.
+	// +kubebuilder:validation:Enum=OnDelete;Recreate
+	// +kubebuilder:default=OnDelete
+	// +optional
+	UpdateStrategy MachineUpdateStrategyType `json:"updateStrategy,omitempty"`
+}
+
+// MachineFlag is a flag of docker-machine create.
+type MachineFlag struct {
+	// Name of the flag without the leading dashes, e.g. engine-opt.
+	Name string `json:"name"`
+	// Values of the flag, the flag is repeated for every value.
+	// +optional
+	Values []string `json:"values,omitempty"`
+	// Bool passes the flag without a value if true. If false, the flag is
+	// not passed, even if it is set in parameters.
+	// +optional
+	Bool *bool `json:"bool,omitempty"`
+}
+
+// ScriptOutputReference points to the object that receives the script outputs.
+type ScriptOutputReference struct {
+	// +kubebuilder:validation:Enum=Secret;ConfigMap
+	// +kubebuilder:default=Secret
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// ScriptResult is the outcome reported by the startup script.
+type ScriptResult struct {
+	ExitCode int32 `json:"exitCode"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+	// +optional
+	Outputs map[string]string `json:"outputs,omitempty"`
+}
+
+// MachineConnection holds the details needed to reach a created Machine.
+type MachineConnection struct {
+	// Driver is the docker-machine driver the Machine was created with.
+	// +optional
+	Driver string `json:"driver,omitempty"`
+	// +optional
+	PublicIP string `json:"publicIP,omitempty"`
+	// +optional
+	PrivateIP string `json:"privateIP,omitempty"`
+	// DockerURL is the docker daemon endpoint, e.g. tcp://203.0.113.10:2376
+	// +optional
+	DockerURL string `json:"dockerURL,omitempty"`
+	// +optional
+	SSHUser string `json:"sshUser,omitempty"`
+	// +optional
+	SSHPort int32 `json:"sshPort,omitempty"`
+	// InstanceID is the identifier of the VM at the cloud provider.
+	// +optional
+	InstanceID string `json:"instanceID,omitempty"`
+	// +optional
+	Region string `json:"region,omitempty"`
+	// +optional
+	Zone string `json:"zone,omitempty"`
+}
+
+// MachineOperation is a long running docker-machine command executed in the
+// background for the Machine.
+type MachineOperation struct {
+	// ID identifies the run of the operation.
+	ID   string               `json:"id"`
+	Type MachineOperationType `json:"type"`
+	// StartedAt is the time the operation was started.
+	StartedAt metav1.Time `json:"startedAt"`
 }
 
 // MachineStatus defines the observed state of Machine
@@ -44,16 +168,49 @@ type MachineStatus struct {
 	// +optional
 	// +listType=map
 	// +listMapKey=type
-	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:MaxItems=16
 	Conditions []kmapi.Condition `json:"conditions"`
 	// +optional
 	Phase MachinePhase `json:"phase"`
+	// ScriptResult is the result reported by the startup script once it finishes.
+	// +optional
+	ScriptResult *ScriptResult `json:"scriptResult,omitempty"`
+	// Connection is recorded once the Machine is created.
+	// +optional
+	Connection *MachineConnection `json:"connection,omitempty"`
+	// Operation is the docker-machine operation in flight, if any.
+	// +optional
+	Operation *MachineOperation `json:"operation,omitempty"`
+	// PowerState is the state of the machine reported by docker-machine status,
+	// e.g. Running, Stopped or Error.
+	// +optional
+	PowerState MachinePowerState `json:"powerState,omitempty"`
+	// ObservedRestart is the value of the restart annotation the machine was last restarted for.
+	// +optional
+	ObservedRestart string `json:"observedRestart,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CreateArgsHash identifies the create arguments the machine was created with.
+	// +optional
+	CreateArgsHash string `json:"createArgsHash,omitempty"`
+	// EffectiveSpec is the spec of a Machine with spec.classRef merged with its class.
+	// +optional
+	EffectiveSpec *MachineClassSpec `json:"effectiveSpec,omitempty"`
 }
 
 // Machine is the Schema for the machines API
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Driver",type="string",JSONPath=".status.connection.driver"
+// +kubebuilder:printcolumn:name="Public-IP",type="string",JSONPath=".status.connection.publicIP"
+// +kubebuilder:printcolumn:name="Private-IP",type="string",JSONPath=".status.connection.privateIP",priority=1
+// +kubebuilder:printcolumn:name="Docker-URL",type="string",JSONPath=".status.connection.dockerURL",priority=1
+// +kubebuilder:printcolumn:name="Instance",type="string",JSONPath=".status.connection.instanceID",priority=1
+// +kubebuilder:printcolumn:name="Region",type="string",JSONPath=".status.connection.region",priority=1
+// +kubebuilder:printcolumn:name="Zone",type="string",JSONPath=".status.connection.zone",priority=1
+// +kubebuilder:printcolumn:name="Power",type="string",JSONPath=".status.powerState",priority=1
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=".status.phase"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Machine struct {
@@ -0,0 +1,111 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+const (
+	ResourceCodeMachineClass     = "mcc"
+	ResourceKindMachineClass     = "MachineClass"
+	ResourceSingularMachineClass = "machineclass"
+	ResourcePluralMachineClass   = "machineclasses"
+
+	ResourceCodeClusterMachineClass     = "cmcc"
+	ResourceKindClusterMachineClass     = "ClusterMachineClass"
+	ResourceSingularClusterMachineClass = "clustermachineclass"
+	ResourcePluralClusterMachineClass   = "clustermachineclasses"
+)
+
+// MachineClassSpec holds the fields a Machine takes from its class. Secret
+// references without a namespace refer to the namespace of the Machine.
+type MachineClassSpec struct {
+	// +optional
+	Driver *core.LocalObjectReference `json:"driver,omitempty"`
+	// +optional
+	AuthSecret *kmapi.ObjectReference `json:"authSecret,omitempty"`
+	// +optional
+	ScriptRef *kmapi.ObjectReference `json:"scriptRef,omitempty"`
+	// +optional
+	ScriptKey string `json:"scriptKey,omitempty"`
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Flags []MachineFlag `json:"flags,omitempty"`
+}
+
+// MachineClassReference points to the MachineClass in the namespace of the
+// Machine or to a ClusterMachineClass.
+type MachineClassReference struct {
+	// +kubebuilder:validation:Enum=MachineClass;ClusterMachineClass
+	// +kubebuilder:default=MachineClass
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// MachineClass is the Schema for the machineclasses API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Driver",type="string",JSONPath=".spec.driver.name"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type MachineClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MachineClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MachineClassList contains a list of MachineClass
+type MachineClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MachineClass `json:"items"`
+}
+
+// ClusterMachineClass is the Schema for the clustermachineclasses API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Driver",type="string",JSONPath=".spec.driver.name"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type ClusterMachineClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MachineClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterMachineClassList contains a list of ClusterMachineClass
+type ClusterMachineClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterMachineClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MachineClass{}, &MachineClassList{}, &ClusterMachineClass{}, &ClusterMachineClassList{})
+}
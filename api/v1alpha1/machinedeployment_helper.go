@@ -0,0 +1,58 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+const (
+	// MachineDeploymentNameLabel is set on the MachineSets and Machines of a
+	// MachineDeployment to the name of the deployment.
+	MachineDeploymentNameLabel = "docker-machine.klusters.dev/machine-deployment"
+	// MachineTemplateHashLabel is set on the MachineSets and Machines of a
+	// MachineDeployment to the hash of the template they are created from.
+	MachineTemplateHashLabel = "docker-machine.klusters.dev/template-hash"
+	// MachineDeploymentRevisionAnnotation is the revision of a MachineSet of a MachineDeployment.
+	MachineDeploymentRevisionAnnotation = "docker-machine.klusters.dev/revision"
+
+	defaultRevisionHistoryLimit = 10
+)
+
+func (in *MachineDeployment) GetStatus() *MachineDeploymentStatus {
+	return &in.Status
+}
+
+// GetReplicas returns the desired number of Machines of the deployment.
+func (in *MachineDeployment) GetReplicas() int32 {
+	if in.Spec.Replicas == nil {
+		return 1
+	}
+	return *in.Spec.Replicas
+}
+
+// GetRevisionHistoryLimit returns the number of scaled down MachineSets kept for a rollback.
+func (in *MachineDeployment) GetRevisionHistoryLimit() int32 {
+	if in.Spec.RevisionHistoryLimit == nil {
+		return defaultRevisionHistoryLimit
+	}
+	return *in.Spec.RevisionHistoryLimit
+}
+
+// GetStrategyType returns the type of the update strategy, RollingUpdate if it is not set.
+func (in *MachineDeployment) GetStrategyType() MachineDeploymentStrategyType {
+	if in.Spec.Strategy == nil || in.Spec.Strategy.Type == "" {
+		return MachineDeploymentStrategyRollingUpdate
+	}
+	return in.Spec.Strategy.Type
+}
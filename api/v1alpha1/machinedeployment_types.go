@@ -0,0 +1,154 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	ResourceCodeMachineDeployment     = "mcd"
+	ResourceKindMachineDeployment     = "MachineDeployment"
+	ResourceSingularMachineDeployment = "machinedeployment"
+	ResourcePluralMachineDeployment   = "machinedeployments"
+)
+
+// MachineDeploymentSpec defines the desired state of MachineDeployment
+type MachineDeploymentSpec struct {
+	// Replicas is the number of Machines of the deployment.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Template is the Machine the Machines of the deployment are created from.
+	// A change of the template rolls out a new MachineSet.
+	Template MachineTemplateSpec `json:"template"`
+	// Strategy is how the Machines of the previous templates are replaced.
+	// +optional
+	Strategy *MachineDeploymentStrategy `json:"strategy,omitempty"`
+	// RevisionHistoryLimit is the number of scaled down MachineSets kept for a rollback.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=10
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo rolls the template back to the one of a previous revision. It
+	// is cleared once the template is restored.
+	// +optional
+	RollbackTo *MachineDeploymentRollback `json:"rollbackTo,omitempty"`
+}
+
+// MachineDeploymentStrategyType is how a MachineDeployment replaces its Machines.
+// +kubebuilder:validation:Enum=RollingUpdate;Recreate
+type MachineDeploymentStrategyType string
+
+const (
+	// MachineDeploymentStrategyRollingUpdate replaces the Machines one batch at a
+	// time within the bounds of maxSurge and maxUnavailable.
+	MachineDeploymentStrategyRollingUpdate MachineDeploymentStrategyType = "RollingUpdate"
+	// MachineDeploymentStrategyRecreate removes all Machines before the new ones are created.
+	MachineDeploymentStrategyRecreate MachineDeploymentStrategyType = "Recreate"
+)
+
+// MachineDeploymentStrategy describes how the Machines of a MachineDeployment are replaced.
+type MachineDeploymentStrategy struct {
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Type MachineDeploymentStrategyType `json:"type,omitempty"`
+	// +optional
+	RollingUpdate *MachineRollingUpdate `json:"rollingUpdate,omitempty"`
+}
+
+// MachineRollingUpdate bounds the number of Machines during a rolling update.
+// If both are zero, maxSurge is 1.
+type MachineRollingUpdate struct {
+	// MaxSurge is the number or percentage of Machines created above the
+	// desired replicas. Percentages are rounded up. Defaults to 1.
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// MaxUnavailable is the number or percentage of the desired replicas that
+	// may be unavailable. Percentages are rounded down. Defaults to 0.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// MachineDeploymentRollback selects the revision a MachineDeployment is rolled back to.
+type MachineDeploymentRollback struct {
+	// Revision to roll back to, 0 is the previous revision.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// MachineDeploymentStatus defines the observed state of MachineDeployment
+type MachineDeploymentStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Revision of the MachineSet of the current template.
+	// +optional
+	Revision int64 `json:"revision,omitempty"`
+	// Replicas is the number of Machines of all MachineSets of the deployment.
+	// +optional
+	Replicas int32 `json:"replicas"`
+	// UpdatedReplicas is the number of Machines created from the current template.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+	// ReadyReplicas is the number of created Machines.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// AvailableReplicas is the number of Machines whose startup script succeeded.
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas"`
+	// UnavailableReplicas is the number of desired replicas that are not available.
+	// +optional
+	UnavailableReplicas int32 `json:"unavailableReplicas"`
+	// Selector is the label selector of the Machines of the deployment, used by the scale subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+}
+
+// MachineDeployment is the Schema for the machinedeployments API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Desired",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="Current",type="integer",JSONPath=".status.replicas"
+// +kubebuilder:printcolumn:name="Up-to-date",type="integer",JSONPath=".status.updatedReplicas"
+// +kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.availableReplicas"
+// +kubebuilder:printcolumn:name="Revision",type="integer",JSONPath=".status.revision",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type MachineDeployment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MachineDeploymentSpec   `json:"spec,omitempty"`
+	Status MachineDeploymentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MachineDeploymentList contains a list of MachineDeployment
+type MachineDeploymentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MachineDeployment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MachineDeployment{}, &MachineDeploymentList{})
+}
@@ -0,0 +1,34 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+const (
+	// MachineSetNameLabel is set on the Machines of a MachineSet to the name of the set.
+	MachineSetNameLabel = "docker-machine.klusters.dev/machine-set"
+)
+
+func (in *MachineSet) GetStatus() *MachineSetStatus {
+	return &in.Status
+}
+
+// GetReplicas returns the desired number of Machines of the set.
+func (in *MachineSet) GetReplicas() int32 {
+	if in.Spec.Replicas == nil {
+		return 1
+	}
+	return *in.Spec.Replicas
+}
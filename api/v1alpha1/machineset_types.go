@@ -0,0 +1,107 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ResourceCodeMachineSet     = "mcs"
+	ResourceKindMachineSet     = "MachineSet"
+	ResourceSingularMachineSet = "machineset"
+	ResourcePluralMachineSet   = "machinesets"
+)
+
+// MachineSetSpec defines the desired state of MachineSet
+type MachineSetSpec struct {
+	// Replicas is the number of Machines of the set.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Template is the Machine the Machines of the set are created from. Changes
+	// of the template only apply to Machines created afterwards.
+	Template MachineTemplateSpec `json:"template"`
+}
+
+// MachineTemplateSpec describes the Machines created from a template.
+type MachineTemplateSpec struct {
+	// +optional
+	ObjectMeta MachineTemplateMeta `json:"metadata,omitempty"`
+	Spec       MachineSpec         `json:"spec"`
+}
+
+// MachineTemplateMeta is the metadata of the Machines created from a template.
+type MachineTemplateMeta struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// MachineSetStatus defines the observed state of MachineSet
+type MachineSetStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Replicas is the number of Machines of the set that are not being deleted.
+	// +optional
+	Replicas int32 `json:"replicas"`
+	// ReadyReplicas is the number of created Machines.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// AvailableReplicas is the number of Machines whose startup script succeeded.
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas"`
+	// FailedReplicas is the number of Machines in the Failed phase.
+	// +optional
+	FailedReplicas int32 `json:"failedReplicas,omitempty"`
+	// Selector is the label selector of the Machines of the set, used by the scale subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+}
+
+// MachineSet is the Schema for the machinesets API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:printcolumn:name="Desired",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="Current",type="integer",JSONPath=".status.replicas"
+// +kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.availableReplicas"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type MachineSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MachineSetSpec   `json:"spec,omitempty"`
+	Status MachineSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MachineSetList contains a list of MachineSet
+type MachineSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MachineSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MachineSet{}, &MachineSetList{})
+}
@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+
+# Copyright AppsCode Inc. and Contributors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -eou pipefail
+
+export CGO_ENABLED=1
+export GO111MODULE=on
+
+ENVTEST_K8S_VERSION=${ENVTEST_K8S_VERSION:-1.34.x}
+SETUP_ENVTEST_VERSION=${SETUP_ENVTEST_VERSION:-release-0.22}
+
+if [ -z "${KUBEBUILDER_ASSETS:-}" ]; then
+    KUBEBUILDER_ASSETS=$(GOFLAGS= go run sigs.k8s.io/controller-runtime/tools/setup-envtest@${SETUP_ENVTEST_VERSION} use ${ENVTEST_K8S_VERSION} -p path)
+    export KUBEBUILDER_ASSETS
+fi
+
+echo "Running envtest suites:"
+GOFLAGS="-mod=vendor" go test -race -tags envtest ./pkg/controller/... ./pkg/webhooks/...
+echo
@@ -0,0 +1,324 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fake-docker-machine is a stand-in for the docker-machine binary in envtest
+// and e2e suites. Machines only exist in the store directory, no cloud is involved.
+//
+// The outcome of create is chosen with the --fake-behavior flag, which a Machine
+// passes through spec.parameters, or the FAKE_DOCKER_MACHINE_BEHAVIOR variable:
+//
+//	success  the machine is created and running (default)
+//	fail     create exits with code 1
+//	timeout  create blocks until the process is killed
+//	exists   create fails because the machine already exists
+//
+// The startup script result read by "ssh <name> cat /tmp/result.txt" reports the
+// exit code given with --fake-script-exit-code, 0 by default.
+// FAKE_DOCKER_MACHINE_DELAY, e.g. 2s, delays every command.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	behaviorEnv = "FAKE_DOCKER_MACHINE_BEHAVIOR"
+	delayEnv    = "FAKE_DOCKER_MACHINE_DELAY"
+
+	behaviorSuccess = "success"
+	behaviorFail    = "fail"
+	behaviorTimeout = "timeout"
+	behaviorExists  = "exists"
+
+	stateRunning = "Running"
+	stateStopped = "Stopped"
+
+	resultFile = "/tmp/result.txt"
+)
+
+// config is the part of the docker-machine config.json the operator reads.
+type config struct {
+	Name       string            `json:"Name"`
+	DriverName string            `json:"DriverName"`
+	Driver     map[string]any    `json:"Driver"`
+	State      string            `json:"FakeState"`
+	Files      map[string]string `json:"FakeFiles,omitempty"`
+}
+
+func main() {
+	if d, err := time.ParseDuration(os.Getenv(delayEnv)); err == nil {
+		time.Sleep(d)
+	}
+
+	storage, args := storagePath(os.Args[1:])
+	if len(args) == 0 {
+		fail(1, "usage: fake-docker-machine [-s path] <command> [args...]")
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "create":
+		create(storage, args)
+	case "rm":
+		for _, name := range names(args) {
+			load(storage, name)
+			if err := os.RemoveAll(machineDir(storage, name)); err != nil {
+				fail(1, err.Error())
+			}
+			fmt.Printf("Successfully removed %s\n", name)
+		}
+	case "ssh":
+		ssh(storage, args)
+	case "scp":
+		scp(storage, args)
+	case "inspect":
+		c := load(storage, arg(args, 0))
+		out, _ := json.MarshalIndent(c, "", "    ")
+		fmt.Println(string(out))
+	case "url":
+		c := running(storage, arg(args, 0))
+		fmt.Printf("tcp://%s:2376\n", c.Driver["IPAddress"])
+	case "ip":
+		c := running(storage, arg(args, 0))
+		fmt.Println(c.Driver["IPAddress"])
+	case "status":
+		fmt.Println(load(storage, arg(args, 0)).State)
+	case "start":
+		setState(storage, arg(args, 0), stateRunning)
+	case "stop":
+		setState(storage, arg(args, 0), stateStopped)
+	case "restart":
+		setState(storage, arg(args, 0), stateRunning)
+	case "version":
+		fmt.Println("fake-docker-machine version 0.0.0")
+	default:
+		fail(1, fmt.Sprintf("unknown command %q", cmd))
+	}
+}
+
+// storagePath returns the store from the -s/--storage-path flag, MACHINE_STORAGE_PATH or the
+// docker-machine default, along with the remaining arguments.
+func storagePath(args []string) (string, []string) {
+	if len(args) > 1 && (args[0] == "-s" || args[0] == "--storage-path") {
+		return args[1], args[2:]
+	}
+	if p := os.Getenv("MACHINE_STORAGE_PATH"); p != "" {
+		return p, args
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker", "machine"), args
+}
+
+func create(storage string, args []string) {
+	name := arg(args, len(args)-1)
+	behavior := os.Getenv(behaviorEnv)
+	driver := "none"
+	scriptExitCode := 0
+	for i := 0; i < len(args)-2; i++ {
+		switch args[i] {
+		case "--driver", "-d":
+			driver = args[i+1]
+		case "--fake-behavior":
+			behavior = args[i+1]
+		case "--fake-script-exit-code":
+			code, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				fail(1, fmt.Sprintf("invalid --fake-script-exit-code: %v", err))
+			}
+			scriptExitCode = code
+		}
+	}
+
+	if _, err := os.Stat(configFile(storage, name)); err == nil {
+		behavior = behaviorExists
+	}
+	switch behavior {
+	case "", behaviorSuccess:
+	case behaviorFail:
+		fail(1, "Error creating machine: Error in driver during machine creation: fake failure")
+	case behaviorTimeout:
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		<-sig
+		fail(1, "Error creating machine: interrupted")
+	case behaviorExists:
+		fail(1, fmt.Sprintf("Error creating machine: Host already exists: %q", name))
+	default:
+		fail(1, fmt.Sprintf("unknown behavior %q", behavior))
+	}
+
+	dir := machineDir(storage, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		fail(1, err.Error())
+	}
+	for _, f := range []string{"ca.pem", "cert.pem", "key.pem", "server.pem", "server-key.pem", "id_rsa", "id_rsa.pub"} {
+		if err := os.WriteFile(filepath.Join(dir, f), []byte("fake "+f+" of "+name+"\n"), 0o600); err != nil {
+			fail(1, err.Error())
+		}
+	}
+	result, _ := json.Marshal(map[string]any{"version": "v1", "exitCode": scriptExitCode, "message": "fake script finished"})
+	save(storage, &config{
+		Name:       name,
+		DriverName: driver,
+		Driver: map[string]any{
+			"MachineName": name,
+			"IPAddress":   fakeIP(name),
+			"SSHUser":     "docker",
+			"SSHPort":     22,
+			"StorePath":   storage,
+		},
+		State: stateRunning,
+		Files: map[string]string{resultFile: string(result)},
+	})
+	fmt.Println("Docker is up and running!")
+}
+
+func ssh(storage string, args []string) {
+	c := running(storage, arg(args, 0))
+	command := args[1:]
+	if len(command) == 1 {
+		command = strings.Fields(command[0])
+	}
+	if len(command) == 2 && command[0] == "cat" {
+		data, ok := c.Files[command[1]]
+		if !ok {
+			fail(1, fmt.Sprintf("cat: %s: No such file or directory", command[1]))
+		}
+		fmt.Print(data)
+	}
+}
+
+// scp copies a local file to the machine or back, remote paths are prefixed with the machine name.
+func scp(storage string, args []string) {
+	var src, dst string
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") {
+			continue
+		}
+		if src == "" {
+			src = a
+		} else {
+			dst = a
+		}
+	}
+	if name, remote, ok := strings.Cut(dst, ":"); ok {
+		c := running(storage, name)
+		data, err := os.ReadFile(src)
+		if err != nil {
+			fail(1, err.Error())
+		}
+		c.Files[remote] = string(data)
+		save(storage, c)
+		return
+	}
+	if name, remote, ok := strings.Cut(src, ":"); ok {
+		c := running(storage, name)
+		data, ok := c.Files[remote]
+		if !ok {
+			fail(1, fmt.Sprintf("scp: %s: No such file or directory", remote))
+		}
+		if err := os.WriteFile(dst, []byte(data), 0o600); err != nil {
+			fail(1, err.Error())
+		}
+		return
+	}
+	fail(1, "scp needs a remote path")
+}
+
+func setState(storage, name, state string) {
+	c := load(storage, name)
+	c.State = state
+	save(storage, c)
+}
+
+func running(storage, name string) *config {
+	c := load(storage, name)
+	if c.State != stateRunning {
+		fail(1, fmt.Sprintf("Host is not running: %q", name))
+	}
+	return c
+}
+
+func load(storage, name string) *config {
+	data, err := os.ReadFile(configFile(storage, name))
+	if errors.Is(err, os.ErrNotExist) {
+		fail(1, fmt.Sprintf("Host does not exist: %q", name))
+	}
+	if err != nil {
+		fail(1, err.Error())
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		fail(1, err.Error())
+	}
+	if c.Files == nil {
+		c.Files = map[string]string{}
+	}
+	return &c
+}
+
+func save(storage string, c *config) {
+	data, _ := json.MarshalIndent(c, "", "    ")
+	if err := os.WriteFile(configFile(storage, c.Name), data, 0o600); err != nil {
+		fail(1, err.Error())
+	}
+}
+
+func machineDir(storage, name string) string {
+	return filepath.Join(storage, "machines", name)
+}
+
+func configFile(storage, name string) string {
+	return filepath.Join(machineDir(storage, name), "config.json")
+}
+
+// fakeIP derives a stable address from the machine name.
+func fakeIP(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return fmt.Sprintf("10.%d.%d.%d", sum[0], sum[1], sum[2]%254+1)
+}
+
+func names(args []string) []string {
+	var out []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func arg(args []string, i int) string {
+	if i < 0 || i >= len(args) {
+		fail(1, "machine name is missing")
+	}
+	return args[i]
+}
+
+func fail(code int, msg string) {
+	_, _ = io.WriteString(os.Stderr, msg+"\n")
+	os.Exit(code)
+}
@@ -19,16 +19,22 @@ package server
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
 	dockermachinev1alpha1 "go.klusters.dev/docker-machine-operator/api/v1alpha1"
 	"go.klusters.dev/docker-machine-operator/pkg/controller"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+	"go.klusters.dev/docker-machine-operator/pkg/webhooks"
 
 	"github.com/spf13/pflag"
 	v "gomodules.xyz/x/version"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
@@ -40,6 +46,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
 var (
@@ -63,6 +70,21 @@ type OperatorOptions struct {
 	ResyncPeriod   time.Duration
 	MaxNumRequeues int
 	NumThreads     int
+	DriverDir      string
+	StoragePath    string
+	WorkDir        string
+	Executor       string
+	JobImage       string
+	EnableWebhooks bool
+	WebhookPort    int
+	WebhookCertDir string
+
+	// JobCPURequest, JobMemoryRequest, JobCPULimit and JobMemoryLimit are the
+	// resources of the Job pods, an empty quantity is not set.
+	JobCPURequest    string
+	JobMemoryRequest string
+	JobCPULimit      string
+	JobMemoryLimit   string
 
 	metricsAddr          string
 	enableLeaderElection bool
@@ -71,9 +93,18 @@ type OperatorOptions struct {
 
 func NewOperatorOptions() *OperatorOptions {
 	return &OperatorOptions{
-		ResyncPeriod:   10 * time.Minute,
-		MaxNumRequeues: 5,
-		NumThreads:     2,
+		ResyncPeriod:     10 * time.Minute,
+		MaxNumRequeues:   5,
+		NumThreads:       2,
+		DriverDir:        controller.DefaultDriverDir,
+		StoragePath:      controller.DefaultMachineStoragePath(),
+		WorkDir:          controller.DefaultWorkDir,
+		Executor:         executor.ModeLocal,
+		JobCPURequest:    "100m",
+		JobMemoryRequest: "128Mi",
+		JobCPULimit:      "1",
+		JobMemoryLimit:   "512Mi",
+		WebhookPort:      9443,
 		// ref: https://github.com/kubernetes/ingress-nginx/blob/e4d53786e771cc6bdd55f180674b79f5b692e552/pkg/ingress/controller/launch.go#L252-L259
 		// High enough QPS to fit all expected use cases. QPS=0 is not set here, because client code is overriding it.
 		QPS: 1e6,
@@ -100,6 +131,20 @@ func (s *OperatorOptions) AddGoFlags(fs *flag.FlagSet) {
 	fs.Float64Var(&s.QPS, "qps", s.QPS, "The maximum QPS to the master from this client")
 	fs.IntVar(&s.Burst, "burst", s.Burst, "The maximum burst for throttle")
 	fs.DurationVar(&s.ResyncPeriod, "resync-period", s.ResyncPeriod, "If non-zero, will re-list this often. Otherwise, re-list will be delayed aslong as possible (until the upstream source closes the watch or times out.")
+	fs.IntVar(&s.NumThreads, "max-concurrent-reconciles", s.NumThreads, "The maximum number of Machines reconciled in parallel")
+	fs.StringVar(&s.DriverDir, "driver-dir", s.DriverDir, "Directory where non-builtin docker-machine driver binaries are installed, in a subdirectory per namespace. Only the Machines of that namespace find them.")
+	fs.StringVar(&s.WorkDir, "work-dir", s.WorkDir, "Directory for the per-machine startup scripts and script results")
+	fs.StringVar(&s.StoragePath, "machine-storage-path", s.StoragePath, "Path of the docker-machine store. Each machine's store directory is persisted in a Secret.")
+	fs.StringVar(&s.Executor, "executor", s.Executor, "Where docker-machine create, rm and ssh commands run. One of local (operator process) or job (a Kubernetes Job per command).")
+	fs.StringVar(&s.JobImage, "job-image", s.JobImage, "Image of the Jobs used by the job executor. It must contain docker-machine, curl and the drivers.")
+	fs.StringVar(&s.JobCPURequest, "job-cpu-request", s.JobCPURequest, "CPU request of the Job pods, not set if empty")
+	fs.StringVar(&s.JobMemoryRequest, "job-memory-request", s.JobMemoryRequest, "Memory request of the Job pods, not set if empty")
+	fs.StringVar(&s.JobCPULimit, "job-cpu-limit", s.JobCPULimit, "CPU limit of the Job pods, not set if empty")
+	fs.StringVar(&s.JobMemoryLimit, "job-memory-limit", s.JobMemoryLimit, "Memory limit of the Job pods, not set if empty")
+
+	fs.BoolVar(&s.EnableWebhooks, "enable-webhooks", s.EnableWebhooks, "Serve the defaulting and validating admission webhooks of Machines and Drivers")
+	fs.IntVar(&s.WebhookPort, "webhook-port", s.WebhookPort, "The port the webhook server listens on")
+	fs.StringVar(&s.WebhookCertDir, "webhook-cert-dir", s.WebhookCertDir, "Directory with the tls.crt and tls.key of the webhook server. Defaults to <temp-dir>/k8s-webhook-server/serving-certs.")
 
 	fs.StringVar(&s.metricsAddr, "metrics-bind-address", s.metricsAddr, "The address the metric endpoint binds to.")
 	fs.StringVar(&s.probeAddr, "health-probe-bind-address", s.probeAddr, "The address the probe endpoint binds to.")
@@ -125,7 +170,7 @@ func (s OperatorOptions) Run(ctx context.Context) error {
 
 	mgr, err := manager.New(cfg, manager.Options{
 		Scheme:  scheme,
-		Metrics: metricsserver.Options{BindAddress: ""},
+		Metrics: metricsserver.Options{BindAddress: s.metricsAddr},
 		Cache: cache.Options{
 			SyncPeriod: &syncPeriod,
 		},
@@ -133,26 +178,67 @@ func (s OperatorOptions) Run(ctx context.Context) error {
 		LeaderElection:         s.enableLeaderElection,
 		LeaderElectionID:       "54995429.klusters.dev",
 		NewClient:              cu.NewClient,
+		// the webhook server only starts when webhooks are registered
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    s.WebhookPort,
+			CertDir: s.WebhookCertDir,
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	machineExecutor, err := s.newExecutor(mgr)
+	if err != nil {
+		setupLog.Error(err, "unable to create executor")
+		os.Exit(1)
+	}
+
 	if err = (&controller.DriverReconciler{
-		KBClient: mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
+		KBClient:  mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		DriverDir: s.DriverDir,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Driver")
 		os.Exit(1)
 	}
 	if err = (&controller.MachineReconciler{
+		KBClient:                mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		StoragePath:             s.StoragePath,
+		WorkDir:                 s.WorkDir,
+		DriverDir:               s.DriverDir,
+		MaxConcurrentReconciles: s.NumThreads,
+		Executor:                machineExecutor,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Machine")
+		os.Exit(1)
+	}
+	if err = (&controller.MachineSetReconciler{
 		KBClient: mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Machine")
+		setupLog.Error(err, "unable to create controller", "controller", "MachineSet")
 		os.Exit(1)
 	}
+	if err = (&controller.MachineDeploymentReconciler{
+		KBClient: mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "MachineDeployment")
+		os.Exit(1)
+	}
+	if s.EnableWebhooks {
+		if err = (&webhooks.MachineWebhook{}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Machine")
+			os.Exit(1)
+		}
+		if err = (&webhooks.DriverWebhook{}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Driver")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -172,3 +258,60 @@ func (s OperatorOptions) Run(ctx context.Context) error {
 
 	return nil
 }
+
+// newExecutor returns the executor of the --executor mode. The commands that
+// only read the docker-machine store always run locally.
+func (s OperatorOptions) newExecutor(mgr manager.Manager) (executor.Executor, error) {
+	local := &executor.LocalExecutor{Client: mgr.GetClient()}
+	switch s.Executor {
+	case executor.ModeLocal:
+		return executor.NewDockerMachine(local, nil), nil
+	case executor.ModeJob:
+		if s.JobImage == "" {
+			return nil, fmt.Errorf("--job-image is required for the %s executor", executor.ModeJob)
+		}
+		resources, err := s.jobResources()
+		if err != nil {
+			return nil, err
+		}
+		kc, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return nil, err
+		}
+		jobs := &executor.JobExecutor{
+			KubeClient:  kc,
+			Scheme:      mgr.GetScheme(),
+			Image:       s.JobImage,
+			StoragePath: s.StoragePath,
+			Resources:   resources,
+		}
+		return executor.NewDockerMachine(jobs, local), nil
+	}
+	return nil, fmt.Errorf("unknown executor %q", s.Executor)
+}
+
+// jobResources returns the resource requests and limits of the Job pods.
+func (s OperatorOptions) jobResources() (core.ResourceRequirements, error) {
+	res := core.ResourceRequirements{Requests: core.ResourceList{}, Limits: core.ResourceList{}}
+	for _, q := range []struct {
+		list  core.ResourceList
+		name  core.ResourceName
+		value string
+		flag  string
+	}{
+		{res.Requests, core.ResourceCPU, s.JobCPURequest, "job-cpu-request"},
+		{res.Requests, core.ResourceMemory, s.JobMemoryRequest, "job-memory-request"},
+		{res.Limits, core.ResourceCPU, s.JobCPULimit, "job-cpu-limit"},
+		{res.Limits, core.ResourceMemory, s.JobMemoryLimit, "job-memory-limit"},
+	} {
+		if q.value == "" {
+			continue
+		}
+		v, err := resource.ParseQuantity(q.value)
+		if err != nil {
+			return res, fmt.Errorf("invalid --%s %q: %w", q.flag, q.value, err)
+		}
+		q.list[q.name] = v
+	}
+	return res, nil
+}
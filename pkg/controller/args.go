@@ -0,0 +1,186 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+// createArgs builds the driver flags of docker-machine create. The flags are
+// ordered by name and the values of a repeated flag keep their order, so the
+// same Machine always results in the same command line.
+type createArgs struct {
+	flags map[string]*flagValue
+}
+
+// flagValue holds the values of a flag, a flag without values is passed alone, e.g. a bool flag.
+type flagValue struct {
+	values []string
+}
+
+func newCreateArgs() *createArgs {
+	return &createArgs{flags: map[string]*flagValue{}}
+}
+
+// Set replaces the values of the flag. A flag with more than one value is repeated.
+func (a *createArgs) Set(name string, values ...string) {
+	a.flags[name] = &flagValue{values: append([]string(nil), values...)}
+}
+
+// SetBool passes the flag without a value if enabled, and removes it otherwise.
+func (a *createArgs) SetBool(name string, enabled bool) {
+	if !enabled {
+		delete(a.flags, name)
+		return
+	}
+	a.flags[name] = &flagValue{}
+}
+
+func (a *createArgs) Has(name string) bool {
+	_, ok := a.flags[name]
+	return ok
+}
+
+// Get returns the last value of the flag, the one docker-machine uses for a
+// flag with a single value. It is empty for a flag without a value.
+func (a *createArgs) Get(name string) (string, bool) {
+	f, ok := a.flags[name]
+	if !ok || len(f.values) == 0 {
+		return "", ok
+	}
+	return f.values[len(f.values)-1], true
+}
+
+// Args returns the flags in the form passed to docker-machine.
+func (a *createArgs) Args() []string {
+	names := make([]string, 0, len(a.flags))
+	for name := range a.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		f := a.flags[name]
+		if len(f.values) == 0 {
+			args = append(args, "--"+name)
+			continue
+		}
+		for _, v := range f.values {
+			args = append(args, "--"+name, v)
+		}
+	}
+	return args
+}
+
+// addParameters adds the Machine parameters. The schema of the driver decides
+// how a value is passed: bool parameters are flags without a value, the comma
+// separated values of stringSlice parameters are repeated flags. Parameters
+// unknown to the schema with an empty value are passed as flags without a value.
+func (a *createArgs) addParameters(params map[string]string, schema []api.DriverParameter) {
+	types := make(map[string]api.DriverParameterType, len(schema))
+	for _, p := range schema {
+		types[p.Name] = p.Type
+	}
+
+	for name, value := range params {
+		t, known := types[name]
+		switch {
+		case t == api.DriverParameterTypeBool:
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				a.Set(name, value)
+				continue
+			}
+			a.SetBool(name, enabled)
+		case t == api.DriverParameterTypeStringSlice:
+			a.Set(name, splitList(value)...)
+		case !known && value == "":
+			a.SetBool(name, true)
+		default:
+			a.Set(name, value)
+		}
+	}
+}
+
+// addFlags adds the Machine flags, replacing the parameters of the same name.
+func (a *createArgs) addFlags(flags []api.MachineFlag) {
+	for _, f := range flags {
+		if f.Bool != nil {
+			a.SetBool(f.Name, *f.Bool)
+			continue
+		}
+		a.Set(f.Name, f.Values...)
+	}
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// addAWSArgs adds the AMI of the region, unless set by the user or the
+// defaulting webhook, and the network created for the Machine.
+func (a *createArgs) addAWSArgs(machine *api.Machine) {
+	if !a.Has(AWSAMIParam) {
+		region, _ := a.Get(AWSRegionParam)
+		if ami := DefaultAMIID(region); ami != "" {
+			a.Set(AWSAMIParam, ami)
+		}
+	}
+	if id := machine.Annotations[awsVPCIDAnnotation]; id != "" {
+		a.Set("amazonec2-vpc-id", id)
+	}
+	if id := machine.Annotations[awsSubnetIDAnnotation]; id != "" {
+		a.Set("amazonec2-subnet-id", id)
+	}
+}
+
+// SpecParameter returns the value docker-machine create gets for the flag name
+// of spec, from spec.flags or else spec.parameters. The bool is false if the
+// flag is not passed.
+func SpecParameter(spec *api.MachineSpec, name string) (string, bool) {
+	a := newCreateArgs()
+	a.addParameters(spec.Parameters, nil)
+	a.addFlags(spec.Flags)
+	return a.Get(name)
+}
+
+// machineCreateArgs returns the driver flags of docker-machine create for the
+// Machine. The startup script is passed with the scriptKey flag, if set.
+func machineCreateArgs(machine *api.Machine, schema []api.DriverParameter, scriptKey string) []string {
+	a := newCreateArgs()
+	a.addParameters(machine.Spec.Parameters, schema)
+	a.addFlags(machine.Spec.Flags)
+	if scriptKey != "" {
+		a.Set(scriptKey, "$("+startupScriptEnv+")")
+	}
+	if machine.Spec.Driver.Name == AWSDriver {
+		a.addAWSArgs(machine)
+	}
+	return a.Args()
+}
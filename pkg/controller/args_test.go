@@ -0,0 +1,205 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the create args")
+
+func TestMachineCreateArgsGolden(t *testing.T) {
+	enabled, disabled := true, false
+	engineOpt := api.DriverParameter{Name: "engine-opt", Type: api.DriverParameterTypeStringSlice}
+	tests := []struct {
+		driver      string
+		params      map[string]string
+		annotations map[string]string
+		schema      []api.DriverParameter
+		flags       []api.MachineFlag
+		scriptKey   string
+	}{
+		{
+			driver: GoogleDriver,
+			params: map[string]string{
+				"google-project":      "demo",
+				"google-zone":         "us-central1-a",
+				"google-machine-type": "n1-standard-2",
+				"google-tags":         "http-server,https-server",
+			},
+			scriptKey: "google-userdata",
+		},
+		{
+			driver: AWSDriver,
+			params: map[string]string{
+				AWSRegionParam:                   "us-east-1",
+				"amazonec2-instance-type":        "t2.xlarge",
+				"amazonec2-open-port":            "80, 443",
+				"amazonec2-private-address-only": "true",
+				"amazonec2-use-ebs-optimized":    "false",
+				"amazonec2-tags":                 "env,prod,team,infra",
+				"engine-opt":                     "log-driver=json-file,log-opt=max-size=10m",
+			},
+			annotations: map[string]string{
+				awsVPCIDAnnotation:    "vpc-0123",
+				awsSubnetIDAnnotation: "subnet-0456",
+			},
+			schema: []api.DriverParameter{
+				{Name: "amazonec2-open-port", Type: api.DriverParameterTypeStringSlice},
+				{Name: "amazonec2-private-address-only", Type: api.DriverParameterTypeBool},
+				{Name: "amazonec2-use-ebs-optimized", Type: api.DriverParameterTypeBool},
+				{Name: "amazonec2-tags", Type: api.DriverParameterTypeString},
+				engineOpt,
+			},
+			scriptKey: "amazonec2-userdata",
+		},
+		{
+			driver: AzureDriver,
+			params: map[string]string{
+				"azure-location":         "eastus",
+				"azure-size":             "Standard_D2_v2",
+				AzureResourceGroupParam:  DefaultAzureResourceGroup,
+				"azure-image":            "canonical:UbuntuServer:18.04-LTS:latest",
+				"azure-open-port":        "6443,2379",
+				"azure-static-public-ip": "",
+			},
+			schema: []api.DriverParameter{
+				{Name: "azure-open-port", Type: api.DriverParameterTypeStringSlice},
+			},
+			scriptKey: "azure-custom-data",
+		},
+		{
+			driver: "digitalocean",
+			params: map[string]string{
+				"digitalocean-region": "ams3",
+				"digitalocean-size":   "s-1vcpu-1gb",
+				"digitalocean-ipv6":   "",
+				"engine-opt":          "log-driver=json-file",
+				"engine-label":        "env=prod",
+			},
+			schema: []api.DriverParameter{engineOpt},
+			flags: []api.MachineFlag{
+				{Name: "engine-opt", Values: []string{"log-driver=journald", "storage-driver=overlay2"}},
+				{Name: "engine-insecure-registry", Values: []string{"registry.local:5000", "10.0.0.10:5000"}},
+				{Name: "digitalocean-ipv6", Bool: &disabled},
+				{Name: "digitalocean-monitoring", Bool: &enabled},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			machine := &api.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "demo", Annotations: tt.annotations},
+				Spec: api.MachineSpec{
+					Driver:     &core.LocalObjectReference{Name: tt.driver},
+					Parameters: tt.params,
+					Flags:      tt.flags,
+				},
+			}
+			args := machineCreateArgs(machine, tt.schema, tt.scriptKey)
+			for i := 0; i < 10; i++ {
+				if again := machineCreateArgs(machine, tt.schema, tt.scriptKey); !reflect.DeepEqual(args, again) {
+					t.Fatalf("args are not stable:\n%v\n%v", args, again)
+				}
+			}
+
+			got := strings.Join(args, "\n") + "\n"
+			golden := filepath.Join("testdata", "args", tt.driver+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("args differ from %s, run the tests with -update if the change is expected\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestCreateArgs(t *testing.T) {
+	a := newCreateArgs()
+	a.Set("b", "1")
+	a.Set("a", "x", "y")
+	a.SetBool("c", true)
+	a.SetBool("d", true)
+	a.SetBool("d", false)
+	a.Set("b", "2")
+	want := []string{"--a", "x", "--a", "y", "--b", "2", "--c"}
+	if got := a.Args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Args() = %v, want %v", got, want)
+	}
+}
+
+func TestAWSRegionFromFlags(t *testing.T) {
+	machine := &api.Machine{Spec: api.MachineSpec{
+		Driver:     &core.LocalObjectReference{Name: AWSDriver},
+		Parameters: map[string]string{"amazonec2-instance-type": "t3.small"},
+		Flags:      []api.MachineFlag{{Name: AWSRegionParam, Values: []string{"eu-west-1"}}},
+	}}
+	if got := AWSRegion(&machine.Spec); got != "eu-west-1" {
+		t.Errorf("AWSRegion() = %q, want eu-west-1", got)
+	}
+
+	args := strings.Join(machineCreateArgs(machine, nil, ""), " ")
+	if want := "--" + AWSAMIParam + " " + DefaultAMIID("eu-west-1"); !strings.Contains(args, want) {
+		t.Errorf("args %q do not contain %q", args, want)
+	}
+
+	machine.Spec.Parameters[AWSRegionParam] = "us-east-1"
+	if got := AWSRegion(&machine.Spec); got != "eu-west-1" {
+		t.Errorf("AWSRegion() = %q, want the flag to replace the parameter", got)
+	}
+}
+
+func TestScriptSecretKey(t *testing.T) {
+	secret := &core.Secret{Data: map[string][]byte{
+		"google-userdata": []byte("#!/bin/sh"),
+		"aaa-notes":       []byte("notes"),
+		"empty":           nil,
+	}}
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{key: "", want: "aaa-notes"},
+		{key: "google-userdata", want: "google-userdata"},
+		{key: "empty", wantErr: true},
+		{key: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := scriptSecretKey(secret, tt.key)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("scriptSecretKey(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
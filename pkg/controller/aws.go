@@ -20,48 +20,35 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	core "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
 const (
 	awsAccessKeyField              = "amazonec2-access-key"
 	awsSecretKeyField              = "amazonec2-secret-key"
-	awsRegionField                 = "amazonec2-region"
+	AWSRegionParam                 = "amazonec2-region"
+	AWSAMIParam                    = "amazonec2-ami"
 	awsVPCIDAnnotation             = "docker-machine-operator/aws-vpc"
 	awsSubnetIDAnnotation          = "docker-machine-operator/aws-subnet"
 	awsInternetGatewayIDAnnotation = "docker-machine-operator/aws-gateway"
 	awsVpcCIDR                     = "10.1.0.0/16"
 	allowAllIPs                    = "0.0.0.0/0"
 	defaultZone                    = "a" // same as rancher amazonec2 driver default zone
-	regionParameter                = "amazonec2-region"
 )
 
 type awsAuthCredential struct {
 	accessKey, secretKey, region string
 }
 
-func (r *MachineReconciler) getAnnotationsArgsForAWS() []string {
-	var annotationArgs []string
-	if r.machineObj.Spec.Driver.Name == AWSDriver {
-		if r.machineObj.Annotations[awsVPCIDAnnotation] != "" {
-			annotationArgs = append(annotationArgs, "--amazonec2-vpc-id")
-			annotationArgs = append(annotationArgs, r.machineObj.Annotations[awsVPCIDAnnotation])
-		}
-		if r.machineObj.Annotations[awsSubnetIDAnnotation] != "" {
-			annotationArgs = append(annotationArgs, "--amazonec2-subnet-id")
-			annotationArgs = append(annotationArgs, r.machineObj.Annotations[awsSubnetIDAnnotation])
-		}
-	}
-	return annotationArgs
-}
-
-func (r *MachineReconciler) cleanupAWSResources() error {
+func (r *machineRequest) cleanupAWSResources() error {
 	c, err := r.awsEC2Client()
 	if err != nil {
 		return err
@@ -69,7 +56,7 @@ func (r *MachineReconciler) cleanupAWSResources() error {
 	return r.deleteAwsVpc(c, r.machineObj.Annotations[awsVPCIDAnnotation])
 }
 
-func (r *MachineReconciler) getAWSCredentials() (*awsAuthCredential, error) {
+func (r *machineRequest) getAWSCredentials() (*awsAuthCredential, error) {
 	authSecret, err := r.getSecret(r.machineObj.Spec.AuthSecret)
 	if err != nil {
 		return nil, err
@@ -83,14 +70,14 @@ func (r *MachineReconciler) getAWSCredentials() (*awsAuthCredential, error) {
 		secretKey: string(authSecret.Data[awsSecretKeyField]),
 	}
 
-	awsCreds.region = r.machineObj.Spec.Parameters[awsRegionField]
+	awsCreds.region = AWSRegion(&r.machineObj.Spec)
 	if awsCreds.secretKey == "" || awsCreds.accessKey == "" || awsCreds.region == "" {
 		return nil, errors.New("failed to get aws credentials or region")
 	}
 	return &awsCreds, nil
 }
 
-func (r *MachineReconciler) newAWSClientSession() (*session.Session, error) {
+func (r *machineRequest) newAWSClientSession() (*session.Session, error) {
 	cred, err := r.getAWSCredentials()
 	if err != nil {
 		return nil, err
@@ -105,10 +92,13 @@ func (r *MachineReconciler) newAWSClientSession() (*session.Session, error) {
 	if err != nil {
 		return nil, err
 	}
+	session.Handlers.Complete.PushBack(func(req *request.Request) {
+		observeCloudAPICall(cloudProviderAWS, req.Operation.Name, req.Error)
+	})
 	return session, nil
 }
 
-func (r *MachineReconciler) awsEC2Client() (*ec2.EC2, error) {
+func (r *machineRequest) awsEC2Client() (*ec2.EC2, error) {
 	sess, err := r.newAWSClientSession()
 	if err != nil {
 		return nil, err
@@ -186,7 +176,7 @@ func detachInternetGatewayToVPC(c *ec2.EC2, internetGatewayID, vpcID string) err
 	return err
 }
 
-func (r *MachineReconciler) createAwsInternetGateway(c *ec2.EC2, vpcId string) error {
+func (r *machineRequest) createAwsInternetGateway(c *ec2.EC2, vpcId string) error {
 	out, err := c.CreateInternetGateway(&ec2.CreateInternetGatewayInput{})
 	if err != nil {
 		return err
@@ -214,6 +204,7 @@ func (r *MachineReconciler) createAwsInternetGateway(c *ec2.EC2, vpcId string) e
 	}
 
 	klog.Infof("internet gateway is created with id: %s", *out.InternetGateway.InternetGatewayId)
+	r.event(core.EventTypeNormal, EventReasonInternetGatewayCreated, "Created internet gateway %s", *out.InternetGateway.InternetGatewayId)
 	return nil
 }
 
@@ -239,14 +230,15 @@ func deleteAwsInternetGateway(c *ec2.EC2, gatewayId, vpcId string) error {
 	return nil
 }
 
-func (r *MachineReconciler) createAwsSubnet(c *ec2.EC2, vpcID string) error {
-	if r.machineObj.Spec.Parameters[regionParameter] == "" {
+func (r *machineRequest) createAwsSubnet(c *ec2.EC2, vpcID string) error {
+	region := AWSRegion(&r.machineObj.Spec)
+	if region == "" {
 		return errors.New("region not specified")
 	}
 	out, err := c.CreateSubnet(&ec2.CreateSubnetInput{
 		CidrBlock:        stringToP(awsVpcCIDR),
 		VpcId:            &vpcID,
-		AvailabilityZone: stringToP(fmt.Sprintf("%s%s", r.machineObj.Spec.Parameters[regionParameter], defaultZone)),
+		AvailabilityZone: stringToP(fmt.Sprintf("%s%s", region, defaultZone)),
 	})
 	if err != nil {
 		return err
@@ -266,13 +258,16 @@ func (r *MachineReconciler) createAwsSubnet(c *ec2.EC2, vpcID string) error {
 	}
 
 	r.Log.Info("aws subnet created", "subnet id ", *out.Subnet.SubnetId)
+	r.event(core.EventTypeNormal, EventReasonSubnetCreated, "Created subnet %s", *out.Subnet.SubnetId)
 	return nil
 }
 
-func (r *MachineReconciler) deleteAwsSubnet(c *ec2.EC2, subnetId string) error {
-	if r.machineObj.Annotations[awsInternetGatewayIDAnnotation] != "" {
-		if err := deleteAwsInternetGateway(c, r.machineObj.Annotations[awsInternetGatewayIDAnnotation], r.machineObj.Annotations[awsVPCIDAnnotation]); err != nil {
+func (r *machineRequest) deleteAwsSubnet(c *ec2.EC2, subnetId string) error {
+	if gatewayID := r.machineObj.Annotations[awsInternetGatewayIDAnnotation]; gatewayID != "" {
+		if err := deleteAwsInternetGateway(c, gatewayID, r.machineObj.Annotations[awsVPCIDAnnotation]); err != nil {
 			klog.Warningf("failed to delete internet gateway, %s", err.Error())
+		} else {
+			r.event(core.EventTypeNormal, EventReasonInternetGatewayDeleted, "Deleted internet gateway %s", gatewayID)
 		}
 	}
 	_, err := c.DeleteSubnet(&ec2.DeleteSubnetInput{
@@ -287,6 +282,7 @@ func (r *MachineReconciler) deleteAwsSubnet(c *ec2.EC2, subnetId string) error {
 	}
 
 	r.Log.Info("subnet successfully deleted")
+	r.event(core.EventTypeNormal, EventReasonSubnetDeleted, "Deleted subnet %s", subnetId)
 	return nil
 }
 
@@ -306,7 +302,8 @@ func getVPC(c *ec2.EC2, vpcId *string) (*ec2.Vpc, error) {
 	return nil, fmt.Errorf("no vpc found with id %s", *vpcId)
 }
 
-func (r *MachineReconciler) createAwsVpc(c *ec2.EC2) error {
+// createAwsVpc returns false while the VPC is not available yet.
+func (r *machineRequest) createAwsVpc(c *ec2.EC2) (bool, error) {
 	var vpc *ec2.Vpc
 	var err error
 
@@ -315,53 +312,40 @@ func (r *MachineReconciler) createAwsVpc(c *ec2.EC2) error {
 			CidrBlock: stringToP(awsVpcCIDR),
 		})
 		if err != nil {
-			return err
+			return false, err
 		}
 		vpc = out.Vpc
 		if err = r.patchAnnotation(awsVPCIDAnnotation, *vpc.VpcId); err != nil {
-			return err
-		}
-
-		err = waitForState(5*time.Second, 1*time.Minute, func() (bool, error) {
-			vpc, err := getVPC(c, vpc.VpcId)
-			if err != nil {
-				return false, err
-			}
-
-			if *vpc.State == "available" {
-				return true, nil
-			}
-			return false, nil
-		})
-		if err != nil {
-			er := r.deleteAwsVpc(c, *vpc.VpcId)
-			if er != nil {
-				err = errors.Join(err, er)
-			}
-			return err
+			return false, err
 		}
+		r.event(core.EventTypeNormal, EventReasonVPCCreated, "Created VPC %s", *vpc.VpcId)
 	} else {
 		vpc, err = getVPC(c, stringToP(r.machineObj.Annotations[awsVPCIDAnnotation]))
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
+	if vpc.State == nil || *vpc.State != ec2.VpcStateAvailable {
+		r.Log.Info("waiting for aws vpc to become available", "vpc id", *vpc.VpcId)
+		return false, nil
+	}
+
 	if r.machineObj.Annotations[awsSubnetIDAnnotation] == "" {
 		if err = r.createAwsSubnet(c, *vpc.VpcId); err != nil {
 			er := r.deleteAwsVpc(c, *vpc.VpcId)
 			if er != nil {
 				err = errors.Join(err, er)
 			}
-			return err
+			return false, err
 		}
 	}
 
 	klog.Infof("aws vpc created with id %s", *vpc.VpcId)
-	return nil
+	return true, nil
 }
 
-func (r *MachineReconciler) deleteAwsVpc(c *ec2.EC2, vpcID string) error {
+func (r *machineRequest) deleteAwsVpc(c *ec2.EC2, vpcID string) error {
 	if r.machineObj.Annotations[awsVPCIDAnnotation] == "" {
 		return nil
 	}
@@ -386,6 +370,7 @@ func (r *MachineReconciler) deleteAwsVpc(c *ec2.EC2, vpcID string) error {
 	}
 
 	klog.Infof("vpc successfully delete")
+	r.event(core.EventTypeNormal, EventReasonVPCDeleted, "Deleted VPC %s", vpcID)
 	return nil
 }
 
@@ -404,6 +389,11 @@ func deleteSecurityGroup(c *ec2.EC2, vpcId string) error {
 		return err
 	}
 	for _, sg := range des.SecurityGroups {
+		if aws.StringValue(sg.GroupName) == "default" {
+			// deleted along with the vpc
+			continue
+		}
+		orphanedResourcesTotal.WithLabelValues("aws-security-group").Inc()
 		_, err = c.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
 			GroupId: sg.GroupId,
 		})
@@ -415,14 +405,55 @@ func deleteSecurityGroup(c *ec2.EC2, vpcId string) error {
 	return nil
 }
 
-func (r *MachineReconciler) createAWSEnvironment() error {
+// awsInstanceLost reports whether the EC2 instance of the machine is gone or
+// being terminated. Machines without a known instance ID are not checked.
+func (r *machineRequest) awsInstanceLost() (bool, error) {
+	conn := r.machineObj.Status.Connection
+	if conn == nil || conn.InstanceID == "" {
+		return false, nil
+	}
+	c, err := r.awsEC2Client()
+	if err != nil {
+		return false, err
+	}
+	out, err := c.DescribeInstancesWithContext(r.ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: stringPSlice([]string{conn.InstanceID}),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "InvalidInstanceID.NotFound" {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, res := range out.Reservations {
+		for _, inst := range res.Instances {
+			if aws.StringValue(inst.InstanceId) != conn.InstanceID || inst.State == nil {
+				continue
+			}
+			switch aws.StringValue(inst.State.Name) {
+			case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+				return true, nil
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// createAWSEnvironment returns false while the network of the machine is being created.
+func (r *machineRequest) createAWSEnvironment() (bool, error) {
 	if r.machineObj.Annotations[awsVPCIDAnnotation] != "" && r.machineObj.Annotations[awsSubnetIDAnnotation] != "" && r.machineObj.Annotations[awsInternetGatewayIDAnnotation] != "" {
-		return nil
+		return true, nil
 	}
 
 	c, err := r.awsEC2Client()
 	if err != nil {
-		return err
+		return false, err
+	}
+	ready, err := r.createAwsVpc(c)
+	if err != nil {
+		r.warning(EventReasonNetworkFailed, "Failed to create the network of the machine: %v", err)
 	}
-	return r.createAwsVpc(c)
+	return ready, err
 }
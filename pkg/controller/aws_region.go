@@ -16,6 +16,10 @@ limitations under the License.
 
 package controller
 
+import (
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
 // This list generated using scripts/ami.sh file
 var amiIDs = map[string]string{
 	"ap-south-2":     "ami-00680cef23a721c2a",
@@ -49,12 +53,13 @@ var amiIDs = map[string]string{
 	"us-east-2":      "ami-003932de22c285676",
 }
 
-func (r *MachineReconciler) getAMIIDArg() []string {
-	args := []string{}
-	region := r.machineObj.Spec.Parameters[awsRegionField]
-	if amiIDs[region] != "" {
-		args = append(args, "--amazonec2-ami", amiIDs[region])
-	}
+// DefaultAMIID returns the AMI used for Machines in the AWS region, or "" for an unknown region.
+func DefaultAMIID(region string) string {
+	return amiIDs[region]
+}
 
-	return args
+// AWSRegion returns the region of an amazonec2 Machine, set either as parameter or as flag.
+func AWSRegion(spec *api.MachineSpec) string {
+	region, _ := SpecParameter(spec, AWSRegionParam)
+	return region
 }
@@ -19,8 +19,11 @@ package controller
 import (
 	"fmt"
 
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	core "k8s.io/api/core/v1"
 )
 
 const (
@@ -28,8 +31,10 @@ const (
 	azureTenantIDKeyField       = "azure-tenant-id"
 	azureClientIDKeyField       = "azure-client-id"
 	azureClientSecretKeyField   = "azure-client-secret"
-	azureResourceGroupParam     = "azure-resource-group"
-	defaultAzureResourceGroup   = "docker-machine"
+	AzureResourceGroupParam     = "azure-resource-group"
+	DefaultAzureResourceGroup   = "docker-machine"
+	// azureComputeAPIVersion is the API version of the virtual machines checked with the generic resources client
+	azureComputeAPIVersion = "2023-03-01"
 )
 
 type AzureCredential struct {
@@ -39,35 +44,68 @@ type AzureCredential struct {
 	SubscriptionID string
 }
 
-func (r *MachineReconciler) deleteAzureResourceGroup() error {
+func (r *machineRequest) deleteAzureResourceGroup() error {
 	r.Log.Info("Deleting Azure Resource Group", "Name", r.machineObj.Name)
-	azureCred, err := r.getAzureCredential()
-	if err != nil {
-		return err
-	}
-	cred, err := azidentity.NewClientSecretCredential(azureCred.TenantID, azureCred.ClientID, azureCred.ClientSecret, nil)
+	azureCred, cred, err := r.newAzureCredential()
 	if err != nil {
 		return err
 	}
 
 	rgClient, err := armresources.NewResourceGroupsClient(azureCred.SubscriptionID, cred, nil)
 	if err != nil {
-		fmt.Println(err.Error())
 		return err
 	}
 	resourceGroupName := r.getResourceGroupName()
 	poller, err := rgClient.BeginDelete(r.ctx, resourceGroupName, nil)
+	observeCloudAPICall(cloudProviderAzure, "BeginDeleteResourceGroup", err)
 	if err != nil {
 		return err
 	}
-	if _, err := poller.PollUntilDone(r.ctx, nil); err != nil {
+	_, err = poller.PollUntilDone(r.ctx, nil)
+	observeCloudAPICall(cloudProviderAzure, "DeleteResourceGroup", err)
+	if err != nil {
 		return err
 	}
+	orphanedResourcesTotal.WithLabelValues("azure-resource-group").Inc()
+	r.event(core.EventTypeNormal, EventReasonResourceGroupDeleted, "Deleted resource group %s", resourceGroupName)
 
 	return nil
 }
 
-func (r *MachineReconciler) getAzureCredential() (*AzureCredential, error) {
+// azureInstanceLost reports whether the virtual machine of the machine is gone.
+// The azure driver names the virtual machine after the machine.
+func (r *machineRequest) azureInstanceLost() (bool, error) {
+	azureCred, cred, err := r.newAzureCredential()
+	if err != nil {
+		return false, err
+	}
+	c, err := armresources.NewClient(azureCred.SubscriptionID, cred, nil)
+	if err != nil {
+		return false, err
+	}
+	id := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		azureCred.SubscriptionID, r.getResourceGroupName(), executor.MachineName(r.machineObj))
+	resp, err := c.CheckExistenceByID(r.ctx, id, azureComputeAPIVersion, nil)
+	observeCloudAPICall(cloudProviderAzure, "CheckVirtualMachineExistence", err)
+	if err != nil {
+		return false, err
+	}
+	return !resp.Success, nil
+}
+
+func (r *machineRequest) newAzureCredential() (*AzureCredential, *azidentity.ClientSecretCredential, error) {
+	azureCred, err := r.getAzureCredential()
+	if err != nil {
+		return nil, nil, err
+	}
+	cred, err := azidentity.NewClientSecretCredential(azureCred.TenantID, azureCred.ClientID, azureCred.ClientSecret, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return azureCred, cred, nil
+}
+
+func (r *machineRequest) getAzureCredential() (*AzureCredential, error) {
 	authSecret, err := r.getSecret(r.machineObj.Spec.AuthSecret)
 	if err != nil {
 		return nil, err
@@ -88,11 +126,11 @@ func (r *MachineReconciler) getAzureCredential() (*AzureCredential, error) {
 	return &azureCred, nil
 }
 
-func (r *MachineReconciler) getResourceGroupName() string {
-	rgName, ok := r.machineObj.Spec.Parameters[azureResourceGroupParam]
+func (r *machineRequest) getResourceGroupName() string {
+	rgName, ok := r.machineObj.Spec.Parameters[AzureResourceGroupParam]
 	if !ok {
 		r.Log.Info("Using default resource group docker-machine")
-		rgName = defaultAzureResourceGroup
+		rgName = DefaultAzureResourceGroup
 	}
 	return rgName
 }
@@ -19,92 +19,180 @@ package controller
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 
 	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
 
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kmapi "kmodules.xyz/client-go/api/v1"
+	cu "kmodules.xyz/client-go/client"
 	cutil "kmodules.xyz/client-go/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-const resultFile = "/tmp/result.txt"
+const (
+	// remoteResultFile is written by the startup script on the machine once it finishes
+	remoteResultFile    = "/tmp/result.txt"
+	scriptResultTimeout = 5 * time.Minute
+)
+
+// scriptPollInterval is how often a Machine is re-queued while its startup script runs.
+var scriptPollInterval = time.Minute
 
-func (r *MachineReconciler) isScriptFinished() (bool, error) {
+func (r *machineRequest) isScriptFinished() (bool, error) {
 	if !cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineReady)) {
 		return false, nil
 	}
-	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachinePhaseSuccess)) || cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachinePhaseFailed)) {
+	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeClusterOperationComplete)) ||
+		cutil.GetReason(r.machineObj, api.MachineConditionTypeClusterOperationComplete) == api.ReasonClusterOperationFailed {
 		return false, nil
 	}
 
-	args := r.getScpArgs()
-	cmd := exec.Command("docker-machine", args...)
-	var commandOutput, commandError bytes.Buffer
-	cmd.Stdout = &commandOutput
-	cmd.Stderr = &commandError
-
-	err := cmd.Run()
+	ctx, cancel := context.WithTimeout(r.ctx, scriptResultTimeout)
+	defer cancel()
+	// polled often, so it runs like the other queries and not as a Job per poll
+	opts := r.executorOptions()
+	opts.ReadOnly = true
+	out, err := r.Executor.SSH(ctx, r.machineObj, opts, "cat", remoteResultFile)
 	if err != nil {
-		r.Log.Info("Waiting for Script Completion. Checking Again in 1 minute. ", "CommandError: ", commandError.String(), "Output: ", commandOutput.String(), "Error: ", err.Error())
+		r.Log.Info("Waiting for Script Completion. Checking Again later. ", "Error: ", err.Error())
 		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeClusterOperationComplete, api.ReasonWaitingForScriptCompletion, kmapi.ConditionSeverityError, "waiting for script completion")
 		return true, nil
 	}
 	r.Log.Info("Finished Cluster Operation Script.")
 
-	file, err := os.Open(resultFile)
+	result, err := parseScriptResult(out)
 	if err != nil {
+		r.Log.Info("Failed to Check Script Completion", "Error: ", err.Error())
+		return false, fmt.Errorf("failed to create cluster")
+	}
+	r.machineObj.Status.ScriptResult = result
+	observeScriptResult(r.machineObj, result.ExitCode)
+	if err := r.publishScriptOutputs(result.Outputs); err != nil {
 		return false, err
 	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		resStr := scanner.Text()
-		ret, err := strconv.Atoi(resStr)
-		if err == nil {
-			var createError error = nil
-			if ret == 0 {
-				r.Log.Info("Cluster Operation Finished Successfully")
-				cutil.MarkTrue(r.machineObj, api.MachineConditionTypeClusterOperationComplete)
-			} else {
-				r.Log.Info("Cluster Operation Failed")
-				cutil.MarkFalse(r.machineObj, api.MachineConditionTypeClusterOperationComplete, api.ReasonClusterOperationFailed, kmapi.ConditionSeverityError, "failed to create cluster")
-				createError = fmt.Errorf("failed to create cluster")
-			}
-			err := os.Remove(resultFile)
-			if err != nil {
-				return false, err
-			}
-			return false, createError
 
-		} else {
-			r.Log.Info("Failed to Check Script Completion", "Error: ", err.Error())
-		}
+	if result.ExitCode != 0 {
+		r.Log.Info("Cluster Operation Failed", "ExitCode", result.ExitCode, "Message", result.Message)
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeClusterOperationComplete, api.ReasonClusterOperationFailed, kmapi.ConditionSeverityError,
+			"script failed with exit code %d: %s", result.ExitCode, result.Message)
+		r.warning(EventReasonScriptFailed, "Startup script finished with exit code %d: %s", result.ExitCode, result.Message)
+		return false, fmt.Errorf("failed to create cluster")
 	}
-	return false, fmt.Errorf("failed to create cluster")
+	r.Log.Info("Cluster Operation Finished Successfully")
+	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeClusterOperationComplete)
+	r.event(core.EventTypeNormal, EventReasonScriptSucceeded, "Startup script finished successfully")
+	return false, nil
 }
 
-func (r *MachineReconciler) getScpArgs() []string {
-	args := []string{"scp"}
-	machineName := r.machineObj.Name
+// scriptResultV1 is the JSON document a startup script writes to remoteResultFile
+// when it finishes, e.g.
+//
+//	{
+//	  "version": "v1",
+//	  "exitCode": 0,
+//	  "message": "cluster is ready",
+//	  "startedAt": "2024-01-02T15:04:05Z",
+//	  "finishedAt": "2024-01-02T15:10:00Z",
+//	  "outputs": {"endpoint": "https://10.0.0.1:6443"}
+//	}
+//
+// Scripts that only write their exit code as a single integer are still supported.
+type scriptResultV1 struct {
+	Version    string            `json:"version"`
+	ExitCode   *int32            `json:"exitCode"`
+	Message    string            `json:"message,omitempty"`
+	StartedAt  *metav1.Time      `json:"startedAt,omitempty"`
+	FinishedAt *metav1.Time      `json:"finishedAt,omitempty"`
+	Outputs    map[string]string `json:"outputs,omitempty"`
+}
 
-	args = append(args, fmt.Sprintf("%s@%s:/tmp/result.txt", r.getDefaultUser(), machineName))
-	args = append(args, "/tmp")
+func parseScriptResult(data []byte) (*api.ScriptResult, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		var res scriptResultV1
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("invalid script result: %w", err)
+		}
+		if res.Version != api.ScriptResultVersionV1 {
+			return nil, fmt.Errorf("unsupported script result version %q", res.Version)
+		}
+		if res.ExitCode == nil {
+			return nil, fmt.Errorf("exitCode is missing in script result")
+		}
+		return &api.ScriptResult{
+			ExitCode:   *res.ExitCode,
+			Message:    res.Message,
+			StartedAt:  res.StartedAt,
+			FinishedAt: res.FinishedAt,
+			Outputs:    res.Outputs,
+		}, nil
+	}
 
-	return args
+	// legacy format, the first line holding an integer is the exit code
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		ret, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 32)
+		if err == nil {
+			return &api.ScriptResult{ExitCode: int32(ret)}, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no exit code found in script result")
 }
 
-func (r *MachineReconciler) getDefaultUser() string {
-	var defaultUser string
-	driverName := r.machineObj.Spec.Driver.Name
-	switch driverName {
-	case GoogleDriver:
-		defaultUser = "docker-user"
-	case AWSDriver:
-		defaultUser = "ubuntu"
-	case AzureDriver:
-		defaultUser = "docker-user"
+// publishScriptOutputs copies the script outputs into the object referenced by
+// spec.scriptOutputRef, if any. Only an object created here is owned by the
+// Machine, one created by the user is kept when the Machine is deleted.
+func (r *machineRequest) publishScriptOutputs(outputs map[string]string) error {
+	ref := r.machineObj.Spec.ScriptOutputRef
+	if ref == nil || len(outputs) == 0 {
+		return nil
+	}
+
+	meta := metav1.ObjectMeta{Name: ref.Name, Namespace: r.machineObj.Namespace}
+	var err, ownerErr error
+	switch ref.Kind {
+	case api.ScriptOutputKindConfigMap:
+		_, err = cu.CreateOrPatch(r.ctx, r.KBClient, &core.ConfigMap{ObjectMeta: meta}, func(object client.Object, createOp bool) client.Object {
+			cm := object.(*core.ConfigMap)
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			for k, v := range outputs {
+				cm.Data[k] = v
+			}
+			if createOp {
+				ownerErr = controllerutil.SetControllerReference(r.machineObj, cm, r.Scheme)
+			}
+			return cm
+		})
+	default:
+		_, err = cu.CreateOrPatch(r.ctx, r.KBClient, &core.Secret{ObjectMeta: meta}, func(object client.Object, createOp bool) client.Object {
+			secret := object.(*core.Secret)
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			for k, v := range outputs {
+				secret.Data[k] = []byte(v)
+			}
+			if createOp {
+				ownerErr = controllerutil.SetControllerReference(r.machineObj, secret, r.Scheme)
+			}
+			return secret
+		})
+	}
+	if err != nil {
+		return err
 	}
-	return defaultUser
+	return ownerErr
 }
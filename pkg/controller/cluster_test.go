@@ -0,0 +1,159 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestMachineRequest(workDir, namespace, name, uid string) *machineRequest {
+	return &machineRequest{
+		MachineReconciler: &MachineReconciler{WorkDir: workDir},
+		machineObj: &api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(uid)},
+		},
+		redactor: newRedactor(),
+	}
+}
+
+func TestWorkDirIsPerMachine(t *testing.T) {
+	workDir := t.TempDir()
+	reqs := []*machineRequest{
+		newTestMachineRequest(workDir, "demo", "vm", "uid-1"),
+		newTestMachineRequest(workDir, "prod", "vm", "uid-2"),
+		// a re-created Machine with the same name
+		newTestMachineRequest(workDir, "demo", "vm", "uid-3"),
+		newTestMachineRequest(workDir, "demo", "vm-2", "uid-4"),
+	}
+
+	seen := map[string]bool{}
+	for _, r := range reqs {
+		if seen[r.getWorkDir()] {
+			t.Errorf("path %s is shared between machines", r.getWorkDir())
+		}
+		seen[r.getWorkDir()] = true
+	}
+}
+
+func TestDeleteFilesKeepsOtherMachines(t *testing.T) {
+	workDir := t.TempDir()
+	deleted := newTestMachineRequest(workDir, "demo", "vm", "uid-1")
+	kept := newTestMachineRequest(workDir, "demo", "vm-2", "uid-2")
+	for _, r := range []*machineRequest{deleted, kept} {
+		if err := os.MkdirAll(r.getWorkDir(), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(r.getWorkDir(), "startup.sh"), []byte("#!/bin/sh"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := deleted.deleteFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "demo", "vm")); !os.IsNotExist(err) {
+		t.Errorf("working directory of the deleted machine still exists")
+	}
+	if _, err := os.Stat(filepath.Join(kept.getWorkDir(), "startup.sh")); err != nil {
+		t.Errorf("files of another machine were removed: %v", err)
+	}
+}
+
+func TestParseScriptResult(t *testing.T) {
+	started := metav1.NewTime(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+	finished := metav1.NewTime(time.Date(2024, 1, 2, 15, 10, 0, 0, time.UTC))
+
+	tests := []struct {
+		name    string
+		data    string
+		want    *api.ScriptResult
+		wantErr bool
+	}{
+		{
+			name: "v1",
+			data: `{"version":"v1","exitCode":3,"message":"kubeadm init failed","startedAt":"2024-01-02T15:04:05Z","finishedAt":"2024-01-02T15:10:00Z","outputs":{"endpoint":"https://10.0.0.1:6443"}}`,
+			want: &api.ScriptResult{
+				ExitCode:   3,
+				Message:    "kubeadm init failed",
+				StartedAt:  &started,
+				FinishedAt: &finished,
+				Outputs:    map[string]string{"endpoint": "https://10.0.0.1:6443"},
+			},
+		},
+		{
+			name: "v1 without optional fields",
+			data: "\n{\"version\": \"v1\", \"exitCode\": 0}\n",
+			want: &api.ScriptResult{ExitCode: 0},
+		},
+		{
+			name:    "v1 without exit code",
+			data:    `{"version":"v1","message":"done"}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown version",
+			data:    `{"version":"v2","exitCode":0}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed json",
+			data:    `{"version":"v1",`,
+			wantErr: true,
+		},
+		{
+			name: "legacy exit code",
+			data: "0\n",
+			want: &api.ScriptResult{ExitCode: 0},
+		},
+		{
+			name: "legacy exit code after other output",
+			data: "running\n 3 \n",
+			want: &api.ScriptResult{ExitCode: 3},
+		},
+		{
+			name:    "legacy without exit code",
+			data:    "running\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScriptResult([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("parseScriptResult() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net/url"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cu "kmodules.xyz/client-go/client"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// keys of the connection Secret
+const (
+	ConnectionSecretKeyCACert     = "ca.pem"
+	ConnectionSecretKeyClientCert = "cert.pem"
+	ConnectionSecretKeyClientKey  = "key.pem"
+	ConnectionSecretKeySSHKey     = "id_rsa"
+	ConnectionSecretKeyHost       = "host"
+	ConnectionSecretKeyPort       = "port"
+	ConnectionSecretKeyDockerHost = "DOCKER_HOST"
+)
+
+// connectionSecretFiles are copied as is from the docker-machine store directory.
+var connectionSecretFiles = []string{
+	ConnectionSecretKeyCACert,
+	ConnectionSecretKeyClientCert,
+	ConnectionSecretKeyClientKey,
+	ConnectionSecretKeySSHKey,
+}
+
+// writeConnectionSecret publishes the docker TLS credentials and the SSH key of
+// the Machine in the Secret referenced by spec.writeConnectionSecretToRef. It runs
+// on every reconcile, so regenerated certificates are picked up. The Secret is
+// owned by the Machine and garbage collected with it. A Secret that exists but
+// is not controlled by the Machine is left alone.
+func (r *machineRequest) writeConnectionSecret() error {
+	ref := r.machineObj.Spec.WriteConnectionSecretToRef
+	if ref == nil || ref.Name == "" {
+		return nil
+	}
+	conn := r.machineObj.Status.Connection
+	if conn == nil || conn.DockerURL == "" {
+		// the docker host is not known yet
+		return nil
+	}
+
+	data, err := connectionSecretData(r.machineStoreDir(), conn.DockerURL)
+	if err != nil {
+		return err
+	}
+
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ref.Name,
+			Namespace: r.machineObj.Namespace,
+		},
+	}
+	var existing core.Secret
+	err = r.KBClient.Get(r.ctx, client.ObjectKeyFromObject(secret), &existing)
+	switch {
+	case err == nil && !metav1.IsControlledBy(&existing, r.machineObj):
+		return fmt.Errorf("secret %s/%s exists and is not controlled by the Machine", secret.Namespace, secret.Name)
+	case client.IgnoreNotFound(err) != nil:
+		return err
+	}
+
+	var ownerErr error
+	_, err = cu.CreateOrPatch(r.ctx, r.KBClient, secret, func(object client.Object, createOp bool) client.Object {
+		s := object.(*core.Secret)
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+		s.Labels[machineLabel] = r.machineObj.Name
+		s.Type = core.SecretTypeOpaque
+		s.Data = data
+		if createOp {
+			ownerErr = controllerutil.SetControllerReference(r.machineObj, s, r.Scheme)
+		}
+		return s
+	})
+	if err != nil {
+		return err
+	}
+	return ownerErr
+}
+
+func connectionSecretData(storeDir, dockerURL string) (map[string][]byte, error) {
+	u, err := url.Parse(dockerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker url %q: %w", dockerURL, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("docker url %q has no host", dockerURL)
+	}
+
+	store, err := readMachineStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{
+		ConnectionSecretKeyHost:       []byte(u.Hostname()),
+		ConnectionSecretKeyPort:       []byte(u.Port()),
+		ConnectionSecretKeyDockerHost: []byte(dockerURL),
+	}
+	for _, name := range connectionSecretFiles {
+		content, ok := store[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is missing in the docker-machine store %s", name, storeDir)
+		}
+		data[name] = content
+	}
+	return data, nil
+}
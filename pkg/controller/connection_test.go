@@ -0,0 +1,79 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnectionSecretData(t *testing.T) {
+	dir := t.TempDir()
+	store := map[string][]byte{
+		"ca.pem":      []byte("ca"),
+		"cert.pem":    []byte("cert"),
+		"key.pem":     []byte("key"),
+		"id_rsa":      []byte("ssh-key"),
+		"server.pem":  []byte("server"),
+		"config.json": []byte("{}"),
+	}
+	if err := writeMachineStore(dir, store); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := connectionSecretData(dir, "tcp://203.0.113.10:2376")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		ConnectionSecretKeyCACert:     "ca",
+		ConnectionSecretKeyClientCert: "cert",
+		ConnectionSecretKeyClientKey:  "key",
+		ConnectionSecretKeySSHKey:     "ssh-key",
+		ConnectionSecretKeyHost:       "203.0.113.10",
+		ConnectionSecretKeyPort:       "2376",
+		ConnectionSecretKeyDockerHost: "tcp://203.0.113.10:2376",
+	}
+	if len(data) != len(want) {
+		t.Errorf("got keys %v, want %v", data, want)
+	}
+	for k, v := range want {
+		if string(data[k]) != v {
+			t.Errorf("%s = %q, want %q", k, data[k], v)
+		}
+	}
+
+	// regenerated certificates are picked up
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("cert-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err = connectionSecretData(dir, "tcp://203.0.113.10:2376")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data[ConnectionSecretKeyClientCert]); got != "cert-2" {
+		t.Errorf("cert.pem = %q, want regenerated certificate", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "id_rsa")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connectionSecretData(dir, "tcp://203.0.113.10:2376"); err == nil {
+		t.Error("expected an error for a missing ssh key")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const googleCredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// credentialEnvVars maps auth Secret keys to the environment variables read by
+// the drivers, where they differ from the upper snake case of the key.
+var credentialEnvVars = map[string]string{
+	awsAccessKeyField:         "AWS_ACCESS_KEY_ID",
+	awsSecretKeyField:         "AWS_SECRET_ACCESS_KEY",
+	"amazonec2-session-token": "AWS_SESSION_TOKEN",
+}
+
+// driverCredentials holds the credentials of a Machine in the form they are
+// handed to docker-machine: environment variables and files only readable by
+// the command, both referencing the auth Secret. Credentials are never passed
+// as command line arguments, which are visible to every process on the host.
+type driverCredentials struct {
+	env   []executor.EnvVar
+	files []executor.SecretFile
+}
+
+// credentialEnvName returns the environment variable that holds the auth
+// Secret key, e.g. azure-client-secret is read from AZURE_CLIENT_SECRET.
+func credentialEnvName(key string) string {
+	if name, ok := credentialEnvVars[key]; ok {
+		return name
+	}
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// newDriverCredentials converts the auth Secret for the driver. The google
+// driver reads its service account key from the file in GOOGLE_APPLICATION_CREDENTIALS.
+func newDriverCredentials(driver string, secret *core.Secret) (*driverCredentials, error) {
+	keys := make([]string, 0, len(secret.Data))
+	for key, value := range secret.Data {
+		if len(key) == 0 || len(value) == 0 {
+			return nil, fmt.Errorf("auth secret not found")
+		}
+		keys = append(keys, key)
+	}
+	// keep the command stable, it identifies the Job of the command
+	sort.Strings(keys)
+
+	creds := &driverCredentials{}
+	for _, key := range keys {
+		ref := executor.SecretKeyRef{Namespace: secret.Namespace, Name: secret.Name, Key: key}
+		if driver != GoogleDriver {
+			creds.env = append(creds.env, executor.EnvVar{Name: credentialEnvName(key), SecretRef: &ref})
+			continue
+		}
+		if len(creds.files) > 0 {
+			return nil, fmt.Errorf("auth secret for the google driver must hold a single service account key")
+		}
+		creds.files = append(creds.files, executor.SecretFile{Env: googleCredentialsEnv, SecretRef: ref})
+	}
+	return creds, nil
+}
+
+// authSecretKeys are the keys the auth Secret must hold for a driver.
+var authSecretKeys = map[string][]string{
+	AWSDriver:   {awsAccessKeyField, awsSecretKeyField},
+	AzureDriver: {azureSubscriptionIDKeyField, azureTenantIDKeyField, azureClientIDKeyField, azureClientSecretKeyField},
+}
+
+// ValidateAuthSecret checks that the auth Secret holds the credentials of the driver.
+func ValidateAuthSecret(driver string, secret *core.Secret) error {
+	var missing []string
+	for _, key := range authSecretKeys[driver] {
+		if len(secret.Data[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("auth secret %s/%s is missing %s for the %s driver", secret.Namespace, secret.Name, strings.Join(missing, ", "), driver)
+	}
+	if len(secret.Data) == 0 {
+		return fmt.Errorf("auth secret %s/%s is empty", secret.Namespace, secret.Name)
+	}
+	for key, value := range secret.Data {
+		if len(value) == 0 {
+			return fmt.Errorf("key %s of auth secret %s/%s is empty", key, secret.Namespace, secret.Name)
+		}
+	}
+	_, err := newDriverCredentials(driver, secret)
+	return err
+}
+
+// getDriverCredentials reads the auth Secret of the Machine.
+func (r *machineRequest) getDriverCredentials() (*driverCredentials, error) {
+	authSecret, err := r.getSecret(r.machineObj.Spec.AuthSecret)
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			r.Log.Info("auth secret is not ready yet", "name", r.machineObj.Spec.AuthSecret)
+		} else {
+			r.Log.Error(err, "error in auth secret", "name", r.machineObj.Spec.AuthSecret)
+		}
+		return nil, err
+	}
+	for _, v := range authSecret.Data {
+		r.redactor.add(string(v))
+	}
+	return newDriverCredentials(r.machineObj.Spec.Driver.Name, &authSecret)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCredentialEnvName(t *testing.T) {
+	tests := map[string]string{
+		"amazonec2-access-key":      "AWS_ACCESS_KEY_ID",
+		"amazonec2-secret-key":      "AWS_SECRET_ACCESS_KEY",
+		"azure-client-secret":       "AZURE_CLIENT_SECRET",
+		"azure-subscription-id":     "AZURE_SUBSCRIPTION_ID",
+		"digitalocean-access-token": "DIGITALOCEAN_ACCESS_TOKEN",
+	}
+	for key, want := range tests {
+		if got := credentialEnvName(key); got != want {
+			t.Errorf("credentialEnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestDriverCredentialsUseEnv(t *testing.T) {
+	creds, err := newDriverCredentials(AWSDriver, &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "aws-cred"},
+		Data: map[string][]byte{
+			"amazonec2-secret-key": []byte("wJalrXUtnFEMI/K7MDENG"),
+			"amazonec2-access-key": []byte("AKIAEXAMPLE"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []executor.EnvVar{
+		{Name: "AWS_ACCESS_KEY_ID", SecretRef: &executor.SecretKeyRef{Namespace: "demo", Name: "aws-cred", Key: "amazonec2-access-key"}},
+		{Name: "AWS_SECRET_ACCESS_KEY", SecretRef: &executor.SecretKeyRef{Namespace: "demo", Name: "aws-cred", Key: "amazonec2-secret-key"}},
+	}
+	if !reflect.DeepEqual(creds.env, want) {
+		t.Errorf("env = %+v, want %+v", creds.env, want)
+	}
+	if len(creds.files) != 0 {
+		t.Errorf("unexpected credential files %v", creds.files)
+	}
+}
+
+func TestGoogleDriverCredentialsUseFile(t *testing.T) {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "gcp-cred"},
+		Data:       map[string][]byte{"google-auth-encoded-json": []byte(`{"type":"service_account"}`)},
+	}
+	creds, err := newDriverCredentials(GoogleDriver, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []executor.SecretFile{
+		{Env: googleCredentialsEnv, SecretRef: executor.SecretKeyRef{Namespace: "demo", Name: "gcp-cred", Key: "google-auth-encoded-json"}},
+	}
+	if !reflect.DeepEqual(creds.files, want) || len(creds.env) != 0 {
+		t.Errorf("files = %+v, env = %+v, want files %+v", creds.files, creds.env, want)
+	}
+
+	secret.Data["project"] = []byte("demo")
+	if _, err := newDriverCredentials(GoogleDriver, secret); err == nil {
+		t.Error("expected an error for more than one google credential")
+	}
+}
+
+func TestDriverCredentialsRejectEmptyValues(t *testing.T) {
+	secret := &core.Secret{Data: map[string][]byte{"amazonec2-access-key": nil}}
+	if _, err := newDriverCredentials(AWSDriver, secret); err == nil {
+		t.Error("expected an error for an empty credential")
+	}
+}
+
+func TestValidateAuthSecret(t *testing.T) {
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "azure-cred"},
+		Data: map[string][]byte{
+			"azure-subscription-id": []byte("sub"),
+			"azure-tenant-id":       []byte("tenant"),
+		},
+	}
+	err := ValidateAuthSecret(AzureDriver, secret)
+	if err == nil || !strings.Contains(err.Error(), "azure-client-id, azure-client-secret") {
+		t.Errorf("expected the missing azure keys, got %v", err)
+	}
+
+	secret.Data["azure-client-id"] = []byte("client")
+	secret.Data["azure-client-secret"] = []byte("secret")
+	if err := ValidateAuthSecret(AzureDriver, secret); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
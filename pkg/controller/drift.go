@@ -0,0 +1,129 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+// createArgsHash identifies the create arguments of a Machine by the fields of
+// the spec they are rendered from. The Driver schema and the defaults of the
+// operator are not part of it, so that deleting the Driver or upgrading the
+// operator does not replace the machine. Neither are the credentials, rotating
+// them does not replace the machine either.
+func createArgsHash(spec *api.MachineSpec) string {
+	in := struct {
+		Driver     string                 `json:"driver"`
+		Parameters map[string]string      `json:"parameters,omitempty"`
+		Flags      []api.MachineFlag      `json:"flags,omitempty"`
+		ScriptRef  *kmapi.ObjectReference `json:"scriptRef,omitempty"`
+		ScriptKey  string                 `json:"scriptKey,omitempty"`
+	}{
+		Parameters: spec.Parameters,
+		Flags:      spec.Flags,
+		ScriptRef:  spec.ScriptRef,
+		ScriptKey:  spec.ScriptKey,
+	}
+	if spec.Driver != nil {
+		in.Driver = spec.Driver.Name
+	}
+	data, _ := json.Marshal(in)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// reconcileSpecDrift compares the create arguments of a created machine with the
+// ones its spec renders now, see createArgsHash, and replaces the machine if spec.updateStrategy is
+// Recreate. It returns true once the machine is being replaced.
+func (r *machineRequest) reconcileSpecDrift() (bool, error) {
+	hash := createArgsHash(&r.machineObj.Spec)
+	switch r.machineObj.Status.CreateArgsHash {
+	case "":
+		// created before the hash was recorded
+		r.machineObj.Status.CreateArgsHash = hash
+		fallthrough
+	case hash:
+		r.machineObj.Status.Conditions = cutil.RemoveCondition(r.machineObj.Status.Conditions, string(api.MachineConditionTypeSpecDrifted))
+		return false, nil
+	}
+
+	replace := r.machineObj.Spec.UpdateStrategy == api.MachineUpdateStrategyRecreate
+	msg := "create arguments changed, delete the Machine or set spec.updateStrategy to Recreate to apply them"
+	if replace {
+		msg = "create arguments changed, the machine is replaced"
+	}
+	if !cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeSpecDrifted)) {
+		r.warning(EventReasonSpecDrifted, "Machine was created with other arguments, its spec has changed")
+	}
+	cutil.Set(r.machineObj, &kmapi.Condition{
+		Type:    api.MachineConditionTypeSpecDrifted,
+		Status:  metav1.ConditionTrue,
+		Reason:  api.ReasonCreateArgsChanged,
+		Message: msg,
+	})
+	if !replace {
+		return false, nil
+	}
+
+	if err := r.startReplaceOperation(); err != nil {
+		return false, err
+	}
+	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineReplacing, kmapi.ConditionSeverityInfo,
+		"removing the machine to create it with the changed spec")
+	r.event(core.EventTypeNormal, EventReasonReplacing, "Replacing machine to apply the changed spec")
+	return true, nil
+}
+
+// startReplaceOperation removes the machine along with its cloud resources.
+func (r *machineRequest) startReplaceOperation() error {
+	return r.startOperation(api.MachineOperationReplace, machineDeletionTimeout, func(op *machineRequest) error {
+		return op.cleanupMachineResources()
+	})
+}
+
+// checkReplaceOperation forgets the removed machine and its cloud resources,
+// so that createMachine creates them again.
+func (r *machineRequest) checkReplaceOperation() (bool, error) {
+	state, err := r.operationResult()
+	switch state {
+	case operationRunning:
+		return true, nil
+	case operationUnknown:
+		// interrupted by an operator restart, the cleanup is idempotent and started again
+		r.machineObj.Status.Operation = nil
+		return true, nil
+	case operationFailed:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineReplacing, kmapi.ConditionSeverityError,
+			"failed to remove the machine, retrying: %v", err)
+		r.warning(EventReasonReplaceFailed, "Failed to remove the machine: %v", err)
+		return false, err
+	}
+
+	if err := r.removeAnnotations(awsVPCIDAnnotation, awsSubnetIDAnnotation, awsInternetGatewayIDAnnotation); err != nil {
+		return false, err
+	}
+	return true, r.forgetMachine("creating the machine with the changed spec")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestCreateArgsHash(t *testing.T) {
+	spec := &api.MachineSpec{
+		Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+		ScriptRef:  &kmapi.ObjectReference{Namespace: "demo", Name: "script"},
+		Parameters: map[string]string{"google-project": "demo", "google-zone": "us-central1-a"},
+	}
+	hash := createArgsHash(spec)
+	if got := createArgsHash(spec.DeepCopy()); got != hash {
+		t.Errorf("createArgsHash() = %s for the same spec, want %s", got, hash)
+	}
+
+	changes := map[string]func(*api.MachineSpec){
+		"parameter": func(s *api.MachineSpec) { s.Parameters["google-zone"] = "europe-west1-b" },
+		"flag":      func(s *api.MachineSpec) { s.Flags = []api.MachineFlag{{Name: "engine-opt", Values: []string{"a=b"}}} },
+		"script":    func(s *api.MachineSpec) { s.ScriptRef.Name = "other" },
+		"scriptKey": func(s *api.MachineSpec) { s.ScriptKey = "userdata" },
+		"driver":    func(s *api.MachineSpec) { s.Driver.Name = AWSDriver },
+	}
+	for name, change := range changes {
+		other := spec.DeepCopy()
+		change(other)
+		if got := createArgsHash(other); got == hash {
+			t.Errorf("createArgsHash() did not change with the %s", name)
+		}
+	}
+
+	other := spec.DeepCopy()
+	other.AuthSecret = &kmapi.ObjectReference{Namespace: "demo", Name: "rotated"}
+	if got := createArgsHash(other); got != hash {
+		t.Error("createArgsHash() changed with the credentials")
+	}
+}
+
+// TestCreateArgsHashOperatorState checks that the hash does not depend on the
+// state of the operator the create arguments are also rendered from.
+func TestCreateArgsHashOperatorState(t *testing.T) {
+	machine := &api.Machine{
+		Spec: api.MachineSpec{
+			Driver:     &core.LocalObjectReference{Name: AWSDriver},
+			Parameters: map[string]string{AWSRegionParam: "us-east-1", "amazonec2-use-private-address": "true"},
+		},
+	}
+	schema := []api.DriverParameter{{Name: "amazonec2-use-private-address", Type: api.DriverParameterTypeBool}}
+	args := machineCreateArgs(machine, schema, "")
+	hash := createArgsHash(&machine.Spec)
+
+	// the Driver is deleted
+	if reflect.DeepEqual(machineCreateArgs(machine, nil, ""), args) {
+		t.Fatal("the rendered arguments do not depend on the Driver schema, the test is void")
+	}
+	if got := createArgsHash(&machine.Spec); got != hash {
+		t.Errorf("createArgsHash() = %s without the Driver, want %s", got, hash)
+	}
+
+	// an operator upgrade changes the default AMI
+	old := amiIDs["us-east-1"]
+	amiIDs["us-east-1"] = "ami-upgraded"
+	defer func() { amiIDs["us-east-1"] = old }()
+	if reflect.DeepEqual(machineCreateArgs(machine, schema, ""), args) {
+		t.Fatal("the rendered arguments do not depend on the default AMI, the test is void")
+	}
+	if got := createArgsHash(&machine.Spec); got != hash {
+		t.Errorf("createArgsHash() = %s with another default AMI, want %s", got, hash)
+	}
+}
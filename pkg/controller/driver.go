@@ -0,0 +1,143 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	driverBinaryPrefix = "docker-machine-driver-"
+	checksumPrefix     = "sha256:"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+func driverBinaryName(name string) string {
+	return driverBinaryPrefix + name
+}
+
+// normalizeChecksum strips the optional "sha256:" prefix and lower cases the digest.
+func normalizeChecksum(checksum string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), checksumPrefix))
+}
+
+// fileChecksum returns the hex encoded sha256 digest of the file at path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() // nolint:errcheck
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadDriver fetches the driver binary from url into a temporary file inside dir.
+// It returns the path of the temporary file and the sha256 digest of its content.
+// The caller is responsible for removing the temporary file.
+func downloadDriver(ctx context.Context, c *http.Client, url, dir, name string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+	tmp, err := os.CreateTemp(dir, "."+driverBinaryName(name)+"-*")
+	if err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksum compares the digest of a downloaded binary with the expected one.
+func verifyChecksum(expected, actual string) error {
+	if normalizeChecksum(expected) != normalizeChecksum(actual) {
+		return fmt.Errorf("%w: expected %s, got %s", errChecksumMismatch, normalizeChecksum(expected), actual)
+	}
+	return nil
+}
+
+// installDriver makes the downloaded binary executable and atomically moves it to
+// its final location in dir. It returns the installed path.
+func installDriver(tmpPath, dir, name string) (string, error) {
+	if err := os.Chmod(tmpPath, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, driverBinaryName(name))
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// isDriverInstalled checks whether the binary at path exists and matches checksum.
+func isDriverInstalled(path, checksum string) bool {
+	if path == "" {
+		return false
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return false
+	}
+	return verifyChecksum(checksum, sum) == nil
+}
+
+// namespaceDriverDir returns the directory below dir that holds the drivers of
+// namespace. Drivers of the same name in different namespaces do not overwrite
+// each other, and docker-machine only finds the drivers of the namespace of a
+// Machine.
+func namespaceDriverDir(dir, namespace string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, namespace)
+}
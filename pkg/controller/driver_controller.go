@@ -18,45 +18,205 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
 
-	dockermachinev1alpha1 "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
 
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+	"kmodules.xyz/client-go/conditions/committer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+const (
+	// DefaultDriverDir is the directory where non-builtin driver binaries are installed.
+	DefaultDriverDir      = "/tmp/docker-machine-operator/drivers"
+	driverDownloadTimeout = 10 * time.Minute
+)
+
 // DriverReconciler reconciles a Driver object
 type DriverReconciler struct {
 	KBClient client.Client
 	Scheme   *runtime.Scheme
+	// DriverDir is the directory where driver binaries are installed, in a
+	// subdirectory per namespace.
+	DriverDir string
+	// HTTPClient is used to download driver binaries. http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+	// Commands runs docker-machine to discover the parameters of installed drivers.
+	// A LocalExecutor is used if nil.
+	Commands executor.CommandExecutor
+	// Recorder records the events of Drivers. A recorder of the manager is used if nil.
+	Recorder record.EventRecorder
+
+	committer func(ctx context.Context, old, obj committer.StatusGetter[*api.DriverStatus]) error
 }
 
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=drivers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=drivers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=drivers/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Driver object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile downloads the docker-machine-driver-<name> binary of a non-builtin
+// Driver from its DownloadURL, verifies it against the configured checksum and
+// installs it into the directory of its namespace below DriverDir. Builtin drivers are shipped with docker-machine
+// and are marked ready right away.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
 func (r *DriverReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
 
-	// TODO(user): your logic here
+	driver := &api.Driver{}
+	if err := r.KBClient.Get(ctx, req.NamespacedName, driver); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	old := driver.DeepCopy()
+
+	var err error
+	if driver.Spec.Builtin {
+		r.markBuiltin(driver)
+	} else {
+		err = r.ensureDriverInstalled(ctx, logger, driver, old)
+	}
+	if err == nil && cutil.IsConditionTrue(driver.Status.Conditions, string(api.DriverConditionTypeDriverInstalled)) {
+		r.discoverParameters(ctx, logger, driver)
+	}
+
+	if updErr := r.updateDriverStatus(ctx, old, driver); updErr != nil {
+		return ctrl.Result{}, updErr
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		// the same binary will be served again, wait for the spec to change
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, err
+}
 
-	return ctrl.Result{}, nil
+func (r *DriverReconciler) markBuiltin(driver *api.Driver) {
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverDownloaded)
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverVerified)
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverInstalled)
+	driver.Status.InstalledPath = ""
+	driver.Status.Checksum = ""
+	driver.Status.Version = driver.Spec.Version
+}
+
+func (r *DriverReconciler) ensureDriverInstalled(ctx context.Context, logger logr.Logger, driver, old *api.Driver) error {
+	if driver.Spec.DownloadURL == "" {
+		cutil.MarkFalse(driver, api.DriverConditionTypeDriverDownloaded, api.ReasonDownloadURLMissing, kmapi.ConditionSeverityError,
+			"downloadURL is required for non-builtin driver")
+		return nil
+	}
+	if driver.Spec.Checksum == "" {
+		cutil.MarkFalse(driver, api.DriverConditionTypeDriverVerified, api.ReasonChecksumMissing, kmapi.ConditionSeverityError,
+			"checksum is required for non-builtin driver")
+		return nil
+	}
+
+	checksum := normalizeChecksum(driver.Spec.Checksum)
+	if driver.Status.Checksum == checksum && isDriverInstalled(driver.Status.InstalledPath, checksum) {
+		driver.Status.Version = driver.Spec.Version
+		return nil
+	}
+
+	cutil.MarkFalse(driver, api.DriverConditionTypeDriverDownloaded, api.ReasonDriverDownloading, kmapi.ConditionSeverityInfo,
+		"Downloading driver from %s", driver.Spec.DownloadURL)
+	cutil.MarkFalse(driver, api.DriverConditionTypeDriverVerified, api.ReasonDriverDownloading, kmapi.ConditionSeverityInfo,
+		"Waiting for driver download")
+	cutil.MarkFalse(driver, api.DriverConditionTypeDriverInstalled, api.ReasonDriverDownloading, kmapi.ConditionSeverityInfo,
+		"Waiting for driver download")
+	cutil.MarkFalse(driver, api.DriverConditionTypeParametersDiscovered, api.ReasonDriverDownloading, kmapi.ConditionSeverityInfo,
+		"Waiting for driver download")
+	driver.Status.Parameters = nil
+	if err := r.updateDriverStatus(ctx, old, driver); err != nil {
+		return err
+	}
+	old.Status = *driver.Status.DeepCopy()
+
+	logger.Info("Downloading driver", "Name", driver.Name, "URL", driver.Spec.DownloadURL)
+	r.event(driver, core.EventTypeNormal, EventReasonDownloading, "Downloading driver from %s", driver.Spec.DownloadURL)
+	downloadCtx, cancel := context.WithTimeout(ctx, driverDownloadTimeout)
+	defer cancel()
+	dir := namespaceDriverDir(r.DriverDir, driver.Namespace)
+	tmpPath, sum, err := downloadDriver(downloadCtx, r.httpClient(), driver.Spec.DownloadURL, dir, driver.Name)
+	if err != nil {
+		cutil.MarkFalse(driver, api.DriverConditionTypeDriverDownloaded, api.ReasonDriverDownloadFailed, kmapi.ConditionSeverityError,
+			"failed to download driver. err: %s", err.Error())
+		r.event(driver, core.EventTypeWarning, EventReasonDownloadFailed, "Failed to download driver: %v", err)
+		return err
+	}
+	defer os.Remove(tmpPath) // nolint:errcheck
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverDownloaded)
+
+	if err := verifyChecksum(checksum, sum); err != nil {
+		logger.Info("Driver checksum mismatch", "Name", driver.Name, "Expected", checksum, "Actual", sum)
+		cutil.MarkFalse(driver, api.DriverConditionTypeDriverVerified, api.ReasonChecksumMismatch, kmapi.ConditionSeverityError,
+			"%s", err.Error())
+		r.event(driver, core.EventTypeWarning, EventReasonChecksumMismatch, "Driver checksum %s does not match the expected %s", sum, checksum)
+		return err
+	}
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverVerified)
+
+	path, err := installDriver(tmpPath, dir, driver.Name)
+	if err != nil {
+		cutil.MarkFalse(driver, api.DriverConditionTypeDriverInstalled, api.ReasonDriverInstallFailed, kmapi.ConditionSeverityError,
+			"failed to install driver. err: %s", err.Error())
+		r.event(driver, core.EventTypeWarning, EventReasonInstallFailed, "Failed to install driver: %v", err)
+		return err
+	}
+	cutil.MarkTrue(driver, api.DriverConditionTypeDriverInstalled)
+
+	driver.Status.InstalledPath = path
+	driver.Status.Checksum = checksum
+	driver.Status.Version = driver.Spec.Version
+	logger.Info("Installed driver", "Name", driver.Name, "Path", path)
+	r.event(driver, core.EventTypeNormal, EventReasonInstalled, "Installed driver %s at %s", driver.Spec.Version, path)
+	return nil
+}
+
+func (r *DriverReconciler) updateDriverStatus(ctx context.Context, old, driver *api.Driver) error {
+	driver.Status.ObservedGeneration = driver.Generation
+	cutil.SetSummary(driver, cutil.WithConditions(api.DriverConditionsOrder()...))
+	driver.Status.Phase = api.GetDriverPhase(driver)
+	return r.committer(ctx, old, driver)
+}
+
+func (r *DriverReconciler) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (r *DriverReconciler) commands() executor.CommandExecutor {
+	if r.Commands != nil {
+		return r.Commands
+	}
+	return &executor.LocalExecutor{}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DriverReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.DriverDir == "" {
+		r.DriverDir = DefaultDriverDir
+	}
+	r.committer = committer.NewStatusCommitter[*api.Driver, *api.DriverStatus](r.KBClient.Status())
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("driver-controller")
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&dockermachinev1alpha1.Driver{}).
+		For(&api.Driver{}).
 		Complete(r)
 }
@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testDriverBinary = []byte("#!/bin/sh\necho docker-machine-driver-test\n")
+
+func testDriverServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/docker-machine-driver-test", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(testDriverBinary)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testDriverChecksum() string {
+	sum := sha256.Sum256(testDriverBinary)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDownloadAndInstallDriver(t *testing.T) {
+	srv := testDriverServer(t)
+	dir := t.TempDir()
+
+	tmpPath, sum, err := downloadDriver(context.Background(), srv.Client(), srv.URL+"/docker-machine-driver-test", dir, "test")
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if err := verifyChecksum(checksumPrefix+testDriverChecksum(), sum); err != nil {
+		t.Fatalf("unexpected checksum error: %v", err)
+	}
+
+	path, err := installDriver(tmpPath, dir, "test")
+	if err != nil {
+		t.Fatalf("install failed: %v", err)
+	}
+	if want := filepath.Join(dir, "docker-machine-driver-test"); path != want {
+		t.Errorf("installed path = %s, want %s", path, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		t.Errorf("installed driver is not executable: %v", info.Mode())
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("temporary file %s was not moved", tmpPath)
+	}
+	if !isDriverInstalled(path, testDriverChecksum()) {
+		t.Errorf("driver should be reported as installed")
+	}
+	if isDriverInstalled(path, "deadbeef") {
+		t.Errorf("driver with a different checksum should not be reported as installed")
+	}
+}
+
+func TestDownloadDriverChecksumMismatch(t *testing.T) {
+	srv := testDriverServer(t)
+	dir := t.TempDir()
+
+	tmpPath, sum, err := downloadDriver(context.Background(), srv.Client(), srv.URL+"/docker-machine-driver-test", dir, "test")
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	defer os.Remove(tmpPath) // nolint:errcheck
+
+	err = verifyChecksum("0000000000000000000000000000000000000000000000000000000000000000", sum)
+	if !errors.Is(err, errChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestDownloadDriverNotFound(t *testing.T) {
+	srv := testDriverServer(t)
+	dir := t.TempDir()
+
+	if _, _, err := downloadDriver(context.Background(), srv.Client(), srv.URL+"/missing", dir, "test"); err == nil {
+		t.Fatal("expected download of a missing binary to fail")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("failed download left files behind: %v", entries)
+	}
+}
+
+func TestNamespaceDriverDir(t *testing.T) {
+	if got, want := namespaceDriverDir("/drivers", "demo"), filepath.Join("/drivers", "demo"); got != want {
+		t.Errorf("namespaceDriverDir() = %s, want %s", got, want)
+	}
+	if namespaceDriverDir("/drivers", "demo") == namespaceDriverDir("/drivers", "other") {
+		t.Error("drivers of different namespaces share a directory")
+	}
+	if got := namespaceDriverDir("", "demo"); got != "" {
+		t.Errorf("namespaceDriverDir() = %s, want an empty directory", got)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+)
+
+// maxEventMessageLength keeps event messages, e.g. with docker-machine output, within the limits of the API server.
+const maxEventMessageLength = 1024
+
+// Reasons of the events of Machines.
+const (
+	EventReasonCreating               = "Creating"
+	EventReasonCreated                = "Created"
+	EventReasonCreateFailed           = "CreateFailed"
+	EventReasonAdopted                = "Adopted"
+	EventReasonDeleting               = "Deleting"
+	EventReasonDeleted                = "Deleted"
+	EventReasonDeleteFailed           = "DeleteFailed"
+	EventReasonDriverNotReady         = "DriverNotReady"
+	EventReasonMachineClassNotFound   = "MachineClassNotFound"
+	EventReasonInvalidParameters      = "InvalidParameters"
+	EventReasonAuthDataNotFound       = "AuthDataNotFound"
+	EventReasonScriptDataNotFound     = "ScriptDataNotFound"
+	EventReasonScriptSucceeded        = "ScriptSucceeded"
+	EventReasonScriptFailed           = "ScriptFailed"
+	EventReasonVPCCreated             = "VPCCreated"
+	EventReasonVPCDeleted             = "VPCDeleted"
+	EventReasonSubnetCreated          = "SubnetCreated"
+	EventReasonSubnetDeleted          = "SubnetDeleted"
+	EventReasonInternetGatewayCreated = "InternetGatewayCreated"
+	EventReasonInternetGatewayDeleted = "InternetGatewayDeleted"
+	EventReasonNetworkFailed          = "NetworkFailed"
+	EventReasonResourceGroupDeleted   = "ResourceGroupDeleted"
+	EventReasonStarting               = "Starting"
+	EventReasonStarted                = "Started"
+	EventReasonStopping               = "Stopping"
+	EventReasonStopped                = "Stopped"
+	EventReasonRestarting             = "Restarting"
+	EventReasonRestarted              = "Restarted"
+	EventReasonPowerOperationFailed   = "PowerOperationFailed"
+	EventReasonPowerStateChanged      = "PowerStateChanged"
+	EventReasonMachineUnhealthy       = "MachineUnhealthy"
+	EventReasonMachineRecovered       = "MachineRecovered"
+	EventReasonRecreating             = "Recreating"
+	EventReasonRecreateFailed         = "RecreateFailed"
+	EventReasonSpecDrifted            = "SpecDrifted"
+	EventReasonReplacing              = "Replacing"
+	EventReasonReplaceFailed          = "ReplaceFailed"
+)
+
+// Reasons of the events of Drivers.
+const (
+	EventReasonDownloading          = "Downloading"
+	EventReasonDownloadFailed       = "DownloadFailed"
+	EventReasonChecksumMismatch     = "ChecksumMismatch"
+	EventReasonInstalled            = "Installed"
+	EventReasonInstallFailed        = "InstallFailed"
+	EventReasonParametersDiscovered = "ParametersDiscovered"
+	EventReasonDiscoveryFailed      = "DiscoveryFailed"
+)
+
+// Reasons of the events of MachineSets.
+const (
+	EventReasonSuccessfulCreate = "SuccessfulCreate"
+	EventReasonFailedCreate     = "FailedCreate"
+	EventReasonSuccessfulDelete = "SuccessfulDelete"
+	EventReasonFailedDelete     = "FailedDelete"
+)
+
+// Reasons of the events of MachineDeployments.
+const (
+	EventReasonScalingMachineSet        = "ScalingMachineSet"
+	EventReasonRolledBack               = "RolledBack"
+	EventReasonRollbackRevisionNotFound = "RollbackRevisionNotFound"
+)
+
+// truncateMessage shortens s to maxEventMessageLength by cutting out its middle,
+// the end of a command output usually tells what went wrong.
+func truncateMessage(s string) string {
+	const marker = " ... "
+	if len(s) <= maxEventMessageLength {
+		return s
+	}
+	keep := (maxEventMessageLength - len(marker)) / 2
+	return s[:keep] + marker + s[len(s)-keep:]
+}
+
+// event records an event for the Machine. The message is redacted and truncated.
+func (r *machineRequest) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	msg := fmt.Sprintf(messageFmt, args...)
+	if r.redactor != nil {
+		msg = r.redactor.redact(msg)
+	}
+	r.Recorder.Event(r.machineObj, eventType, reason, truncateMessage(msg))
+}
+
+// warning records a Warning event for the Machine.
+func (r *machineRequest) warning(reason, messageFmt string, args ...interface{}) {
+	r.event(core.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// event records an event for the Driver. The message is truncated.
+func (r *DriverReconciler) event(driver *api.Driver, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(driver, eventType, reason, truncateMessage(fmt.Sprintf(messageFmt, args...)))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	short := "docker-machine create failed"
+	if got := truncateMessage(short); got != short {
+		t.Errorf("truncateMessage(%q) = %q", short, got)
+	}
+
+	long := "begin" + strings.Repeat("x", 2*maxEventMessageLength) + "end"
+	got := truncateMessage(long)
+	if len(got) > maxEventMessageLength {
+		t.Errorf("truncated message has %d bytes, want at most %d", len(got), maxEventMessageLength)
+	}
+	if !strings.HasPrefix(got, "begin") || !strings.HasSuffix(got, "end") || !strings.Contains(got, " ... ") {
+		t.Errorf("truncated message does not keep the head and the tail: %q...", got[:32])
+	}
+}
+
+func TestMachineEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &machineRequest{
+		MachineReconciler: &MachineReconciler{Recorder: recorder},
+		machineObj:        &api.Machine{ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "default"}},
+		redactor:          newRedactor(),
+	}
+	r.redactor.add(testSecret)
+
+	r.warning(EventReasonCreateFailed, "Failed to create machine: %v", "invalid key "+testSecret)
+	r.event(core.EventTypeNormal, EventReasonCreated, "Created machine")
+
+	want := []string{
+		"Warning CreateFailed Failed to create machine: invalid key ******",
+		"Normal Created Created machine",
+	}
+	for _, w := range want {
+		select {
+		case got := <-recorder.Events:
+			if got != w {
+				t.Errorf("got event %q, want %q", got, w)
+			}
+		default:
+			t.Fatalf("missing event %q", w)
+		}
+	}
+
+	// events are optional
+	r.Recorder = nil
+	r.warning(EventReasonDeleteFailed, "ignored")
+}
@@ -0,0 +1,168 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	core "k8s.io/api/core/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+// resyncInterval is how often a created Machine is re-queued to check its health
+// and to notice changes of its power state made out of band.
+var resyncInterval = 5 * time.Minute
+
+// powerStateError is reported by docker-machine status when the driver can not get the state of the machine.
+const powerStateError api.MachinePowerState = "Error"
+
+// instanceNotFoundPattern matches the errors the cloud providers return for
+// instances that do not exist anymore: InvalidInstanceID.NotFound of EC2, the
+// 404 of the compute API of GCE and ResourceNotFound of Azure. Other not found
+// errors, e.g. of a driver plugin that is not installed yet, say nothing about
+// the instance.
+var instanceNotFoundPattern = regexp.MustCompile(`InvalidInstanceID\.NotFound|googleapi: Error 404|\bResourceNotFound\b`)
+
+// machineHealth is the outcome of a health check, reason is empty for a healthy machine.
+type machineHealth struct {
+	reason  string
+	message string
+	// lost is true once the instance is gone, spec.remediation applies to lost machines
+	lost bool
+}
+
+// isInstanceNotFound reports whether docker-machine failed because the
+// instance of the machine does not exist at the cloud provider.
+func isInstanceNotFound(err error) bool {
+	var exitErr *executor.ExitError
+	return errors.As(err, &exitErr) && instanceNotFoundPattern.MatchString(exitErr.Stderr)
+}
+
+// checkMachineHealth looks for the machine with docker-machine status and for
+// its instance with the API of the cloud provider, where the operator has a client
+// for it. The drivers of the other providers query the instance for docker-machine status.
+func (r *machineRequest) checkMachineHealth() (machineHealth, error) {
+	state, err := r.queryPowerState()
+	switch {
+	case errors.Is(err, executor.ErrMachineNotFound):
+		return machineHealth{reason: api.ReasonMachineNotFound, message: fmt.Sprintf("machine is not found: %v", err), lost: true}, nil
+	case isInstanceNotFound(err):
+		return machineHealth{reason: api.ReasonInstanceNotFound, message: fmt.Sprintf("instance of the machine is not found: %v", err), lost: true}, nil
+	case err != nil:
+		return machineHealth{}, err
+	case state == powerStateError:
+		return machineHealth{reason: api.ReasonMachineError, message: "docker-machine reports the machine in state Error"}, nil
+	}
+
+	var lost bool
+	switch r.machineObj.Spec.Driver.Name {
+	case AWSDriver:
+		lost, err = r.awsInstanceLost()
+	case AzureDriver:
+		lost, err = r.azureInstanceLost()
+	}
+	if err != nil {
+		return machineHealth{}, err
+	}
+	if lost {
+		return machineHealth{reason: api.ReasonInstanceNotFound, message: "instance of the machine is not found at the cloud provider", lost: true}, nil
+	}
+	return machineHealth{}, nil
+}
+
+// reconcileHealth checks that the instance of a created machine still exists and
+// applies spec.remediation once it is lost. It returns true while a lost machine
+// is being removed to be created again.
+func (r *machineRequest) reconcileHealth() (bool, error) {
+	if op := r.machineObj.Status.Operation; op != nil {
+		if op.Type == api.MachineOperationRecreate {
+			return r.checkRecreateOperation()
+		}
+		// the health is checked again once the power operation in flight has finished
+		return false, nil
+	}
+
+	health, err := r.checkMachineHealth()
+	if err != nil {
+		// a failed check says nothing about the machine, e.g. the credentials lack a permission
+		r.Log.Info("Failed to check the health of the machine", "Error", err.Error())
+		cutil.MarkUnknown(r.machineObj, api.MachineConditionTypeMachineHealthy, api.ReasonHealthCheckFailed, "failed to check the health of the machine: %v", err)
+		return false, nil
+	}
+
+	wasUnhealthy := cutil.IsConditionFalse(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineHealthy))
+	if health.reason == "" {
+		if wasUnhealthy {
+			r.event(core.EventTypeNormal, EventReasonMachineRecovered, "Machine is healthy again")
+		}
+		cutil.MarkTrue(r.machineObj, api.MachineConditionTypeMachineHealthy)
+		return false, nil
+	}
+
+	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineHealthy, health.reason, kmapi.ConditionSeverityError, "%s", health.message)
+	if !wasUnhealthy {
+		r.warning(EventReasonMachineUnhealthy, "%s", health.message)
+	}
+	if !health.lost {
+		return false, nil
+	}
+
+	switch r.machineObj.Spec.Remediation {
+	case api.MachineRemediationRecreate:
+		if err := r.startOperation(api.MachineOperationRecreate, machineDeletionTimeout, func(op *machineRequest) error {
+			err := op.Executor.Remove(op.ctx, op.machineObj, op.executorOptions())
+			if err != nil && !errors.Is(err, executor.ErrMachineNotFound) && !isInstanceNotFound(err) {
+				return err
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		r.event(core.EventTypeNormal, EventReasonRecreating, "Removing the lost machine to create it again")
+		return true, nil
+	case api.MachineRemediationMarkFailed:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineLost, kmapi.ConditionSeverityError, "%s", health.message)
+	}
+	return false, nil
+}
+
+// checkRecreateOperation forgets the removed machine, so that createMachine creates it again.
+func (r *machineRequest) checkRecreateOperation() (bool, error) {
+	state, err := r.operationResult()
+	switch state {
+	case operationRunning:
+		return true, nil
+	case operationUnknown:
+		// interrupted by an operator restart, the removal is started again by the next health check
+		r.machineObj.Status.Operation = nil
+		return true, nil
+	case operationFailed:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineHealthy, api.ReasonRecreateFailed, kmapi.ConditionSeverityError,
+			"failed to remove the lost machine: %v", err)
+		r.warning(EventReasonRecreateFailed, "Failed to remove the lost machine: %v", err)
+		return false, err
+	}
+
+	return true, r.forgetMachine("recreating the lost machine")
+}
@@ -0,0 +1,111 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
+
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+func TestIsInstanceNotFound(t *testing.T) {
+	tests := map[string]bool{
+		"googleapi: Error 404: The resource 'projects/demo/zones/us-central1-a/instances/vm' was not found, notFound":    true,
+		"azure: compute.VirtualMachinesClient#Get: StatusCode=404 Code=\"ResourceNotFound\"":                             true,
+		"Error checking TLS connection: InvalidInstanceID.NotFound: The instance ID 'i-0abc' does not exist":             true,
+		"googleapi: Error 403: Required 'compute.instances.get' permission, forbidden":                                   false,
+		`Driver "vultr" not found. Do you have the plugin binary "docker-machine-driver-vultr" accessible in your PATH?`: false,
+		`exec: "docker-machine-driver-vultr": executable file not found in $PATH`:                                        false,
+		"/bin/sh: docker-machine: command not found":                                                                     false,
+		"open /root/.docker/machine/machines/vm/config.json: no such file or directory, file not found":                  false,
+	}
+	for stderr, want := range tests {
+		if got := isInstanceNotFound(&executor.ExitError{Command: "status", ExitCode: 1, Stderr: stderr}); got != want {
+			t.Errorf("isInstanceNotFound(%q) = %v, want %v", stderr, got, want)
+		}
+	}
+}
+
+func TestReconcileHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		remediation api.MachineRemediationPolicy
+		stderr      string
+		wantReason  string
+		wantReady   string
+		wantOp      api.MachineOperationType
+	}{
+		{
+			name:       "lost machine is only reported",
+			wantReason: api.ReasonMachineNotFound,
+		},
+		{
+			name:        "deleted instance is marked failed",
+			remediation: api.MachineRemediationMarkFailed,
+			stderr:      "googleapi: Error 404: The resource 'instances/vm' was not found, notFound",
+			wantReason:  api.ReasonInstanceNotFound,
+			wantReady:   api.ReasonMachineLost,
+		},
+		{
+			name:        "lost machine is recreated",
+			remediation: api.MachineRemediationRecreate,
+			wantReason:  api.ReasonMachineNotFound,
+			wantOp:      api.MachineOperationRecreate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, f := newFakeExecutorRequest(t)
+			r.machineObj.Spec.Remediation = tt.remediation
+
+			if inProgress, err := r.reconcileHealth(); err != nil || inProgress {
+				t.Fatalf("reconcileHealth() = %v, %v", inProgress, err)
+			}
+			if !cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineHealthy)) {
+				t.Fatal("running machine is not healthy")
+			}
+
+			if tt.stderr != "" {
+				f.PrependReaction(fake.VerbStatus, fake.ExitWith(1, tt.stderr))
+			} else {
+				f.PrependReaction(fake.VerbStatus, fake.Fail(executor.ErrMachineNotFound))
+			}
+			r.powerState = ""
+			inProgress, err := r.reconcileHealth()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reason := cutil.GetReason(r.machineObj, api.MachineConditionTypeMachineHealthy); reason != tt.wantReason {
+				t.Errorf("MachineHealthy reason = %q, want %q", reason, tt.wantReason)
+			}
+			if reason := cutil.GetReason(r.machineObj, api.MachineConditionTypeMachineReady); reason != tt.wantReady {
+				t.Errorf("MachineReady reason = %q, want %q", reason, tt.wantReady)
+			}
+			var op api.MachineOperationType
+			if r.machineObj.Status.Operation != nil {
+				op = r.machineObj.Status.Operation.Type
+			}
+			if op != tt.wantOp || inProgress != (tt.wantOp != "") {
+				t.Errorf("operation = %q, in progress %v, want %q", op, inProgress, tt.wantOp)
+			}
+		})
+	}
+}
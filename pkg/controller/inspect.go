@@ -0,0 +1,124 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+// machineStateRunning is the state reported by docker-machine status for a running machine.
+const machineStateRunning = "Running"
+
+// driver specific keys of the Driver section in `docker-machine inspect`,
+// the first non-empty one wins
+var (
+	instanceIDKeys = []string{"InstanceId", "InstanceID", "DropletID", "ServerID", "MachineId", "VMId"}
+	regionKeys     = []string{"Region", "Location"}
+	zoneKeys       = []string{"Zone", "AvailabilityZone"}
+)
+
+// machineInspect is the part of the `docker-machine inspect` output the operator uses.
+type machineInspect struct {
+	DriverName string                 `json:"DriverName"`
+	Driver     map[string]interface{} `json:"Driver"`
+}
+
+// updateConnectionInfo records the connection details of a created Machine in
+// its status. It is a no-op once the details are known, they are cleared
+// whenever the machine is started again, see reconcilePowerState.
+func (r *machineRequest) updateConnectionInfo() error {
+	if conn := r.machineObj.Status.Connection; conn != nil && conn.DockerURL != "" {
+		return nil
+	}
+
+	out, err := r.Executor.Inspect(r.ctx, r.machineObj, r.executorOptions())
+	if err != nil {
+		return err
+	}
+	conn, err := parseMachineInspect(out)
+	if err != nil {
+		return err
+	}
+
+	// url fails while the docker daemon is not reachable yet, it is retried on the next reconcile
+	if dockerURL, err := r.Executor.URL(r.ctx, r.machineObj, r.executorOptions()); err == nil {
+		conn.DockerURL = dockerURL
+	} else {
+		r.Log.Info("Failed to get docker url of the machine", "Error", err.Error())
+	}
+	if conn.PublicIP == "" && conn.DockerURL != "" {
+		if u, err := url.Parse(conn.DockerURL); err == nil {
+			conn.PublicIP = u.Hostname()
+		}
+	}
+
+	r.machineObj.Status.Connection = conn
+	return nil
+}
+
+func parseMachineInspect(data []byte) (*api.MachineConnection, error) {
+	var info machineInspect
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid docker-machine inspect output: %w", err)
+	}
+
+	conn := &api.MachineConnection{
+		Driver:     info.DriverName,
+		PublicIP:   inspectValue(info.Driver, "IPAddress"),
+		PrivateIP:  inspectValue(info.Driver, "PrivateIPAddress"),
+		SSHUser:    inspectValue(info.Driver, "SSHUser"),
+		InstanceID: inspectValue(info.Driver, instanceIDKeys...),
+		Region:     inspectValue(info.Driver, regionKeys...),
+		Zone:       inspectValue(info.Driver, zoneKeys...),
+	}
+	if port := inspectValue(info.Driver, "SSHPort"); port != "" {
+		p, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh port %q: %w", port, err)
+		}
+		conn.SSHPort = int32(p)
+	}
+	// amazonec2 only keeps the zone letter, e.g. "a"
+	if info.DriverName == AWSDriver && len(conn.Zone) == 1 {
+		conn.Zone = conn.Region + conn.Zone
+	}
+	return conn, nil
+}
+
+func inspectValue(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case json.Number:
+			if v.String() != "0" {
+				return v.String()
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+func TestParseMachineInspect(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    api.MachineConnection
+		wantErr bool
+	}{
+		{
+			name: "amazonec2",
+			data: `{
+  "ConfigVersion": 3,
+  "Driver": {
+    "IPAddress": "203.0.113.10",
+    "MachineName": "vm",
+    "SSHUser": "ubuntu",
+    "SSHPort": 22,
+    "InstanceId": "i-0123456789abcdef0",
+    "Region": "us-east-1",
+    "Zone": "a",
+    "PrivateIPAddress": "10.0.0.12"
+  },
+  "DriverName": "amazonec2",
+  "Name": "vm"
+}`,
+			want: api.MachineConnection{
+				Driver:     "amazonec2",
+				PublicIP:   "203.0.113.10",
+				PrivateIP:  "10.0.0.12",
+				SSHUser:    "ubuntu",
+				SSHPort:    22,
+				InstanceID: "i-0123456789abcdef0",
+				Region:     "us-east-1",
+				Zone:       "us-east-1a",
+			},
+		},
+		{
+			name: "digitalocean",
+			data: `{"Driver": {"IPAddress": "203.0.113.11", "SSHUser": "root", "SSHPort": 22, "DropletID": 123456789, "Region": "nyc3"}, "DriverName": "digitalocean"}`,
+			want: api.MachineConnection{
+				Driver:     "digitalocean",
+				PublicIP:   "203.0.113.11",
+				SSHUser:    "root",
+				SSHPort:    22,
+				InstanceID: "123456789",
+				Region:     "nyc3",
+			},
+		},
+		{
+			name: "azure",
+			data: `{"Driver": {"IPAddress": "", "SSHUser": "docker-user", "SSHPort": 22, "Location": "westus"}, "DriverName": "azure"}`,
+			want: api.MachineConnection{
+				Driver:  "azure",
+				SSHUser: "docker-user",
+				SSHPort: 22,
+				Region:  "westus",
+			},
+		},
+		{
+			name:    "invalid",
+			data:    `Host does not exist: "vm"`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMachineInspect([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseMachineInspect() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
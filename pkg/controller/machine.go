@@ -17,180 +17,324 @@ limitations under the License.
 package controller
 
 import (
-	"bytes"
-	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
+	"sort"
 	"time"
 
 	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
 
 	core "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	kmapi "kmodules.xyz/client-go/api/v1"
 	cutil "kmodules.xyz/client-go/conditions"
 )
 
-const machineCreationTimeout = 15 * time.Minute
+const (
+	machineCreationTimeout = 15 * time.Minute
+	machineDeletionTimeout = 15 * time.Minute
+	// startupScriptEnv holds the path of the startup script file
+	startupScriptEnv = "STARTUP_SCRIPT"
+)
 
-func (r *MachineReconciler) createMachine() error {
-	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineCreating)) ||
-		cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineReady)) {
-		return nil
+// createMachine runs docker-machine create in the background. It returns true
+// while the creation is in progress.
+func (r *machineRequest) createMachine() (bool, error) {
+	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineReady)) {
+		return false, nil
+	}
+	if op := r.machineObj.Status.Operation; op != nil {
+		switch op.Type {
+		case api.MachineOperationCreate:
+			return r.checkCreateOperation()
+		case api.MachineOperationReplace:
+			return r.checkReplaceOperation()
+		}
+	}
+	if cutil.GetReason(r.machineObj, api.MachineConditionTypeMachineReady) == api.ReasonMachineReplacing {
+		// the removal of the replaced machine failed or was interrupted
+		return true, r.startReplaceOperation()
+	}
+	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineCreating)) {
+		return false, nil
 	}
 
 	err := r.setInitialConditions()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	err = r.createPrerequisitesForMachine()
-	if err != nil {
-		return err
+	ready, err := r.createPrerequisitesForMachine()
+	if err != nil || !ready {
+		return !ready, err
 	}
-	args, err := r.getMachineCreationArgs()
+	opts, err := r.getMachineCreateOptions()
 	if err != nil {
-		return err
+		return false, err
 	}
 	r.Log.Info("Creating Machine", "MachineName", r.machineObj.Name, "Driver", r.machineObj.Spec.Driver)
 
-	newCtx, cancel := context.WithTimeout(r.ctx, machineCreationTimeout)
-	defer cancel()
-
+	err = r.startOperation(api.MachineOperationCreate, machineCreationTimeout, func(op *machineRequest) error {
+		err := op.Executor.Create(op.ctx, op.machineObj, *opts)
+		if errors.Is(err, executor.ErrMachineExists) {
+			// only a machine created for this Machine before is adopted
+			owned, ownErr := op.ownsMachineStore()
+			if ownErr != nil {
+				return ownErr
+			}
+			if !owned {
+				return fmt.Errorf("docker-machine %s is not the machine of this Machine: %w", executor.MachineName(op.machineObj), err)
+			}
+			return nil
+		}
+		if err != nil {
+			op.Log.Info("Error creating docker machine", "Error: ", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	r.event(core.EventTypeNormal, EventReasonCreating, "Creating machine with driver %s", r.machineObj.Spec.Driver.Name)
 	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeMachineCreating)
-	cmd := exec.CommandContext(newCtx, "docker-machine", args...)
-	var commandOutput, commandError bytes.Buffer
-	cmd.Stdout = &commandOutput
-	cmd.Stderr = &commandError
-
-	err = cmd.Run()
-	if err != nil && !strings.Contains(commandError.String(), "already exists") {
-		r.Log.Info("Error creating docker machine", "Error: ", commandError.String(), "Output: ", commandOutput.String())
-		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineCreationFailed, kmapi.ConditionSeverityError,
-			"unable to create docker machine. err: %s", err.Error())
+	return true, r.updateMachineStatus(types.NamespacedName{Name: r.machineObj.Name, Namespace: r.machineObj.Namespace})
+}
 
+// forgetMachine drops the docker-machine store and the status of a removed
+// machine, so that createMachine creates it again.
+func (r *machineRequest) forgetMachine(message string) error {
+	if err := r.deleteMachineStore(); err != nil {
 		return err
 	}
+	r.machineObj.Status.Conditions = cutil.RemoveCondition(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineCreating))
+	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineCreating, kmapi.ConditionSeverityInfo, "%s", message)
+	r.machineObj.Status.ScriptResult = nil
+	r.machineObj.Status.Connection = nil
+	r.machineObj.Status.PowerState = ""
+	return nil
+}
+
+func (r *machineRequest) checkCreateOperation() (bool, error) {
+	state, err := r.operationResult()
+	switch state {
+	case operationRunning:
+		return true, nil
+	case operationUnknown:
+		return r.resumeCreateOperation()
+	case operationFailed:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineCreationFailed, kmapi.ConditionSeverityError,
+			"unable to create docker machine. err: %s", err.Error())
+		r.warning(EventReasonCreateFailed, "Failed to create machine: %v", err)
+		return false, err
+	}
 
 	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeMachineReady)
 	r.Log.Info("Created Docker Machine Successfully", "MachineName", r.machineObj.Name, "Driver", r.machineObj.Spec.Driver)
-	return r.updateMachineStatus(types.NamespacedName{Name: r.machineObj.Name, Namespace: r.machineObj.Namespace})
+	r.event(core.EventTypeNormal, EventReasonCreated, "Created machine")
+	return false, r.updateMachineStatus(types.NamespacedName{Name: r.machineObj.Name, Namespace: r.machineObj.Namespace})
+}
+
+// resumeCreateOperation handles a create operation that was interrupted by an
+// operator restart. A machine that came up is adopted, a machine that docker-machine
+// never registered is created again, anything else is marked as failed.
+func (r *machineRequest) resumeCreateOperation() (bool, error) {
+	op := r.machineObj.Status.Operation
+	r.machineObj.Status.Operation = nil
+	key := types.NamespacedName{Name: r.machineObj.Name, Namespace: r.machineObj.Namespace}
+
+	state, err := r.Executor.Status(r.ctx, r.machineObj, r.executorOptions())
+	if err == nil && state == machineStateRunning {
+		r.Log.Info("Adopting Machine created by an interrupted operation", "ID", op.ID)
+		cutil.MarkTrue(r.machineObj, api.MachineConditionTypeMachineReady)
+		r.event(core.EventTypeNormal, EventReasonAdopted, "Adopted machine created by the interrupted operation %s", op.ID)
+		return false, r.updateMachineStatus(key)
+	}
+
+	if _, statErr := os.Stat(filepath.Join(r.machineStoreDir(), machineConfigFile)); os.IsNotExist(statErr) {
+		r.Log.Info("Restarting interrupted create operation", "ID", op.ID)
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineCreating, api.ReasonMachineCreating, kmapi.ConditionSeverityInfo,
+			"create operation %s was interrupted", op.ID)
+		if err := r.updateMachineStatus(key); err != nil {
+			return false, err
+		}
+		return r.createMachine()
+	}
+
+	msg := state
+	if err != nil {
+		msg = err.Error()
+	}
+	r.Log.Info("Create operation was interrupted", "ID", op.ID, "State", msg)
+	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineCreationFailed, kmapi.ConditionSeverityError,
+		"create operation %s started at %s was interrupted, machine state: %s", op.ID, op.StartedAt.UTC().Format(time.RFC3339), msg)
+	r.warning(EventReasonCreateFailed, "Create operation %s was interrupted, machine state: %s", op.ID, msg)
+	return false, r.updateMachineStatus(key)
 }
 
-func (r *MachineReconciler) createPrerequisitesForMachine() error {
+// isDriverReady resolves the Driver referenced by the Machine and records its
+// readiness in the DriverReady condition. Machines whose creation has already
+// started are not gated on the Driver.
+func (r *machineRequest) isDriverReady() (bool, error) {
+	if cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineCreating)) ||
+		cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineReady)) {
+		return true, nil
+	}
+
+	if r.machineClassNotFound {
+		ref := r.machineObj.Spec.ClassRef
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeDriverReady, api.ReasonMachineClassNotFound, kmapi.ConditionSeverityWarning,
+			"%s %s not found", machineClassKind(ref), ref.Name)
+		r.warning(EventReasonMachineClassNotFound, "%s %s not found", machineClassKind(ref), ref.Name)
+		return false, nil
+	}
+	if r.machineObj.Spec.Driver == nil || r.machineObj.Spec.Driver.Name == "" {
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeDriverReady, api.ReasonDriverNotFound, kmapi.ConditionSeverityWarning,
+			"spec.driver is not set")
+		return false, nil
+	}
+
+	var driver api.Driver
+	err := r.KBClient.Get(r.ctx, types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Spec.Driver.Name}, &driver)
+	if kerr.IsNotFound(err) {
+		r.Log.Info("driver is not found", "name", r.machineObj.Spec.Driver.Name)
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeDriverReady, api.ReasonDriverNotFound, kmapi.ConditionSeverityWarning,
+			"driver %s/%s not found", r.machineObj.Namespace, r.machineObj.Spec.Driver.Name)
+		r.warning(EventReasonDriverNotReady, "Driver %s not found", r.machineObj.Spec.Driver.Name)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !driver.IsReady() {
+		r.Log.Info("driver is not ready yet", "name", driver.Name, "phase", driver.Status.Phase)
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeDriverReady, api.ReasonDriverNotReady, kmapi.ConditionSeverityWarning,
+			"driver %s/%s is not ready, phase: %q", driver.Namespace, driver.Name, driver.Status.Phase)
+		r.warning(EventReasonDriverNotReady, "Driver %s is not ready, phase: %q", driver.Name, driver.Status.Phase)
+		return false, nil
+	}
+
+	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeDriverReady)
+	r.driver = &driver
+	return true, nil
+}
+
+// createPrerequisitesForMachine returns false while the prerequisites are not ready yet.
+func (r *machineRequest) createPrerequisitesForMachine() (bool, error) {
 	if r.machineObj.Spec.Driver.Name == AWSDriver {
 		return r.createAWSEnvironment()
 	}
-	return nil
+	return true, nil
 }
 
-func (r *MachineReconciler) getMachineCreationArgs() ([]string, error) {
-	var args []string
-	args = append(args, "create", "--driver", r.machineObj.Spec.Driver.Name)
-
-	for k, v := range r.machineObj.Spec.Parameters {
-		args = append(args, fmt.Sprintf("--%s", k))
-		args = append(args, v)
+// getMachineCreateOptions returns the docker-machine create options of the Machine.
+func (r *machineRequest) getMachineCreateOptions() (*executor.CreateOptions, error) {
+	opts := &executor.CreateOptions{
+		Options: r.executorOptions(),
+		Driver:  r.machineObj.Spec.Driver.Name,
 	}
 
+	var scriptKey string
 	if r.machineObj.Spec.ScriptRef != nil {
-		scriptArgs, err := r.getStartupScriptArgs()
+		key, scriptFile, err := r.getStartupScript()
 		if err != nil {
 			cutil.MarkFalse(r.machineObj, api.MachineConditionTypeScriptReady, api.ReasonScriptDataNotFound, kmapi.ConditionSeverityError, "unable to create script")
+			r.warning(EventReasonScriptDataNotFound, "Failed to read the startup script: %v", err)
 			return nil, err
 		}
 		cutil.MarkTrue(r.machineObj, api.MachineConditionTypeScriptReady)
-		args = append(args, scriptArgs...)
+		scriptKey = key
+		opts.SecretFiles = append(opts.SecretFiles, *scriptFile)
 	}
 
-	authArgs, err := r.getAuthSecretArgs()
+	creds, err := r.getDriverCredentials()
 	if err != nil {
 		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeAuthDataReady, api.ReasonAuthDataNotFound, kmapi.ConditionSeverityError, "unable to read auth data")
+		r.warning(EventReasonAuthDataNotFound, "Failed to read the auth data: %v", err)
 		return nil, err
 	}
 	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeAuthDataReady)
-	args = append(args, authArgs...)
-	args = append(args, r.getAMIIDArg()...)
-	args = append(args, r.getAnnotationsArgsForAWS()...)
-	args = append(args, r.machineObj.Name)
-
-	return args, r.updateMachineStatus(types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name})
-}
+	opts.Env = append(opts.Env, creds.env...)
+	opts.SecretFiles = append(opts.SecretFiles, creds.files...)
 
-func (r *MachineReconciler) getAuthSecretArgs() ([]string, error) {
-	authSecret, err := r.getSecret(r.machineObj.Spec.AuthSecret)
+	schema, err := r.driverParameters()
 	if err != nil {
-		if errors.IsNotFound(err) {
-			r.Log.Info("auth secret is not ready yet", "name", r.machineObj.Spec.AuthSecret)
-		} else {
-			r.Log.Error(err, "error in auth secret", "name", r.machineObj.Spec.AuthSecret)
-		}
 		return nil, err
 	}
-	var authArgs []string
-	for key, value := range authSecret.Data {
-		data := string(value)
-		if r.machineObj.Spec.Driver.Name == GoogleDriver {
-			data = base64.StdEncoding.EncodeToString(value)
+	opts.Args = machineCreateArgs(r.machineObj, schema, scriptKey)
+	r.machineObj.Status.CreateArgsHash = createArgsHash(&r.machineObj.Spec)
+
+	return opts, r.updateMachineStatus(types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name})
+}
+
+// driverParameters returns the parameter schema of the Driver of the Machine,
+// or nil if the Driver is gone.
+func (r *machineRequest) driverParameters() ([]api.DriverParameter, error) {
+	if r.driver == nil {
+		var driver api.Driver
+		err := r.KBClient.Get(r.ctx, types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Spec.Driver.Name}, &driver)
+		if kerr.IsNotFound(err) {
+			return nil, nil
 		}
-		if len(data) == 0 || len(key) == 0 {
-			return nil, fmt.Errorf("auth secret not found")
+		if err != nil {
+			return nil, err
 		}
-		authArgs = append(authArgs, fmt.Sprintf("--%s", key))
-		authArgs = append(authArgs, data)
+		r.driver = &driver
 	}
-	return authArgs, nil
+	return DriverParameters(r.driver), nil
 }
 
-func (r *MachineReconciler) getStartupScriptArgs() ([]string, error) {
+// getStartupScript returns the key of the script Secret that holds the startup
+// script along with the file the script is handed to docker-machine with. The
+// key is the driver flag that passes the script, e.g. google-userdata.
+func (r *machineRequest) getStartupScript() (string, *executor.SecretFile, error) {
 	scriptSecret, err := r.getSecret(r.machineObj.Spec.ScriptRef)
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if kerr.IsNotFound(err) {
 			r.Log.Info("script secret is not ready yet", "name", r.machineObj.Spec.ScriptRef)
 		} else {
 			r.Log.Error(err, "error in script secret", "name", r.machineObj.Spec.ScriptRef)
 		}
 
-		return nil, err
+		return "", nil, err
 	}
-	filePath := r.getScriptFilePath()
 
-	var userDataKey, userDataValue string
-	for key, value := range scriptSecret.Data {
-		userDataKey = key
-		userDataValue = string(value)
-		if len(userDataKey) > 0 {
-			break
-		}
+	key, err := scriptSecretKey(&scriptSecret, r.machineObj.Spec.ScriptKey)
+	if err != nil {
+		return "", nil, err
 	}
-	if len(userDataKey) == 0 || len(userDataValue) == 0 {
-		return nil, fmt.Errorf("script data not found")
+	file := &executor.SecretFile{
+		Env:       startupScriptEnv,
+		SecretRef: executor.SecretKeyRef{Namespace: scriptSecret.Namespace, Name: scriptSecret.Name, Key: key},
 	}
+	return key, file, nil
+}
 
-	scriptArgs := []string{fmt.Sprintf("--%s", userDataKey)}
-	scriptArgs = append(scriptArgs, filePath)
-
-	_, err = os.Stat(filePath)
-	if err == nil {
-		return scriptArgs, nil
-	}
-	if !os.IsNotExist(err) {
-		return nil, err
+// scriptSecretKey returns the key of the script Secret selected by the Machine,
+// or the first key in sorted order if the Machine does not select one.
+func scriptSecretKey(secret *core.Secret, key string) (string, error) {
+	if key == "" {
+		keys := make([]string, 0, len(secret.Data))
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			key = keys[0]
+		}
 	}
-	r.Log.Info("writing start up script in file", "Filepath", filePath)
-
-	err = os.WriteFile(filePath, []byte(userDataValue), 0o644)
-	if err != nil {
-		return nil, err
+	if len(key) == 0 || len(secret.Data[key]) == 0 {
+		return "", fmt.Errorf("script data not found")
 	}
-	return scriptArgs, nil
+	return key, nil
 }
 
-func (r *MachineReconciler) getSecret(secretRef *kmapi.ObjectReference) (core.Secret, error) {
+func (r *machineRequest) getSecret(secretRef *kmapi.ObjectReference) (core.Secret, error) {
 	var secret core.Secret
 	err := r.KBClient.Get(r.ctx, secretRef.ObjectKey(), &secret)
 	return secret, err
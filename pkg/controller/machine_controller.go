@@ -18,33 +18,99 @@ package controller
 
 import (
 	"context"
-	"time"
+	"os"
 
 	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
 
 	"github.com/go-logr/logr"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
+	cutil "kmodules.xyz/client-go/conditions"
 	"kmodules.xyz/client-go/conditions/committer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-// MachineReconciler reconciles a Machine object
+const machineDriverIndex = ".spec.driver.name"
+
+// MachineReconciler reconciles a Machine object.
+// It is shared by all workers and must not hold per-request state.
 type MachineReconciler struct {
+	KBClient client.Client
+	Scheme   *runtime.Scheme
+	// StoragePath is the docker-machine store. DefaultMachineStoragePath is used if empty.
+	StoragePath string
+	// WorkDir holds the per-Machine working directories for startup scripts and
+	// script results. DefaultWorkDir is used if empty.
+	WorkDir string
+	// DriverDir is the directory where the Driver controller installs driver
+	// binaries. DefaultDriverDir is used if empty.
+	DriverDir string
+	// MaxConcurrentReconciles is the maximum number of Machines reconciled in parallel.
+	MaxConcurrentReconciles int
+	// Operations runs the long running docker-machine commands. A new runner is used if nil.
+	Operations *OperationRunner
+	// Executor runs the docker-machine operations. docker-machine is run as a
+	// child process if nil.
+	Executor executor.Executor
+	// Recorder records the events of Machines. A recorder of the manager is used if nil.
+	Recorder record.EventRecorder
+}
+
+// machineRequest holds the state of a single Machine reconcile.
+type machineRequest struct {
+	*MachineReconciler
+
 	ctx        context.Context
 	committer  func(ctx context.Context, old, obj committer.StatusGetter[*api.MachineStatus]) error
-	KBClient   client.Client
 	Log        logr.Logger
 	machineObj *api.Machine
-	Scheme     *runtime.Scheme
+	// driver is the Driver of a Machine that is not created yet, set by isDriverReady
+	driver *api.Driver
+	// redactor scrubs credentials from the logs and the status of the Machine
+	redactor *redactor
+	// powerState is the state reported by docker-machine status, see queryPowerState
+	powerState api.MachinePowerState
+	// effectiveSpec is the spec of the Machine merged with its class, see resolveMachineClass
+	effectiveSpec *api.MachineSpec
+	// machineClassNotFound is set if the class of the Machine does not exist
+	machineClassNotFound bool
+	// finishedOperation is the operation cleared from the status, see operationResult
+	finishedOperation string
+}
+
+func (r *MachineReconciler) newMachineRequest(ctx context.Context) *machineRequest {
+	rd := newRedactor()
+	return &machineRequest{
+		MachineReconciler: r,
+		ctx:               ctx,
+		committer:         committer.NewStatusCommitter[*api.Machine, *api.MachineStatus](r.KBClient.Status()),
+		Log:               newRedactingLogger(log.FromContext(ctx), rd),
+		redactor:          rd,
+	}
 }
 
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machines,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machines/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machines/finalizers,verbs=update
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=drivers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machineclasses;clustermachineclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=create
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=create
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -54,26 +120,35 @@ type MachineReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
-func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	r.Log = log.FromContext(ctx)
-
-	r.committer = committer.NewStatusCommitter[*api.Machine, *api.MachineStatus](r.KBClient.Status())
+func (mr *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r := mr.newMachineRequest(ctx)
 
 	message, err := r.updateMachineReconcile(ctx, req.NamespacedName)
 	if err != nil {
 		if kerr.IsNotFound(err) {
 			return ctrl.Result{}, nil
 		}
-		return r.requeueWithError(message, err)
+		r.Log.Info(message, "Reason : ", err.Error())
+		return ctrl.Result{}, err
+	}
+	if err := r.addAuthSecret(); err != nil {
+		return ctrl.Result{}, err
 	}
 	if r.machineObj.Status.Phase == "" {
 		return ctrl.Result{}, r.updateMachineStatus(req.NamespacedName)
 	}
 
+	if err := r.restoreMachineStore(); err != nil {
+		return r.requeueWithError("Failed to restore docker-machine store", err)
+	}
+
 	if r.isMarkedForDeletion() {
-		if err := r.removeFinalizerAfterCleanup(); err != nil {
-			klog.Errorln(err)
-			return r.requeueWithError("", err)
+		inProgress, err := r.removeFinalizerAfterCleanup()
+		if err != nil {
+			return r.requeueWithError("Failed to clean up Machine", err)
+		}
+		if inProgress {
+			return ctrl.Result{RequeueAfter: operationPollInterval}, nil
 		}
 		return r.reconciled()
 	}
@@ -86,23 +161,88 @@ func (r *MachineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return r.requeueWithError("Failed to ensure Finalizers", err)
 	}
 
-	err = r.createMachine()
+	driverReady, err := r.isDriverReady()
+	if err != nil {
+		return r.requeueWithError("Failed to get Driver", err)
+	}
+	if !driverReady {
+		// the Driver watch re-queues the Machine once the driver becomes ready
+		return ctrl.Result{}, r.updateMachineStatus(req.NamespacedName)
+	}
+	if !r.areParametersValid() {
+		// changes to the spec of the Machine or the Driver re-queue the Machine
+		return ctrl.Result{}, r.updateMachineStatus(req.NamespacedName)
+	}
+
+	inProgress, err := r.createMachine()
 	if err != nil {
 		return r.requeueWithError("Failed to create Machine", err)
 	}
+	if inProgress {
+		return ctrl.Result{RequeueAfter: operationPollInterval}, r.updateMachineStatus(req.NamespacedName)
+	}
+
+	machineReady := cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineReady))
+	if machineReady {
+		inProgress, err := r.reconcileHealth()
+		if err != nil {
+			return r.requeueWithError("Failed to remediate Machine", err)
+		}
+		if inProgress {
+			return ctrl.Result{RequeueAfter: operationPollInterval}, r.updateMachineStatus(req.NamespacedName)
+		}
+		if cutil.IsConditionFalse(r.machineObj.Status.Conditions, string(api.MachineConditionTypeMachineHealthy)) {
+			// nothing else can be done for an unhealthy machine, it is checked again later
+			return ctrl.Result{RequeueAfter: resyncInterval}, r.updateMachineStatus(req.NamespacedName)
+		}
+
+		inProgress, err = r.reconcileSpecDrift()
+		if err != nil {
+			return r.requeueWithError("Failed to replace Machine", err)
+		}
+		if inProgress {
+			return ctrl.Result{RequeueAfter: operationPollInterval}, r.updateMachineStatus(req.NamespacedName)
+		}
+
+		inProgress, err = r.reconcilePowerState()
+		if err != nil {
+			return r.requeueWithError("Failed to reconcile power state", err)
+		}
+		if inProgress {
+			return ctrl.Result{RequeueAfter: operationPollInterval}, r.updateMachineStatus(req.NamespacedName)
+		}
+		if err := r.saveMachineStore(); err != nil {
+			return r.requeueWithError("Failed to save docker-machine store", err)
+		}
+		if err := r.updateConnectionInfo(); err != nil {
+			return r.requeueWithError("Failed to get Machine connection details", err)
+		}
+		if err := r.writeConnectionSecret(); err != nil {
+			return r.requeueWithError("Failed to write connection Secret", err)
+		}
+	}
+
+	reconcileResult := ctrl.Result{}
+	if machineReady {
+		// check the health and notice power state changes made out of band
+		reconcileResult.RequeueAfter = resyncInterval
+	}
+	if r.machineObj.Status.PowerState == api.MachinePowerStateStopped {
+		// the startup script is checked once the machine runs again
+		return reconcileResult, r.updateMachineStatus(req.NamespacedName)
+	}
 
 	rekey, err := r.isScriptFinished()
 	if err != nil {
 		return r.requeueWithError("", err)
 	}
-	reconcileResult := ctrl.Result{}
 	if rekey {
-		reconcileResult.RequeueAfter = time.Minute * 1
+		reconcileResult.RequeueAfter = scriptPollInterval
 	}
 	return reconcileResult, r.updateMachineStatus(req.NamespacedName)
 }
 
-func (r *MachineReconciler) updateMachineReconcile(ctx context.Context, namespacedName client.ObjectKey) (string, error) {
+func (r *machineRequest) updateMachineReconcile(ctx context.Context, namespacedName client.ObjectKey) (string, error) {
 	machine := &api.Machine{}
 	if err := r.KBClient.Get(ctx, namespacedName, machine); err != nil {
 		return "Failed to get Machine", err
@@ -110,12 +250,112 @@ func (r *MachineReconciler) updateMachineReconcile(ctx context.Context, namespac
 	r.machineObj = machine
 	r.ctx = ctx
 
+	if err := r.resolveMachineClass(); err != nil {
+		return "Failed to get MachineClass", err
+	}
 	return "", nil
 }
 
+// machinesForDriver maps a Driver to the Machines in its namespace that use it.
+func (r *MachineReconciler) machinesForDriver(ctx context.Context, obj client.Object) []reconcile.Request {
+	var machines api.MachineList
+	if err := r.KBClient.List(ctx, &machines, client.InNamespace(obj.GetNamespace()), client.MatchingFields{machineDriverIndex: obj.GetName()}); err != nil {
+		klog.Errorf("failed to list machines for driver %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+		return nil
+	}
+
+	reqs := make([]reconcile.Request, 0, len(machines.Items))
+	for _, mc := range machines.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&mc)})
+	}
+	return reqs
+}
+
+// machinesForClass maps a MachineClass or a ClusterMachineClass to the Machines that use it.
+func (r *MachineReconciler) machinesForClass(kind string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		opts := []client.ListOption{client.MatchingFields{machineClassIndex: machineClassKey(kind, obj.GetName())}}
+		if kind == api.ResourceKindMachineClass {
+			opts = append(opts, client.InNamespace(obj.GetNamespace()))
+		}
+		var machines api.MachineList
+		if err := r.KBClient.List(ctx, &machines, opts...); err != nil {
+			klog.Errorf("failed to list machines for %s %s: %v", kind, obj.GetName(), err)
+			return nil
+		}
+
+		reqs := make([]reconcile.Request, 0, len(machines.Items))
+		for _, mc := range machines.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&mc)})
+		}
+		return reqs
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *MachineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.StoragePath == "" {
+		r.StoragePath = DefaultMachineStoragePath()
+	}
+	if r.WorkDir == "" {
+		r.WorkDir = DefaultWorkDir
+	}
+	if r.DriverDir == "" {
+		r.DriverDir = DefaultDriverDir
+	}
+	if r.Operations == nil {
+		r.Operations = NewOperationRunner()
+	}
+	if r.Executor == nil {
+		r.Executor = executor.NewDockerMachine(&executor.LocalExecutor{Client: r.KBClient}, nil)
+	}
+	r.Executor = instrumentExecutor(r.Executor)
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machine-controller")
+	}
+	if err := registerMachineCollector(r.KBClient); err != nil {
+		return err
+	}
+	// stop the operations in flight when the manager shuts down, they are resumed after a restart
+	err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		r.Operations.CancelAll()
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	// make sure docker-machine uses the same store that is persisted in Secrets
+	if err := os.Setenv(machineStorageEnv, r.StoragePath); err != nil {
+		return err
+	}
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &api.Machine{}, machineDriverIndex, func(obj client.Object) []string {
+		driver := machineDriverName(obj.(*api.Machine))
+		if driver == "" {
+			return nil
+		}
+		return []string{driver}
+	})
+	if err != nil {
+		return err
+	}
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &api.Machine{}, machineClassIndex, func(obj client.Object) []string {
+		mc := obj.(*api.Machine)
+		if mc.Spec.ClassRef == nil {
+			return nil
+		}
+		return []string{machineClassKey(machineClassKind(mc.Spec.ClassRef), mc.Spec.ClassRef.Name)}
+	})
+	if err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&api.Machine{}).
+		Watches(&api.Driver{}, handler.EnqueueRequestsFromMapFunc(r.machinesForDriver)).
+		Watches(&api.MachineClass{}, handler.EnqueueRequestsFromMapFunc(r.machinesForClass(api.ResourceKindMachineClass))).
+		Watches(&api.ClusterMachineClass{}, handler.EnqueueRequestsFromMapFunc(r.machinesForClass(api.ResourceKindClusterMachineClass))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
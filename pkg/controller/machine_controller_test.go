@@ -0,0 +1,67 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("MachineReconciler concurrency", func() {
+	const numMachines = 20
+
+	It("keeps the state of concurrently reconciled Machines apart", func() {
+		ctx := context.Background()
+		ns := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "concurrency-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		By("creating Machines that all reference a different missing Driver")
+		for i := 0; i < numMachines; i++ {
+			Expect(k8sClient.Create(ctx, &api.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("vm-%d", i), Namespace: ns.Name},
+				Spec: api.MachineSpec{
+					Driver:     &core.LocalObjectReference{Name: fmt.Sprintf("driver-%d", i)},
+					AuthSecret: &kmapi.ObjectReference{Name: "cred", Namespace: ns.Name},
+				},
+			})).To(Succeed())
+		}
+
+		By("checking that every Machine reports its own Driver")
+		for i := 0; i < numMachines; i++ {
+			key := client.ObjectKey{Namespace: ns.Name, Name: fmt.Sprintf("vm-%d", i)}
+			Eventually(func(g Gomega) {
+				var mc api.Machine
+				g.Expect(k8sClient.Get(ctx, key, &mc)).To(Succeed())
+				g.Expect(cutil.GetReason(&mc, api.MachineConditionTypeDriverReady)).To(Equal(api.ReasonDriverNotFound))
+				g.Expect(cutil.GetMessage(&mc, api.MachineConditionTypeDriverReady)).To(HaveSuffix(fmt.Sprintf("/driver-%d not found", i)))
+				g.Expect(mc.Status.Phase).To(Equal(api.MachinePhasePending))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		}
+	})
+})
@@ -0,0 +1,428 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+var _ = Describe("Machine lifecycle", func() {
+	const (
+		authKey   = "service-account.json"
+		scriptKey = "google-userdata"
+	)
+
+	var (
+		ctx     context.Context
+		ns      string
+		machine *api.Machine
+		key     client.ObjectKey
+		fakeKey string
+	)
+
+	createSecret := func(name, dataKey, value string) {
+		Expect(k8sClient.Create(ctx, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Data:       map[string][]byte{dataKey: []byte(value)},
+		})).To(Succeed())
+	}
+
+	getMachine := func(g Gomega) *api.Machine {
+		var mc api.Machine
+		g.Expect(k8sClient.Get(ctx, key, &mc)).To(Succeed())
+		return &mc
+	}
+
+	expectPhase := func(phase api.MachinePhase) *api.Machine {
+		var mc *api.Machine
+		Eventually(func(g Gomega) {
+			mc = getMachine(g)
+			g.Expect(mc.Status.Phase).To(Equal(phase))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		return mc
+	}
+
+	eventReasons := func(g Gomega) []string {
+		var events core.EventList
+		g.Expect(k8sClient.List(ctx, &events, client.InNamespace(ns))).To(Succeed())
+		var reasons []string
+		for _, ev := range events.Items {
+			if ev.InvolvedObject.Kind == "Machine" && ev.InvolvedObject.Name == machine.Name {
+				reasons = append(reasons, ev.Reason)
+			}
+		}
+		return reasons
+	}
+
+	writeScriptResult := func(result string) {
+		Eventually(func() error {
+			return fakeExecutor.SetFile(fakeKey, remoteResultFile, []byte(result))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "lifecycle-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+		ns = namespace.Name
+
+		Expect(k8sClient.Create(ctx, &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: GoogleDriver, Namespace: ns},
+			Spec:       api.DriverSpec{Builtin: true},
+		})).To(Succeed())
+
+		machine = &api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: ns},
+			Spec: api.MachineSpec{
+				Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+				AuthSecret: &kmapi.ObjectReference{Name: "cred", Namespace: ns},
+				ScriptRef:  &kmapi.ObjectReference{Name: "script", Namespace: ns},
+				Parameters: map[string]string{"google-project": "demo"},
+			},
+		}
+		key = client.ObjectKeyFromObject(machine)
+		fakeKey = path.Join(ns, machine.Name)
+	})
+
+	Context("with the auth and script Secrets", func() {
+		BeforeEach(func() {
+			createSecret("cred", authKey, `{"type":"service_account"}`)
+			createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+			Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+		})
+
+		It("creates the machine and waits for the startup script", func() {
+			By("adding the finalizer")
+			Eventually(func(g Gomega) {
+				g.Expect(controllerutil.ContainsFinalizer(getMachine(g), api.GetFinalizer())).To(BeTrue())
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+			By("running docker-machine create")
+			mc := expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+			Expect(cutil.IsConditionTrue(mc.Status.Conditions, string(api.MachineConditionTypeMachineReady))).To(BeTrue())
+			Expect(mc.Status.Operation).To(BeNil())
+
+			creates := fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)
+			Expect(creates).To(HaveLen(1))
+			opts := creates[0].Create
+			Expect(opts.Driver).To(Equal(GoogleDriver))
+			Expect(opts.Args).To(ContainElements("--google-project", "demo", "--"+scriptKey, "$("+startupScriptEnv+")"))
+			var files []string
+			for _, f := range opts.SecretFiles {
+				files = append(files, f.Env)
+			}
+			Expect(files).To(ConsistOf(startupScriptEnv, googleCredentialsEnv))
+
+			By("recording the connection details")
+			Eventually(func(g Gomega) {
+				conn := getMachine(g).Status.Connection
+				g.Expect(conn).NotTo(BeNil())
+				g.Expect(conn.DockerURL).To(HavePrefix("tcp://10.0.0."))
+				g.Expect(conn.SSHUser).To(Equal("docker"))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+			By("reading the script result")
+			writeScriptResult(`{"version":"v1","exitCode":0,"message":"cluster is ready"}`)
+			mc = expectPhase(api.MachinePhaseSuccess)
+			Expect(cutil.IsConditionTrue(mc.Status.Conditions, string(api.MachineConditionTypeClusterOperationComplete))).To(BeTrue())
+			Expect(mc.Status.ScriptResult).NotTo(BeNil())
+			Expect(mc.Status.ScriptResult.Message).To(Equal("cluster is ready"))
+
+			By("recording the lifecycle events")
+			Eventually(func(g Gomega) {
+				g.Expect(eventReasons(g)).To(ContainElements(EventReasonCreating, EventReasonCreated, EventReasonScriptSucceeded))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		})
+
+		It("reports a failed startup script", func() {
+			expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+			writeScriptResult(`{"version":"v1","exitCode":3,"message":"kubeadm init failed"}`)
+
+			mc := expectPhase(api.MachinePhaseClusterOperationFailed)
+			Expect(mc.Status.ScriptResult.ExitCode).To(BeEquivalentTo(3))
+			Expect(cutil.GetMessage(mc, api.MachineConditionTypeClusterOperationComplete)).To(ContainSubstring("kubeadm init failed"))
+		})
+
+		It("stops and starts the machine", func() {
+			expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+			writeScriptResult(`{"version":"v1","exitCode":0}`)
+			expectPhase(api.MachinePhaseSuccess)
+
+			setPowerState := func(state api.MachinePowerState) {
+				Eventually(func(g Gomega) {
+					mc := getMachine(g)
+					mc.Spec.PowerState = state
+					g.Expect(k8sClient.Update(ctx, mc)).To(Succeed())
+				}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+			}
+
+			By("stopping the machine")
+			setPowerState(api.MachinePowerStateStopped)
+			mc := expectPhase(api.MachinePhaseStopped)
+			Expect(mc.Status.PowerState).To(Equal(api.MachinePowerStateStopped))
+			m, _ := fakeExecutor.Machine(fakeKey)
+			Expect(m.State).To(Equal(fake.StateStopped))
+
+			By("reverting a start made out of band")
+			m.State = fake.StateRunning
+			fakeExecutor.SetMachine(fakeKey, m)
+			Eventually(func() int {
+				return len(fakeExecutor.ActionsFor(fake.VerbStop, fakeKey))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Equal(2))
+			expectPhase(api.MachinePhaseStopped)
+
+			By("starting the machine")
+			setPowerState(api.MachinePowerStateRunning)
+			mc = expectPhase(api.MachinePhaseSuccess)
+			Expect(mc.Status.PowerState).To(Equal(api.MachinePowerStateRunning))
+			Expect(fakeExecutor.ActionsFor(fake.VerbStart, fakeKey)).To(HaveLen(1))
+
+			By("restarting the machine")
+			Eventually(func(g Gomega) {
+				mc := getMachine(g)
+				metav1.SetMetaDataAnnotation(&mc.ObjectMeta, api.MachineRestartAnnotation, "1")
+				g.Expect(k8sClient.Update(ctx, mc)).To(Succeed())
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+			Eventually(func(g Gomega) {
+				g.Expect(getMachine(g).Status.ObservedRestart).To(Equal("1"))
+				g.Expect(fakeExecutor.ActionsFor(fake.VerbRestart, fakeKey)).To(HaveLen(1))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		})
+
+		It("removes the machine when the Machine is deleted", func() {
+			expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+			Expect(k8sClient.Delete(ctx, machine)).To(Succeed())
+
+			Eventually(func() bool {
+				return kerr.IsNotFound(k8sClient.Get(ctx, key, &api.Machine{}))
+			}).WithTimeout(timeout).WithPolling(interval).Should(BeTrue())
+			Expect(fakeExecutor.ActionsFor(fake.VerbRemove, fakeKey)).NotTo(BeEmpty())
+			_, exists := fakeExecutor.Machine(fakeKey)
+			Expect(exists).To(BeFalse())
+		})
+	})
+
+	It("reports a failed docker-machine create", func() {
+		fakeExecutor.PrependReaction(fake.VerbCreate, fake.ForMachine(path.Join(ns, machine.Name), fake.Fail(errors.New("quota exceeded"))))
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+		mc := expectPhase(api.MachinePhaseFailed)
+		Expect(cutil.GetReason(mc, api.MachineConditionTypeMachineReady)).To(Equal(api.ReasonMachineCreationFailed))
+		Expect(cutil.GetMessage(mc, api.MachineConditionTypeMachineReady)).To(ContainSubstring("quota exceeded"))
+		Eventually(func(g Gomega) {
+			g.Expect(eventReasons(g)).To(ContainElement(EventReasonCreateFailed))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+		By("deleting the Machine of the failed create")
+		Expect(k8sClient.Delete(ctx, machine)).To(Succeed())
+		Eventually(func() bool {
+			return kerr.IsNotFound(k8sClient.Get(ctx, key, &api.Machine{}))
+		}).WithTimeout(timeout).WithPolling(interval).Should(BeTrue())
+	})
+
+	It("recreates a machine that was deleted out of band", func() {
+		machine.Spec.Remediation = api.MachineRemediationRecreate
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+		expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+		writeScriptResult(`{"version":"v1","exitCode":0}`)
+		expectPhase(api.MachinePhaseSuccess)
+
+		By("deleting the machine behind the back of the operator")
+		Expect(fakeExecutor.Remove(ctx, machine, executor.Options{})).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)).To(HaveLen(2))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+		By("waiting for the startup script of the new machine")
+		mc := expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+		Expect(mc.Status.ScriptResult).To(BeNil())
+		writeScriptResult(`{"version":"v1","exitCode":0}`)
+		Eventually(func(g Gomega) {
+			mc := getMachine(g)
+			g.Expect(mc.Status.Phase).To(Equal(api.MachinePhaseSuccess))
+			g.Expect(cutil.IsConditionTrue(mc.Status.Conditions, string(api.MachineConditionTypeMachineHealthy))).To(BeTrue())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(eventReasons(g)).To(ContainElements(EventReasonMachineUnhealthy, EventReasonRecreating, EventReasonMachineRecovered))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+	})
+
+	It("replaces the machine when its create arguments change", func() {
+		machine.Spec.UpdateStrategy = api.MachineUpdateStrategyRecreate
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+		expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+		writeScriptResult(`{"version":"v1","exitCode":0}`)
+		mc := expectPhase(api.MachinePhaseSuccess)
+		Expect(mc.Status.CreateArgsHash).NotTo(BeEmpty())
+		Expect(mc.Status.ObservedGeneration).To(Equal(mc.Generation))
+		hash := mc.Status.CreateArgsHash
+
+		By("changing a parameter")
+		Eventually(func(g Gomega) {
+			mc := getMachine(g)
+			mc.Spec.Parameters["google-zone"] = "europe-west1-b"
+			g.Expect(k8sClient.Update(ctx, mc)).To(Succeed())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Eventually(func(g Gomega) {
+			creates := fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)
+			g.Expect(creates).To(HaveLen(2))
+			g.Expect(creates[1].Create.Args).To(ContainElement("europe-west1-b"))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+		By("waiting for the startup script of the new machine")
+		writeScriptResult(`{"version":"v1","exitCode":0}`)
+		Eventually(func(g Gomega) {
+			mc := getMachine(g)
+			g.Expect(mc.Status.Phase).To(Equal(api.MachinePhaseSuccess))
+			g.Expect(mc.Status.CreateArgsHash).NotTo(Equal(hash))
+			g.Expect(cutil.HasCondition(mc.Status.Conditions, string(api.MachineConditionTypeSpecDrifted))).To(BeFalse())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(eventReasons(g)).To(ContainElements(EventReasonSpecDrifted, EventReasonReplacing))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+	})
+
+	It("copies the script outputs into a ConfigMap of the user without owning it", func() {
+		Expect(k8sClient.Create(ctx, &core.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "outputs", Namespace: ns},
+			Data:       map[string]string{"owner": "user"},
+		})).To(Succeed())
+		machine.Spec.ScriptOutputRef = &api.ScriptOutputReference{Kind: api.ScriptOutputKindConfigMap, Name: "outputs"}
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+		expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+		writeScriptResult(`{"version":"v1","exitCode":0,"outputs":{"endpoint":"https://10.0.0.1:6443"}}`)
+		expectPhase(api.MachinePhaseSuccess)
+
+		var cm core.ConfigMap
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: "outputs"}, &cm)).To(Succeed())
+		Expect(cm.Data).To(Equal(map[string]string{"owner": "user", "endpoint": "https://10.0.0.1:6443"}))
+		Expect(cm.OwnerReferences).To(BeEmpty())
+	})
+
+	It("creates the machine from its MachineClass", func() {
+		machine.Spec = api.MachineSpec{
+			ClassRef:   &api.MachineClassReference{Kind: api.ResourceKindMachineClass, Name: "gcp"},
+			Parameters: map[string]string{"google-zone": "europe-west1-b"},
+		}
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(cutil.GetReason(getMachine(g), api.MachineConditionTypeDriverReady)).To(Equal(api.ReasonMachineClassNotFound))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Expect(fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)).To(BeEmpty())
+
+		By("creating the MachineClass")
+		Expect(k8sClient.Create(ctx, &api.MachineClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "gcp", Namespace: ns},
+			Spec: api.MachineClassSpec{
+				Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+				AuthSecret: &kmapi.ObjectReference{Name: "cred"},
+				ScriptRef:  &kmapi.ObjectReference{Name: "script"},
+				Parameters: map[string]string{"google-project": "demo", "google-zone": "us-central1-a"},
+			},
+		})).To(Succeed())
+		mc := expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+		Expect(mc.Spec.Driver).To(BeNil())
+		Expect(mc.Status.EffectiveSpec).NotTo(BeNil())
+		Expect(mc.Status.EffectiveSpec.Driver.Name).To(Equal(GoogleDriver))
+		Expect(mc.Status.EffectiveSpec.AuthSecret.Namespace).To(Equal(ns))
+		Expect(mc.Status.EffectiveSpec.Parameters).To(Equal(map[string]string{"google-project": "demo", "google-zone": "europe-west1-b"}))
+
+		creates := fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)
+		Expect(creates).To(HaveLen(1))
+		Expect(creates[0].Create.Args).To(ContainElements("demo", "europe-west1-b"))
+		Expect(creates[0].Create.Args).NotTo(ContainElement("us-central1-a"))
+	})
+
+	It("waits for a missing auth Secret", func() {
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(cutil.GetReason(getMachine(g), api.MachineConditionTypeAuthDataReady)).To(Equal(api.ReasonAuthDataNotFound))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Expect(fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)).To(BeEmpty())
+
+		By("creating the auth Secret")
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+	})
+
+	It("waits for a missing script Secret", func() {
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(cutil.GetReason(getMachine(g), api.MachineConditionTypeScriptReady)).To(Equal(api.ReasonScriptDataNotFound))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		Expect(fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)).To(BeEmpty())
+	})
+
+	It("validates the parameters against the schema of the Driver", func() {
+		var driver api.Driver
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: ns, Name: GoogleDriver}, &driver)).To(Succeed())
+		driver.Spec.Parameters = []api.DriverParameter{
+			{Name: "google-project", Required: true},
+			{Name: "google-zone", Required: true, Enum: []string{"us-central1-a", "europe-west1-b"}},
+		}
+		Expect(k8sClient.Update(ctx, &driver)).To(Succeed())
+		createSecret("cred", authKey, `{"type":"service_account"}`)
+		createSecret("script", scriptKey, "#!/bin/sh\necho hello")
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+
+		mc := expectPhase(api.MachinePhaseFailed)
+		Expect(cutil.GetReason(mc, api.MachineConditionTypeParametersValid)).To(Equal(api.ReasonInvalidParameters))
+		Expect(cutil.GetMessage(mc, api.MachineConditionTypeParametersValid)).To(ContainSubstring("spec.parameters[google-zone]"))
+		Expect(fakeExecutor.ActionsFor(fake.VerbCreate, fakeKey)).To(BeEmpty())
+
+		By("setting the missing parameter")
+		Eventually(func(g Gomega) {
+			mc := getMachine(g)
+			mc.Spec.Parameters["google-zone"] = "us-central1-a"
+			g.Expect(k8sClient.Update(ctx, mc)).To(Succeed())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		expectPhase(api.MachinePhaseWaitingForScriptCompletion)
+	})
+})
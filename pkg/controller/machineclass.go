@@ -0,0 +1,179 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const machineClassIndex = ".spec.classRef"
+
+// EffectiveMachineSpec returns the spec of the Machine merged with its class,
+// see MachineSpec.ClassRef. The spec of a Machine without class is returned as is.
+func EffectiveMachineSpec(ctx context.Context, c client.Reader, machine *api.Machine) (*api.MachineSpec, error) {
+	spec := machine.Spec.DeepCopy()
+	ref := machine.Spec.ClassRef
+	if ref == nil {
+		return spec, nil
+	}
+
+	var class *api.MachineClassSpec
+	switch machineClassKind(ref) {
+	case api.ResourceKindClusterMachineClass:
+		var obj api.ClusterMachineClass
+		if err := c.Get(ctx, client.ObjectKey{Name: ref.Name}, &obj); err != nil {
+			return nil, err
+		}
+		class = &obj.Spec
+	default:
+		var obj api.MachineClass
+		if err := c.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: ref.Name}, &obj); err != nil {
+			return nil, err
+		}
+		class = &obj.Spec
+	}
+	mergeMachineClass(spec, class, machine.Namespace)
+	return spec, nil
+}
+
+func machineClassKind(ref *api.MachineClassReference) string {
+	if ref.Kind == "" {
+		return api.ResourceKindMachineClass
+	}
+	return ref.Kind
+}
+
+// machineDriverName returns the driver of a stored Machine, the one of its
+// class once the Machine is reconciled.
+func machineDriverName(mc *api.Machine) string {
+	driver := mc.Spec.Driver
+	if driver == nil && mc.Status.EffectiveSpec != nil {
+		driver = mc.Status.EffectiveSpec.Driver
+	}
+	if driver == nil {
+		return ""
+	}
+	return driver.Name
+}
+
+// machineClassKey is the value of the class index of the Machines.
+func machineClassKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// mergeMachineClass fills the fields the spec does not set from the class.
+// Secret references of the class without a namespace refer to namespace.
+func mergeMachineClass(spec *api.MachineSpec, class *api.MachineClassSpec, namespace string) {
+	class = class.DeepCopy()
+	for _, ref := range []*kmapi.ObjectReference{class.AuthSecret, class.ScriptRef} {
+		if ref != nil && ref.Namespace == "" {
+			ref.Namespace = namespace
+		}
+	}
+	if spec.Driver == nil {
+		spec.Driver = class.Driver
+	}
+	if spec.AuthSecret == nil {
+		spec.AuthSecret = class.AuthSecret
+	}
+	if spec.ScriptRef == nil {
+		spec.ScriptRef = class.ScriptRef
+	}
+	if spec.ScriptKey == "" {
+		spec.ScriptKey = class.ScriptKey
+	}
+
+	// a parameter or a flag of the Machine replaces the class flag of the same name
+	overridden := map[string]bool{}
+	for name := range spec.Parameters {
+		overridden[name] = true
+	}
+	for _, f := range spec.Flags {
+		overridden[f.Name] = true
+	}
+	var flags []api.MachineFlag
+	for _, f := range class.Flags {
+		if !overridden[f.Name] {
+			flags = append(flags, f)
+		}
+	}
+	if flags != nil {
+		spec.Flags = append(flags, spec.Flags...)
+	}
+
+	if len(class.Parameters) > 0 {
+		for k, v := range spec.Parameters {
+			class.Parameters[k] = v
+		}
+		spec.Parameters = class.Parameters
+	}
+}
+
+// machineClassSpec returns the fields of the spec a class can set.
+func machineClassSpec(spec *api.MachineSpec) *api.MachineClassSpec {
+	out := &api.MachineClassSpec{
+		Driver:     spec.Driver,
+		AuthSecret: spec.AuthSecret,
+		ScriptRef:  spec.ScriptRef,
+		ScriptKey:  spec.ScriptKey,
+		Parameters: spec.Parameters,
+		Flags:      spec.Flags,
+	}
+	return out.DeepCopy()
+}
+
+// resolveMachineClass merges the class of the Machine into its spec. A Machine
+// whose class is gone keeps the spec recorded in status.effectiveSpec, e.g.
+// so that it can still be deleted.
+func (r *machineRequest) resolveMachineClass() error {
+	if r.machineObj.Spec.ClassRef == nil {
+		r.machineObj.Status.EffectiveSpec = nil
+		return nil
+	}
+	spec, err := EffectiveMachineSpec(r.ctx, r.KBClient, r.machineObj)
+	if kerr.IsNotFound(err) {
+		r.machineClassNotFound = true
+		last := r.machineObj.Status.EffectiveSpec
+		if last == nil {
+			// reported by isDriverReady
+			return nil
+		}
+		spec = r.machineObj.Spec.DeepCopy()
+		mergeMachineClass(spec, last, r.machineObj.Namespace)
+	} else if err != nil {
+		return err
+	}
+	r.effectiveSpec = spec
+	r.applyEffectiveSpec()
+	return nil
+}
+
+// applyEffectiveSpec sets the merged spec on the Machine of the request. It is
+// called again whenever the Machine is read back from the API server.
+func (r *machineRequest) applyEffectiveSpec() {
+	if r.effectiveSpec == nil {
+		return
+	}
+	r.machineObj.Spec = *r.effectiveSpec.DeepCopy()
+	r.machineObj.Status.EffectiveSpec = machineClassSpec(r.effectiveSpec)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestMergeMachineClass(t *testing.T) {
+	class := &api.MachineClassSpec{
+		Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+		AuthSecret: &kmapi.ObjectReference{Name: "gcp-auth"},
+		ScriptRef:  &kmapi.ObjectReference{Namespace: "shared", Name: "script"},
+		ScriptKey:  "google-userdata",
+		Parameters: map[string]string{
+			"google-project":      "demo",
+			"google-zone":         "us-central1-a",
+			"google-machine-type": "e2-medium",
+		},
+		Flags: []api.MachineFlag{
+			{Name: "engine-opt", Values: []string{"log-driver=json-file"}},
+			{Name: "google-preemptible", Bool: ptr.To(true)},
+		},
+	}
+	spec := &api.MachineSpec{
+		ClassRef:   &api.MachineClassReference{Name: "gcp"},
+		Parameters: map[string]string{"google-zone": "europe-west1-b", "google-preemptible": "false"},
+		Flags:      []api.MachineFlag{{Name: "engine-label", Values: []string{"team=demo"}}},
+	}
+
+	mergeMachineClass(spec, class, "demo")
+
+	if spec.Driver == nil || spec.Driver.Name != GoogleDriver {
+		t.Errorf("driver = %v, want %s", spec.Driver, GoogleDriver)
+	}
+	if want := (kmapi.ObjectReference{Namespace: "demo", Name: "gcp-auth"}); spec.AuthSecret == nil || *spec.AuthSecret != want {
+		t.Errorf("authSecret = %v, want %v", spec.AuthSecret, want)
+	}
+	if want := (kmapi.ObjectReference{Namespace: "shared", Name: "script"}); spec.ScriptRef == nil || *spec.ScriptRef != want {
+		t.Errorf("scriptRef = %v, want %v", spec.ScriptRef, want)
+	}
+	if spec.ScriptKey != "google-userdata" {
+		t.Errorf("scriptKey = %q, want google-userdata", spec.ScriptKey)
+	}
+	wantParams := map[string]string{
+		"google-project":      "demo",
+		"google-zone":         "europe-west1-b",
+		"google-machine-type": "e2-medium",
+		"google-preemptible":  "false",
+	}
+	if !reflect.DeepEqual(spec.Parameters, wantParams) {
+		t.Errorf("parameters = %v, want %v", spec.Parameters, wantParams)
+	}
+	wantFlags := []api.MachineFlag{
+		{Name: "engine-opt", Values: []string{"log-driver=json-file"}},
+		{Name: "engine-label", Values: []string{"team=demo"}},
+	}
+	if !reflect.DeepEqual(spec.Flags, wantFlags) {
+		t.Errorf("flags = %v, want %v", spec.Flags, wantFlags)
+	}
+
+	// the class is not modified
+	if class.AuthSecret.Namespace != "" || class.Parameters["google-zone"] != "us-central1-a" || len(class.Parameters) != 3 {
+		t.Errorf("mergeMachineClass() modified the class: %+v", class)
+	}
+}
+
+func TestMergeMachineClassMachineWins(t *testing.T) {
+	class := &api.MachineClassSpec{
+		Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+		AuthSecret: &kmapi.ObjectReference{Name: "gcp-auth"},
+		ScriptKey:  "google-userdata",
+		Flags:      []api.MachineFlag{{Name: "engine-opt", Values: []string{"log-driver=json-file"}}},
+	}
+	spec := &api.MachineSpec{
+		Driver:     &core.LocalObjectReference{Name: "other"},
+		AuthSecret: &kmapi.ObjectReference{Namespace: "demo", Name: "own-auth"},
+		ScriptKey:  "userdata",
+		Flags:      []api.MachineFlag{{Name: "engine-opt", Values: []string{"log-driver=journald"}}},
+	}
+	want := spec.DeepCopy()
+
+	mergeMachineClass(spec, class, "demo")
+
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("mergeMachineClass() = %+v, want %+v", spec, want)
+	}
+}
+
+func TestMachineDriverName(t *testing.T) {
+	mc := &api.Machine{
+		Spec: api.MachineSpec{ClassRef: &api.MachineClassReference{Name: "gcp"}},
+	}
+	if got := machineDriverName(mc); got != "" {
+		t.Errorf("machineDriverName() = %q before reconcile, want empty", got)
+	}
+	mc.Status.EffectiveSpec = &api.MachineClassSpec{Driver: &core.LocalObjectReference{Name: GoogleDriver}}
+	if got := machineDriverName(mc); got != GoogleDriver {
+		t.Errorf("machineDriverName() = %q, want %s", got, GoogleDriver)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// machineTemplateHash identifies the template of a MachineDeployment. It is
+// part of the names of the MachineSets of the deployment.
+func machineTemplateHash(tmpl *api.MachineTemplateSpec) (string, error) {
+	data, err := json.Marshal(tmpl)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
+
+// machineSetRevision returns the revision of a MachineSet of a MachineDeployment, 0 if it is not set.
+func machineSetRevision(set *api.MachineSet) int64 {
+	rev, err := strconv.ParseInt(set.Annotations[api.MachineDeploymentRevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return rev
+}
+
+// sortByRevision sorts the MachineSets from the oldest to the newest revision.
+func sortByRevision(sets []*api.MachineSet) {
+	sort.SliceStable(sets, func(i, j int) bool {
+		return machineSetRevision(sets[i]) < machineSetRevision(sets[j])
+	})
+}
+
+func maxRevision(sets []*api.MachineSet) int64 {
+	var rev int64
+	for _, set := range sets {
+		rev = max(rev, machineSetRevision(set))
+	}
+	return rev
+}
+
+// rollingUpdateBounds resolves maxSurge and maxUnavailable of the deployment
+// against its replicas. If both are zero, maxSurge is 1.
+func rollingUpdateBounds(d *api.MachineDeployment) (int32, int32, error) {
+	surge, unavailable := intstr.FromInt32(1), intstr.FromInt32(0)
+	if s := d.Spec.Strategy; s != nil && s.RollingUpdate != nil {
+		if s.RollingUpdate.MaxSurge != nil {
+			surge = *s.RollingUpdate.MaxSurge
+		}
+		if s.RollingUpdate.MaxUnavailable != nil {
+			unavailable = *s.RollingUpdate.MaxUnavailable
+		}
+	}
+	replicas := int(d.GetReplicas())
+	maxSurge, err := intstr.GetScaledValueFromIntOrPercent(&surge, replicas, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(&unavailable, replicas, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if maxSurge == 0 && maxUnavailable == 0 {
+		maxSurge = 1
+	}
+	return int32(maxSurge), int32(min(maxUnavailable, replicas)), nil
+}
+
+func specReplicas(set *api.MachineSet) int32 {
+	if set.Spec.Replicas == nil {
+		return 0
+	}
+	return *set.Spec.Replicas
+}
+
+// rollingUpdateReplicas returns the replicas of the new and of the old MachineSets
+// for the next step of a rolling update. The new set is scaled up as far as
+// maxSurge allows. The old sets, sorted by revision, are scaled down as far as
+// the available Machines allow, i.e. only once the startup scripts of the
+// Machines of the new set succeeded.
+func rollingUpdateReplicas(replicas, maxSurge, maxUnavailable int32, newSet *api.MachineSet, oldSets []*api.MachineSet) (int32, []int32) {
+	newReplicas := specReplicas(newSet)
+	oldReplicas := make([]int32, len(oldSets))
+	var oldTotal int32
+	for i, set := range oldSets {
+		oldReplicas[i] = specReplicas(set)
+		oldTotal += oldReplicas[i]
+	}
+	if oldTotal == 0 {
+		return replicas, oldReplicas
+	}
+
+	if newReplicas < replicas {
+		if room := replicas + maxSurge - newReplicas - oldTotal; room > 0 {
+			return min(replicas, newReplicas+room), oldReplicas
+		}
+	}
+	newReplicas = min(newReplicas, replicas)
+
+	minAvailable := replicas - maxUnavailable
+	newUnavailable := max(newReplicas-newSet.Status.AvailableReplicas, 0)
+	maxScaledDown := newReplicas + oldTotal - minAvailable - newUnavailable
+	// the Machines that are not available are removed first, they do not reduce the availability
+	for i, set := range oldSets {
+		if maxScaledDown <= 0 {
+			return newReplicas, oldReplicas
+		}
+		if unavailable := oldReplicas[i] - set.Status.AvailableReplicas; unavailable > 0 {
+			n := min(unavailable, maxScaledDown)
+			oldReplicas[i] -= n
+			maxScaledDown -= n
+		}
+	}
+
+	available := newSet.Status.AvailableReplicas
+	for i, set := range oldSets {
+		available += min(set.Status.AvailableReplicas, oldReplicas[i])
+	}
+	scaleDown := min(available-minAvailable, maxScaledDown)
+	for i := range oldSets {
+		if scaleDown <= 0 {
+			break
+		}
+		n := min(oldReplicas[i], scaleDown)
+		oldReplicas[i] -= n
+		scaleDown -= n
+	}
+	return newReplicas, oldReplicas
+}
+
+// recreateReplicas returns the replicas of the new and of the old MachineSets
+// for the next step of a Recreate update. The new set is scaled up once all
+// Machines of the old sets are gone.
+func recreateReplicas(replicas int32, newSet *api.MachineSet, oldSets []*api.MachineSet) (int32, []int32) {
+	oldReplicas := make([]int32, len(oldSets))
+	for _, set := range oldSets {
+		if specReplicas(set) > 0 || set.Status.Replicas > 0 {
+			return specReplicas(newSet), oldReplicas
+		}
+	}
+	return replicas, oldReplicas
+}
+
+// machineSetsToPrune returns the oldest scaled down MachineSets beyond the revision history limit.
+func machineSetsToPrune(oldSets []*api.MachineSet, limit int32) []*api.MachineSet {
+	var idle []*api.MachineSet
+	for _, set := range oldSets {
+		if specReplicas(set) == 0 && set.Status.Replicas == 0 {
+			idle = append(idle, set)
+		}
+	}
+	if n := len(idle) - int(limit); n > 0 {
+		sortByRevision(idle)
+		return idle[:n]
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"kmodules.xyz/client-go/conditions/committer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// MachineDeploymentReconciler reconciles a MachineDeployment object
+type MachineDeploymentReconciler struct {
+	KBClient client.Client
+	Scheme   *runtime.Scheme
+	// Recorder records the events of MachineDeployments. A recorder of the manager is used if nil.
+	Recorder record.EventRecorder
+
+	committer func(ctx context.Context, old, obj committer.StatusGetter[*api.MachineDeploymentStatus]) error
+}
+
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinedeployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinedeployments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinedeployments/finalizers,verbs=update
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinesets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile rolls out the template of a MachineDeployment. Every template gets
+// its own MachineSet, named <deployment>-<template hash> and annotated with
+// its revision. The MachineSet of the current template is scaled up while the
+// ones of the previous templates are scaled down, following the update strategy.
+// Scaled down MachineSets are kept up to spec.revisionHistoryLimit for a rollback.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
+func (r *MachineDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	d := &api.MachineDeployment{}
+	if err := r.KBClient.Get(ctx, req.NamespacedName, d); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !d.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	old := d.DeepCopy()
+
+	sets, err := r.listMachineSets(ctx, d)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if d.Spec.RollbackTo != nil {
+		// the changed template is rolled out once the deployment is updated
+		return ctrl.Result{}, r.rollback(ctx, d, sets)
+	}
+
+	hash, err := machineTemplateHash(&d.Spec.Template)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	var newSet *api.MachineSet
+	var oldSets []*api.MachineSet
+	for _, set := range sets {
+		if set.Labels[api.MachineTemplateHashLabel] == hash {
+			newSet = set
+		} else {
+			oldSets = append(oldSets, set)
+		}
+	}
+	sortByRevision(oldSets)
+	if newSet == nil {
+		if newSet, err = r.newMachineSet(d, hash); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if rev := maxRevision(oldSets) + 1; machineSetRevision(newSet) < rev {
+		// a new template or a rollback to the template of an old MachineSet
+		metav1.SetMetaDataAnnotation(&newSet.ObjectMeta, api.MachineDeploymentRevisionAnnotation, strconv.FormatInt(rev, 10))
+	}
+
+	var newReplicas int32
+	var oldReplicas []int32
+	if d.GetStrategyType() == api.MachineDeploymentStrategyRecreate {
+		newReplicas, oldReplicas = recreateReplicas(d.GetReplicas(), newSet, oldSets)
+	} else {
+		maxSurge, maxUnavailable, err := rollingUpdateBounds(d)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		newReplicas, oldReplicas = rollingUpdateReplicas(d.GetReplicas(), maxSurge, maxUnavailable, newSet, oldSets)
+	}
+
+	var errs []error
+	if newSet.UID == "" {
+		newSet.Spec.Replicas = ptr.To(newReplicas)
+		if err := r.KBClient.Create(ctx, newSet); err != nil {
+			r.event(d, core.EventTypeWarning, EventReasonFailedCreate, "Failed to create MachineSet %s: %v", newSet.Name, err)
+			return ctrl.Result{}, err
+		}
+		logger.Info("Created MachineSet", "Name", newSet.Name, "Replicas", newReplicas)
+		r.event(d, core.EventTypeNormal, EventReasonSuccessfulCreate, "Created MachineSet %s with %d replicas", newSet.Name, newReplicas)
+	} else if err := r.scaleMachineSet(ctx, d, newSet, newReplicas); err != nil {
+		errs = append(errs, err)
+	}
+	for i, set := range oldSets {
+		if err := r.scaleMachineSet(ctx, d, set, oldReplicas[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, set := range machineSetsToPrune(oldSets, d.GetRevisionHistoryLimit()) {
+		if err := r.KBClient.Delete(ctx, set); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := r.updateMachineDeploymentStatus(ctx, old, d, newSet, oldSets); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, errors.Join(errs...)
+}
+
+func (r *MachineDeploymentReconciler) listMachineSets(ctx context.Context, d *api.MachineDeployment) ([]*api.MachineSet, error) {
+	var list api.MachineSetList
+	err := r.KBClient.List(ctx, &list, client.InNamespace(d.Namespace), client.MatchingLabels(machineDeploymentLabels(d.Name)))
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]*api.MachineSet, 0, len(list.Items))
+	for i := range list.Items {
+		set := &list.Items[i]
+		if metav1.IsControlledBy(set, d) && set.DeletionTimestamp.IsZero() {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// newMachineSet returns the MachineSet of the template with the given hash. It is created by the caller.
+func (r *MachineDeploymentReconciler) newMachineSet(d *api.MachineDeployment, hash string) (*api.MachineSet, error) {
+	setLabels := machineDeploymentLabels(d.Name)
+	setLabels[api.MachineTemplateHashLabel] = hash
+	tmpl := d.Spec.Template.DeepCopy()
+	if tmpl.ObjectMeta.Labels == nil {
+		tmpl.ObjectMeta.Labels = map[string]string{}
+	}
+	for k, v := range setLabels {
+		tmpl.ObjectMeta.Labels[k] = v
+	}
+	set := &api.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", d.Name, hash),
+			Namespace: d.Namespace,
+			Labels:    setLabels,
+		},
+		Spec: api.MachineSetSpec{
+			Replicas: ptr.To[int32](0),
+			Template: *tmpl,
+		},
+	}
+	if err := controllerutil.SetControllerReference(d, set, r.Scheme); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// scaleMachineSet patches the replicas and the revision of a MachineSet.
+func (r *MachineDeploymentReconciler) scaleMachineSet(ctx context.Context, d *api.MachineDeployment, set *api.MachineSet, replicas int32) error {
+	var current api.MachineSet
+	if err := r.KBClient.Get(ctx, client.ObjectKeyFromObject(set), &current); err != nil {
+		return err
+	}
+	rev := set.Annotations[api.MachineDeploymentRevisionAnnotation]
+	from := specReplicas(&current)
+	if from == replicas && current.Annotations[api.MachineDeploymentRevisionAnnotation] == rev {
+		return nil
+	}
+	patch := client.MergeFrom(current.DeepCopy())
+	current.Spec.Replicas = ptr.To(replicas)
+	metav1.SetMetaDataAnnotation(&current.ObjectMeta, api.MachineDeploymentRevisionAnnotation, rev)
+	if err := r.KBClient.Patch(ctx, &current, patch); err != nil {
+		return err
+	}
+	set.Spec.Replicas = ptr.To(replicas)
+	if from != replicas {
+		direction := "up"
+		if replicas < from {
+			direction = "down"
+		}
+		r.event(d, core.EventTypeNormal, EventReasonScalingMachineSet, "Scaled %s MachineSet %s from %d to %d", direction, set.Name, from, replicas)
+	}
+	return nil
+}
+
+// rollback replaces the template of the deployment with the one of the
+// requested revision and clears spec.rollbackTo.
+func (r *MachineDeploymentReconciler) rollback(ctx context.Context, d *api.MachineDeployment, sets []*api.MachineSet) error {
+	sortByRevision(sets)
+	revision := d.Spec.RollbackTo.Revision
+	if revision == 0 && len(sets) > 1 {
+		// the previous revision
+		revision = machineSetRevision(sets[len(sets)-2])
+	}
+	var target *api.MachineSet
+	for _, set := range sets {
+		if revision != 0 && machineSetRevision(set) == revision {
+			target = set
+		}
+	}
+
+	if target == nil {
+		r.event(d, core.EventTypeWarning, EventReasonRollbackRevisionNotFound, "Unable to find revision %d to roll back to", d.Spec.RollbackTo.Revision)
+	} else {
+		tmpl := target.Spec.Template.DeepCopy()
+		delete(tmpl.ObjectMeta.Labels, api.MachineDeploymentNameLabel)
+		delete(tmpl.ObjectMeta.Labels, api.MachineTemplateHashLabel)
+		if len(tmpl.ObjectMeta.Labels) == 0 {
+			tmpl.ObjectMeta.Labels = nil
+		}
+		d.Spec.Template = *tmpl
+		r.event(d, core.EventTypeNormal, EventReasonRolledBack, "Rolled back to revision %d", revision)
+	}
+	d.Spec.RollbackTo = nil
+	return r.KBClient.Update(ctx, d)
+}
+
+func (r *MachineDeploymentReconciler) updateMachineDeploymentStatus(ctx context.Context, old, d *api.MachineDeployment, newSet *api.MachineSet, oldSets []*api.MachineSet) error {
+	var status api.MachineDeploymentStatus
+	for _, set := range append([]*api.MachineSet{newSet}, oldSets...) {
+		status.Replicas += set.Status.Replicas
+		status.ReadyReplicas += set.Status.ReadyReplicas
+		status.AvailableReplicas += set.Status.AvailableReplicas
+	}
+	status.UpdatedReplicas = newSet.Status.Replicas
+	status.UnavailableReplicas = max(d.GetReplicas()-status.AvailableReplicas, 0)
+	status.Revision = machineSetRevision(newSet)
+	status.ObservedGeneration = d.Generation
+	status.Selector = labels.SelectorFromSet(machineDeploymentLabels(d.Name)).String()
+	d.Status = status
+	return r.committer(ctx, old, d)
+}
+
+func machineDeploymentLabels(name string) map[string]string {
+	return map[string]string{api.MachineDeploymentNameLabel: name}
+}
+
+// event records an event for the MachineDeployment. The message is truncated.
+func (r *MachineDeploymentReconciler) event(d *api.MachineDeployment, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(d, eventType, reason, truncateMessage(fmt.Sprintf(messageFmt, args...)))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MachineDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.committer = committer.NewStatusCommitter[*api.MachineDeployment, *api.MachineDeploymentStatus](r.KBClient.Status())
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machinedeployment-controller")
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&api.MachineDeployment{}).
+		Owns(&api.MachineSet{}).
+		Complete(r)
+}
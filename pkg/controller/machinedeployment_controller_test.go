@@ -0,0 +1,137 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"path"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("MachineDeploymentReconciler", func() {
+	var (
+		ctx context.Context
+		ns  string
+		d   *api.MachineDeployment
+	)
+
+	getDeployment := func(g Gomega) *api.MachineDeployment {
+		var got api.MachineDeployment
+		g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(d), &got)).To(Succeed())
+		return &got
+	}
+
+	// completeScripts reports a successful startup script for every Machine of the deployment.
+	completeScripts := func(g Gomega) {
+		var list api.MachineList
+		g.Expect(k8sClient.List(ctx, &list, client.InNamespace(ns), client.MatchingLabels{api.MachineDeploymentNameLabel: d.Name})).To(Succeed())
+		for _, mc := range list.Items {
+			_ = fakeExecutor.SetFile(path.Join(ns, mc.Name), remoteResultFile, []byte(`{"version":"v1","exitCode":0}`))
+		}
+	}
+
+	expectRollout := func(revision int64) *api.MachineDeployment {
+		var got *api.MachineDeployment
+		Eventually(func(g Gomega) {
+			completeScripts(g)
+			got = getDeployment(g)
+			g.Expect(got.Status.Revision).To(Equal(revision))
+			g.Expect(got.Status.Replicas).To(BeEquivalentTo(2))
+			g.Expect(got.Status.UpdatedReplicas).To(BeEquivalentTo(2))
+			g.Expect(got.Status.AvailableReplicas).To(BeEquivalentTo(2))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		return got
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "machinedeployment-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+		ns = namespace.Name
+
+		Expect(k8sClient.Create(ctx, &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: GoogleDriver, Namespace: ns},
+			Spec:       api.DriverSpec{Builtin: true},
+		})).To(Succeed())
+		for name, data := range map[string]map[string][]byte{
+			"cred":   {"service-account.json": []byte(`{"type":"service_account"}`)},
+			"script": {"google-userdata": []byte("#!/bin/sh\necho hello")},
+		} {
+			Expect(k8sClient.Create(ctx, &core.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}, Data: data})).To(Succeed())
+		}
+
+		d = &api.MachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: ns},
+			Spec: api.MachineDeploymentSpec{
+				Replicas: ptr.To[int32](2),
+				Template: api.MachineTemplateSpec{
+					Spec: api.MachineSpec{
+						Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+						AuthSecret: &kmapi.ObjectReference{Name: "cred", Namespace: ns},
+						ScriptRef:  &kmapi.ObjectReference{Name: "script", Namespace: ns},
+						Parameters: map[string]string{"google-project": "demo", "google-zone": "us-central1-a"},
+					},
+				},
+			},
+		}
+	})
+
+	It("rolls out a changed template and rolls it back", func() {
+		Expect(k8sClient.Create(ctx, d)).To(Succeed())
+		first := expectRollout(1)
+
+		By("changing the zone")
+		Eventually(func(g Gomega) {
+			got := getDeployment(g)
+			got.Spec.Template.Spec.Parameters["google-zone"] = "europe-west1-b"
+			g.Expect(k8sClient.Update(ctx, got)).To(Succeed())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		expectRollout(2)
+		Eventually(func(g Gomega) {
+			var sets api.MachineSetList
+			g.Expect(k8sClient.List(ctx, &sets, client.InNamespace(ns))).To(Succeed())
+			g.Expect(sets.Items).To(HaveLen(2))
+			for _, set := range sets.Items {
+				if set.Annotations[api.MachineDeploymentRevisionAnnotation] == "1" {
+					g.Expect(set.Status.Replicas).To(BeZero())
+				}
+			}
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+		By("rolling back to the previous revision")
+		Eventually(func(g Gomega) {
+			got := getDeployment(g)
+			got.Spec.RollbackTo = &api.MachineDeploymentRollback{}
+			g.Expect(k8sClient.Update(ctx, got)).To(Succeed())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		expectRollout(3)
+		got := getDeployment(Default)
+		Expect(got.Spec.RollbackTo).To(BeNil())
+		Expect(got.Spec.Template).To(Equal(first.Spec.Template))
+	})
+})
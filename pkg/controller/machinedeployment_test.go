@@ -0,0 +1,158 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func testMachineSet(name string, revision string, replicas, available int32) *api.MachineSet {
+	return &api.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{api.MachineDeploymentRevisionAnnotation: revision},
+		},
+		Spec:   api.MachineSetSpec{Replicas: ptr.To(replicas)},
+		Status: api.MachineSetStatus{Replicas: replicas, AvailableReplicas: available},
+	}
+}
+
+func TestRollingUpdateBounds(t *testing.T) {
+	tests := []struct {
+		name                       string
+		rollingUpdate              *api.MachineRollingUpdate
+		wantSurge, wantUnavailable int32
+	}{
+		{name: "defaults", wantSurge: 1},
+		{
+			name:            "percentages",
+			rollingUpdate:   &api.MachineRollingUpdate{MaxSurge: ptr.To(intstr.FromString("25%")), MaxUnavailable: ptr.To(intstr.FromString("25%"))},
+			wantSurge:       2,
+			wantUnavailable: 1,
+		},
+		{
+			name:          "both zero",
+			rollingUpdate: &api.MachineRollingUpdate{MaxSurge: ptr.To(intstr.FromInt32(0))},
+			wantSurge:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &api.MachineDeployment{Spec: api.MachineDeploymentSpec{
+				Replicas: ptr.To[int32](5),
+				Strategy: &api.MachineDeploymentStrategy{RollingUpdate: tt.rollingUpdate},
+			}}
+			surge, unavailable, err := rollingUpdateBounds(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if surge != tt.wantSurge || unavailable != tt.wantUnavailable {
+				t.Errorf("rollingUpdateBounds() = %d, %d, want %d, %d", surge, unavailable, tt.wantSurge, tt.wantUnavailable)
+			}
+		})
+	}
+}
+
+// TestRollingUpdateReplicas rolls out a new MachineSet step by step. The
+// Machines of the new set only become available every other step.
+func TestRollingUpdateReplicas(t *testing.T) {
+	const replicas, maxSurge, maxUnavailable = 3, 1, 0
+	newSet := testMachineSet("new", "2", 0, 0)
+	oldSet := testMachineSet("old", "1", replicas, replicas)
+
+	for step := 0; step < 20; step++ {
+		newReplicas, oldReplicas := rollingUpdateReplicas(replicas, maxSurge, maxUnavailable, newSet, []*api.MachineSet{oldSet})
+		if total := newReplicas + oldReplicas[0]; total > replicas+maxSurge {
+			t.Fatalf("step %d: %d machines exceed maxSurge", step, total)
+		}
+		if oldReplicas[0] < *oldSet.Spec.Replicas && newSet.Status.AvailableReplicas+oldReplicas[0] < replicas-maxUnavailable {
+			t.Fatalf("step %d: old set scaled down to %d with %d available new machines", step, oldReplicas[0], newSet.Status.AvailableReplicas)
+		}
+		newSet.Spec.Replicas, oldSet.Spec.Replicas = ptr.To(newReplicas), ptr.To(oldReplicas[0])
+		oldSet.Status.Replicas, oldSet.Status.AvailableReplicas = oldReplicas[0], oldReplicas[0]
+		newSet.Status.Replicas = newReplicas
+		if step%2 == 1 {
+			newSet.Status.AvailableReplicas = newReplicas
+		}
+		if newReplicas == replicas && oldReplicas[0] == 0 {
+			return
+		}
+	}
+	t.Errorf("rollout did not finish, new set has %d and old set %d replicas", *newSet.Spec.Replicas, *oldSet.Spec.Replicas)
+}
+
+func TestRollingUpdateReplicasRemovesUnavailableFirst(t *testing.T) {
+	newSet := testMachineSet("new", "3", 1, 0)
+	oldSets := []*api.MachineSet{
+		testMachineSet("oldest", "1", 2, 0),
+		testMachineSet("old", "2", 2, 2),
+	}
+	newReplicas, oldReplicas := rollingUpdateReplicas(4, 1, 1, newSet, oldSets)
+	if newReplicas != 1 || !reflect.DeepEqual(oldReplicas, []int32{1, 2}) {
+		t.Errorf("rollingUpdateReplicas() = %d, %v, want 1, [1 2]", newReplicas, oldReplicas)
+	}
+}
+
+func TestRecreateReplicas(t *testing.T) {
+	newSet := testMachineSet("new", "2", 0, 0)
+	oldSet := testMachineSet("old", "1", 2, 2)
+	if n, old := recreateReplicas(2, newSet, []*api.MachineSet{oldSet}); n != 0 || old[0] != 0 {
+		t.Errorf("recreateReplicas() = %d, %v with old machines, want 0, [0]", n, old)
+	}
+	oldSet = testMachineSet("old", "1", 0, 0)
+	if n, _ := recreateReplicas(2, newSet, []*api.MachineSet{oldSet}); n != 2 {
+		t.Errorf("recreateReplicas() = %d without old machines, want 2", n)
+	}
+}
+
+func TestMachineSetsToPrune(t *testing.T) {
+	oldSets := []*api.MachineSet{
+		testMachineSet("rev-3", "3", 0, 0),
+		testMachineSet("rev-1", "1", 0, 0),
+		testMachineSet("rev-2", "2", 1, 1),
+		testMachineSet("rev-4", "4", 0, 0),
+	}
+	var got []string
+	for _, set := range machineSetsToPrune(oldSets, 1) {
+		got = append(got, set.Name)
+	}
+	if want := []string{"rev-1", "rev-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("machineSetsToPrune() = %v, want %v", got, want)
+	}
+}
+
+func TestMachineTemplateHash(t *testing.T) {
+	tmpl := &api.MachineTemplateSpec{Spec: api.MachineSpec{Parameters: map[string]string{"google-zone": "us-central1-a", "google-project": "demo"}}}
+	hash, err := machineTemplateHash(tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := machineTemplateHash(tmpl.DeepCopy()); again != hash {
+		t.Errorf("machineTemplateHash() = %s for the same template, want %s", again, hash)
+	}
+	tmpl.Spec.Parameters["google-zone"] = "europe-west1-b"
+	if changed, _ := machineTemplateHash(tmpl); changed == hash {
+		t.Error("machineTemplateHash() did not change with the template")
+	}
+}
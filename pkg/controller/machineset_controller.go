@@ -0,0 +1,298 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	cutil "kmodules.xyz/client-go/conditions"
+	"kmodules.xyz/client-go/conditions/committer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// MachineSetReconciler reconciles a MachineSet object
+type MachineSetReconciler struct {
+	KBClient client.Client
+	Scheme   *runtime.Scheme
+	// Reader lists the Machines of a set. It bypasses the cache, so that a set
+	// is not scaled twice for a Machine the cache has not seen yet. The API
+	// reader of the manager is used if nil.
+	Reader client.Reader
+	// Recorder records the events of MachineSets. A recorder of the manager is used if nil.
+	Recorder record.EventRecorder
+
+	committer func(ctx context.Context, old, obj committer.StatusGetter[*api.MachineSetStatus]) error
+}
+
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinesets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinesets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machinesets/finalizers,verbs=update
+//+kubebuilder:rbac:groups=docker-machine.klusters.dev,resources=machines,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile creates and deletes the Machines of a MachineSet until the set has
+// spec.replicas Machines. The Machines are named <set>-<ordinal>, new Machines
+// take the lowest free ordinals. Failed Machines are deleted first, then the
+// newest ones. The Machines of a deleted set are removed by the garbage collector.
+//
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
+func (r *MachineSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	set := &api.MachineSet{}
+	if err := r.KBClient.Get(ctx, req.NamespacedName, set); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !set.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	old := set.DeepCopy()
+
+	machines, err := r.listMachines(ctx, set)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	active := activeMachines(machines)
+
+	switch diff := int(set.GetReplicas()) - len(active); {
+	case diff > 0:
+		logger.Info("Scaling up", "Replicas", set.GetReplicas(), "Current", len(active))
+		err = r.createMachines(ctx, set, nextMachineNames(set.Name, machines, diff))
+	case diff < 0:
+		logger.Info("Scaling down", "Replicas", set.GetReplicas(), "Current", len(active))
+		err = r.deleteMachines(ctx, set, machinesToDelete(active, -diff))
+	}
+
+	if updErr := r.updateMachineSetStatus(ctx, old, set, active); updErr != nil {
+		return ctrl.Result{}, updErr
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *MachineSetReconciler) listMachines(ctx context.Context, set *api.MachineSet) ([]api.Machine, error) {
+	var list api.MachineList
+	err := r.Reader.List(ctx, &list, client.InNamespace(set.Namespace), client.MatchingLabels(machineSetLabels(set.Name)))
+	if err != nil {
+		return nil, err
+	}
+	machines := make([]api.Machine, 0, len(list.Items))
+	for _, mc := range list.Items {
+		if metav1.IsControlledBy(&mc, set) {
+			machines = append(machines, mc)
+		}
+	}
+	return machines, nil
+}
+
+func (r *MachineSetReconciler) createMachines(ctx context.Context, set *api.MachineSet, names []string) error {
+	var errs []error
+	for _, name := range names {
+		mc, err := r.newMachine(set, name)
+		if err == nil {
+			err = r.KBClient.Create(ctx, mc)
+		}
+		if kerr.IsAlreadyExists(err) {
+			// created by a previous reconcile the reader has not seen yet
+			continue
+		}
+		if err != nil {
+			r.event(set, core.EventTypeWarning, EventReasonFailedCreate, "Failed to create Machine %s: %v", name, err)
+			errs = append(errs, err)
+			continue
+		}
+		r.event(set, core.EventTypeNormal, EventReasonSuccessfulCreate, "Created Machine %s", name)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *MachineSetReconciler) deleteMachines(ctx context.Context, set *api.MachineSet, machines []api.Machine) error {
+	var errs []error
+	for i := range machines {
+		mc := &machines[i]
+		err := r.KBClient.Delete(ctx, mc, client.Preconditions{UID: &mc.UID})
+		if kerr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			r.event(set, core.EventTypeWarning, EventReasonFailedDelete, "Failed to delete Machine %s: %v", mc.Name, err)
+			errs = append(errs, err)
+			continue
+		}
+		r.event(set, core.EventTypeNormal, EventReasonSuccessfulDelete, "Deleted Machine %s", mc.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// newMachine returns the Machine of the set with the given name, created from the template.
+func (r *MachineSetReconciler) newMachine(set *api.MachineSet, name string) (*api.Machine, error) {
+	tmpl := set.Spec.Template.DeepCopy()
+	mc := &api.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   set.Namespace,
+			Labels:      tmpl.ObjectMeta.Labels,
+			Annotations: tmpl.ObjectMeta.Annotations,
+		},
+		Spec: tmpl.Spec,
+	}
+	if mc.Labels == nil {
+		mc.Labels = map[string]string{}
+	}
+	for k, v := range machineSetLabels(set.Name) {
+		mc.Labels[k] = v
+	}
+	if err := controllerutil.SetControllerReference(set, mc, r.Scheme); err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
+
+func (r *MachineSetReconciler) updateMachineSetStatus(ctx context.Context, old, set *api.MachineSet, machines []api.Machine) error {
+	set.Status = machineSetStatus(machines)
+	set.Status.ObservedGeneration = set.Generation
+	set.Status.Selector = labels.SelectorFromSet(machineSetLabels(set.Name)).String()
+	return r.committer(ctx, old, set)
+}
+
+// machineSetStatus counts the Machines of a set by their state.
+func machineSetStatus(machines []api.Machine) api.MachineSetStatus {
+	var status api.MachineSetStatus
+	for i := range machines {
+		mc := &machines[i]
+		status.Replicas++
+		if cutil.IsConditionTrue(mc.Status.Conditions, string(api.MachineConditionTypeMachineReady)) {
+			status.ReadyReplicas++
+		}
+		if cutil.IsConditionTrue(mc.Status.Conditions, string(api.MachineConditionTypeClusterOperationComplete)) {
+			status.AvailableReplicas++
+		}
+		if isMachineFailed(mc) {
+			status.FailedReplicas++
+		}
+	}
+	return status
+}
+
+func machineSetLabels(name string) map[string]string {
+	return map[string]string{api.MachineSetNameLabel: name}
+}
+
+// activeMachines returns the Machines that are not being deleted.
+func activeMachines(machines []api.Machine) []api.Machine {
+	active := make([]api.Machine, 0, len(machines))
+	for _, mc := range machines {
+		if mc.DeletionTimestamp.IsZero() {
+			active = append(active, mc)
+		}
+	}
+	return active
+}
+
+func isMachineFailed(mc *api.Machine) bool {
+	return mc.Status.Phase == api.MachinePhaseFailed || mc.Status.Phase == api.MachinePhaseClusterOperationFailed
+}
+
+// nextMachineNames returns n names <set>-<ordinal> with the lowest ordinals not
+// used by the machines. Machines being deleted keep their names until they are gone.
+func nextMachineNames(setName string, machines []api.Machine, n int) []string {
+	used := map[int]bool{}
+	for _, mc := range machines {
+		if ordinal, ok := machineOrdinal(setName, mc.Name); ok {
+			used[ordinal] = true
+		}
+	}
+	names := make([]string, 0, n)
+	for i := 0; len(names) < n; i++ {
+		if !used[i] {
+			names = append(names, fmt.Sprintf("%s-%d", setName, i))
+		}
+	}
+	return names
+}
+
+func machineOrdinal(setName, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, setName+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// machinesToDelete returns the n machines a set is scaled down by: the failed
+// machines first, then the newest ones.
+func machinesToDelete(machines []api.Machine, n int) []api.Machine {
+	sorted := append([]api.Machine(nil), machines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if fi, fj := isMachineFailed(&sorted[i]), isMachineFailed(&sorted[j]); fi != fj {
+			return fi
+		}
+		ti, tj := sorted[i].CreationTimestamp, sorted[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return sorted[i].Name > sorted[j].Name
+	})
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	return sorted[:n]
+}
+
+// event records an event for the MachineSet. The message is truncated.
+func (r *MachineSetReconciler) event(set *api.MachineSet, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Event(set, eventType, reason, truncateMessage(fmt.Sprintf(messageFmt, args...)))
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MachineSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Reader == nil {
+		r.Reader = mgr.GetAPIReader()
+	}
+	r.committer = committer.NewStatusCommitter[*api.MachineSet, *api.MachineSetStatus](r.KBClient.Status())
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("machineset-controller")
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&api.MachineSet{}).
+		Owns(&api.Machine{}).
+		Complete(r)
+}
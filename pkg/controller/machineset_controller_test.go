@@ -0,0 +1,123 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"path"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	autoscaling "k8s.io/api/autoscaling/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("MachineSetReconciler", func() {
+	var (
+		ctx context.Context
+		ns  string
+		set *api.MachineSet
+	)
+
+	machineNames := func(g Gomega) []string {
+		var list api.MachineList
+		g.Expect(k8sClient.List(ctx, &list, client.InNamespace(ns), client.MatchingLabels{api.MachineSetNameLabel: set.Name})).To(Succeed())
+		var names []string
+		for _, mc := range list.Items {
+			if mc.DeletionTimestamp.IsZero() {
+				names = append(names, mc.Name)
+			}
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "machineset-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+		ns = namespace.Name
+
+		Expect(k8sClient.Create(ctx, &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: GoogleDriver, Namespace: ns},
+			Spec:       api.DriverSpec{Builtin: true},
+		})).To(Succeed())
+		for name, data := range map[string]map[string][]byte{
+			"cred":   {"service-account.json": []byte(`{"type":"service_account"}`)},
+			"script": {"google-userdata": []byte("#!/bin/sh\necho hello")},
+		} {
+			Expect(k8sClient.Create(ctx, &core.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns}, Data: data})).To(Succeed())
+		}
+
+		set = &api.MachineSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: ns},
+			Spec: api.MachineSetSpec{
+				Replicas: ptr.To[int32](2),
+				Template: api.MachineTemplateSpec{
+					ObjectMeta: api.MachineTemplateMeta{Labels: map[string]string{"role": "worker"}},
+					Spec: api.MachineSpec{
+						Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+						AuthSecret: &kmapi.ObjectReference{Name: "cred", Namespace: ns},
+						ScriptRef:  &kmapi.ObjectReference{Name: "script", Namespace: ns},
+						Parameters: map[string]string{"google-project": "demo"},
+					},
+				},
+			},
+		}
+	})
+
+	It("scales the Machines of the set", func() {
+		Expect(k8sClient.Create(ctx, set)).To(Succeed())
+		Eventually(machineNames).WithTimeout(timeout).WithPolling(interval).Should(ConsistOf("workers-0", "workers-1"))
+
+		By("completing the startup scripts")
+		for _, name := range []string{"workers-0", "workers-1"} {
+			Eventually(func() error {
+				return fakeExecutor.SetFile(path.Join(ns, name), remoteResultFile, []byte(`{"version":"v1","exitCode":0}`))
+			}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+		}
+		Eventually(func(g Gomega) {
+			var got api.MachineSet
+			g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(set), &got)).To(Succeed())
+			g.Expect(got.Status.Replicas).To(BeEquivalentTo(2))
+			g.Expect(got.Status.ReadyReplicas).To(BeEquivalentTo(2))
+			g.Expect(got.Status.AvailableReplicas).To(BeEquivalentTo(2))
+			g.Expect(got.Status.Selector).To(Equal(api.MachineSetNameLabel + "=workers"))
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+
+		By("scaling up with the scale subresource")
+		scale := &autoscaling.Scale{Spec: autoscaling.ScaleSpec{Replicas: 3}}
+		Expect(k8sClient.SubResource("scale").Update(ctx, set, client.WithSubResourceBody(scale))).To(Succeed())
+		Eventually(machineNames).WithTimeout(timeout).WithPolling(interval).Should(ConsistOf("workers-0", "workers-1", "workers-2"))
+
+		By("scaling down")
+		scale = &autoscaling.Scale{Spec: autoscaling.ScaleSpec{Replicas: 1}}
+		Expect(k8sClient.SubResource("scale").Update(ctx, set, client.WithSubResourceBody(scale))).To(Succeed())
+		Eventually(machineNames).WithTimeout(timeout).WithPolling(interval).Should(ConsistOf("workers-0"))
+		Eventually(func(g Gomega) {
+			g.Expect(fakeExecutor.ActionsFor(fake.VerbRemove, path.Join(ns, "workers-2"))).NotTo(BeEmpty())
+		}).WithTimeout(timeout).WithPolling(interval).Should(Succeed())
+	})
+})
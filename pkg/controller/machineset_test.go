@@ -0,0 +1,67 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextMachineNames(t *testing.T) {
+	machines := []api.Machine{
+		{ObjectMeta: metav1.ObjectMeta{Name: "workers-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "workers-2", DeletionTimestamp: &metav1.Time{Time: time.Now()}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "workers-x"}},
+	}
+	want := []string{"workers-1", "workers-3", "workers-4"}
+	if got := nextMachineNames("workers", machines, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("nextMachineNames() = %v, want %v", got, want)
+	}
+}
+
+func TestMachinesToDelete(t *testing.T) {
+	now := time.Now()
+	machine := func(name string, age time.Duration, phase api.MachinePhase) api.Machine {
+		return api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+			Status:     api.MachineStatus{Phase: phase},
+		}
+	}
+	machines := []api.Machine{
+		machine("workers-0", 3*time.Hour, api.MachinePhaseSuccess),
+		machine("workers-1", 2*time.Hour, api.MachinePhaseFailed),
+		machine("workers-2", time.Hour, api.MachinePhaseSuccess),
+		machine("workers-3", time.Hour, api.MachinePhaseInProgress),
+	}
+
+	var got []string
+	for _, mc := range machinesToDelete(machines, 3) {
+		got = append(got, mc.Name)
+	}
+	want := []string{"workers-1", "workers-3", "workers-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("machinesToDelete() = %v, want %v", got, want)
+	}
+	if n := len(machinesToDelete(machines, 10)); n != len(machines) {
+		t.Errorf("machinesToDelete() returned %d machines, want %d", n, len(machines))
+	}
+}
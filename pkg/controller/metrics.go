@@ -0,0 +1,255 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	cutil "kmodules.xyz/client-go/conditions"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "docker_machine_operator"
+
+const (
+	cloudProviderAWS   = "aws"
+	cloudProviderAzure = "azure"
+)
+
+var (
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the create and delete operations of Machines.",
+		Buckets:   []float64{30, 60, 120, 300, 600, 900, 1800},
+	}, []string{"operation", "driver", "result"})
+
+	commandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "docker-machine commands run for Machines by exit code. Commands that did not exit have the exit code \"error\".",
+	}, []string{"command", "exit_code"})
+
+	scriptWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "script_wait_seconds",
+		Help:      "Time from the creation of a Machine until its startup script reported a result.",
+		Buckets:   prometheus.ExponentialBuckets(30, 2, 8),
+	}, []string{"driver", "result"})
+
+	cloudAPICallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cloud_api_calls_total",
+		Help:      "Calls to the AWS and Azure APIs.",
+	}, []string{"provider", "operation"})
+
+	cloudAPIErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cloud_api_errors_total",
+		Help:      "Failed calls to the AWS and Azure APIs.",
+	}, []string{"provider", "operation"})
+
+	orphanedResourcesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orphaned_resources_total",
+		Help:      "Cloud resources left behind by docker-machine rm that were found and deleted by the operator.",
+	}, []string{"kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		operationDuration,
+		commandsTotal,
+		scriptWaitDuration,
+		cloudAPICallsTotal,
+		cloudAPIErrorsTotal,
+		orphanedResourcesTotal,
+	)
+}
+
+func metricResult(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// observeCloudAPICall records a call to the API of a cloud provider.
+func observeCloudAPICall(provider, operation string, err error) {
+	cloudAPICallsTotal.WithLabelValues(provider, operation).Inc()
+	if err != nil {
+		cloudAPIErrorsTotal.WithLabelValues(provider, operation).Inc()
+	}
+}
+
+// observeScriptResult records the time the startup script of a Machine took since the Machine was created.
+func observeScriptResult(machine *api.Machine, exitCode int32) {
+	created := cutil.GetLastTransitionTime(machine, api.MachineConditionTypeMachineReady)
+	if created == nil {
+		return
+	}
+	result := "success"
+	if exitCode != 0 {
+		result = "failure"
+	}
+	scriptWaitDuration.WithLabelValues(machine.Spec.Driver.Name, result).Observe(time.Since(created.Time).Seconds())
+}
+
+// machineCollector reports the number of Machines by phase and driver when scraped.
+type machineCollector struct {
+	client client.Reader
+	desc   *prometheus.Desc
+}
+
+func newMachineCollector(c client.Reader) *machineCollector {
+	return &machineCollector{
+		client: c,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "machines"),
+			"Number of Machines by phase and driver.", []string{"phase", "driver"}, nil),
+	}
+}
+
+func (c *machineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *machineCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var machines api.MachineList
+	if err := c.client.List(ctx, &machines); err != nil {
+		klog.Errorf("failed to list machines for metrics: %v", err)
+		return
+	}
+	type key struct{ phase, driver string }
+	counts := map[key]int{}
+	for _, mc := range machines.Items {
+		counts[key{phase: string(mc.Status.Phase), driver: machineDriverName(&mc)}]++
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), k.phase, k.driver)
+	}
+}
+
+// registerMachineCollector registers the collector of Machines once per process.
+func registerMachineCollector(c client.Reader) error {
+	err := metrics.Registry.Register(newMachineCollector(c))
+	if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		return nil
+	}
+	return err
+}
+
+// instrumentedExecutor counts the docker-machine commands by exit code.
+type instrumentedExecutor struct {
+	executor.Executor
+}
+
+func instrumentExecutor(e executor.Executor) executor.Executor {
+	if _, ok := e.(*instrumentedExecutor); ok {
+		return e
+	}
+	return &instrumentedExecutor{Executor: e}
+}
+
+func observeCommand(command string, err error) {
+	code := "0"
+	var exitErr *executor.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = strconv.Itoa(exitErr.ExitCode)
+	case err != nil:
+		code = "error"
+	}
+	commandsTotal.WithLabelValues(command, code).Inc()
+}
+
+func (e *instrumentedExecutor) Create(ctx context.Context, m *api.Machine, opts executor.CreateOptions) error {
+	err := e.Executor.Create(ctx, m, opts)
+	observeCommand("create", err)
+	return err
+}
+
+func (e *instrumentedExecutor) Remove(ctx context.Context, m *api.Machine, opts executor.Options) error {
+	err := e.Executor.Remove(ctx, m, opts)
+	observeCommand("rm", err)
+	return err
+}
+
+func (e *instrumentedExecutor) SSH(ctx context.Context, m *api.Machine, opts executor.Options, command ...string) ([]byte, error) {
+	out, err := e.Executor.SSH(ctx, m, opts, command...)
+	observeCommand("ssh", err)
+	return out, err
+}
+
+func (e *instrumentedExecutor) SCP(ctx context.Context, m *api.Machine, opts executor.Options, src, dst string) error {
+	err := e.Executor.SCP(ctx, m, opts, src, dst)
+	observeCommand("scp", err)
+	return err
+}
+
+func (e *instrumentedExecutor) Inspect(ctx context.Context, m *api.Machine, opts executor.Options) ([]byte, error) {
+	out, err := e.Executor.Inspect(ctx, m, opts)
+	observeCommand("inspect", err)
+	return out, err
+}
+
+func (e *instrumentedExecutor) URL(ctx context.Context, m *api.Machine, opts executor.Options) (string, error) {
+	out, err := e.Executor.URL(ctx, m, opts)
+	observeCommand("url", err)
+	return out, err
+}
+
+func (e *instrumentedExecutor) IP(ctx context.Context, m *api.Machine, opts executor.Options) (string, error) {
+	out, err := e.Executor.IP(ctx, m, opts)
+	observeCommand("ip", err)
+	return out, err
+}
+
+func (e *instrumentedExecutor) Status(ctx context.Context, m *api.Machine, opts executor.Options) (string, error) {
+	out, err := e.Executor.Status(ctx, m, opts)
+	observeCommand("status", err)
+	return out, err
+}
+
+func (e *instrumentedExecutor) Start(ctx context.Context, m *api.Machine, opts executor.Options) error {
+	err := e.Executor.Start(ctx, m, opts)
+	observeCommand("start", err)
+	return err
+}
+
+func (e *instrumentedExecutor) Stop(ctx context.Context, m *api.Machine, opts executor.Options) error {
+	err := e.Executor.Stop(ctx, m, opts)
+	observeCommand("stop", err)
+	return err
+}
+
+func (e *instrumentedExecutor) Restart(ctx context.Context, m *api.Machine, opts executor.Options) error {
+	err := e.Executor.Restart(ctx, m, opts)
+	observeCommand("restart", err)
+	return err
+}
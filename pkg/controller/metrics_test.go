@@ -0,0 +1,118 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// machineLister lists Machines from memory.
+type machineLister []api.Machine
+
+func (l machineLister) Get(context.Context, client.ObjectKey, client.Object, ...client.GetOption) error {
+	return errors.New("not implemented")
+}
+
+func (l machineLister) List(_ context.Context, list client.ObjectList, _ ...client.ListOption) error {
+	list.(*api.MachineList).Items = append([]api.Machine(nil), l...)
+	return nil
+}
+
+func TestMachineCollector(t *testing.T) {
+	machine := func(name, driver string, phase api.MachinePhase) api.Machine {
+		return api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       api.MachineSpec{Driver: &core.LocalObjectReference{Name: driver}},
+			Status:     api.MachineStatus{Phase: phase},
+		}
+	}
+	c := newMachineCollector(machineLister{
+		machine("a", GoogleDriver, api.MachinePhaseSuccess),
+		machine("b", GoogleDriver, api.MachinePhaseSuccess),
+		machine("c", AWSDriver, api.MachinePhaseFailed),
+	})
+
+	want := `
+# HELP docker_machine_operator_machines Number of Machines by phase and driver.
+# TYPE docker_machine_operator_machines gauge
+docker_machine_operator_machines{driver="amazonec2",phase="Failed"} 1
+docker_machine_operator_machines{driver="google",phase="Success"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInstrumentedExecutor(t *testing.T) {
+	fe := fake.NewExecutor()
+	fe.PrependReaction(fake.VerbSSH, fake.ExitWith(3, "connection refused"))
+	fe.PrependReaction(fake.VerbInspect, fake.Fail(context.DeadlineExceeded))
+	e := instrumentExecutor(fe)
+	if instrumentExecutor(e) != e {
+		t.Error("executor is instrumented twice")
+	}
+
+	machine := &api.Machine{ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "demo"}}
+	before := testutil.ToFloat64(commandsTotal.WithLabelValues("create", "0"))
+	if err := e.Create(context.Background(), machine, executor.CreateOptions{Driver: GoogleDriver}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(commandsTotal.WithLabelValues("create", "0")) - before; got != 1 {
+		t.Errorf("successful creates = %v, want 1", got)
+	}
+
+	before = testutil.ToFloat64(commandsTotal.WithLabelValues("ssh", "3"))
+	if _, err := e.SSH(context.Background(), machine, executor.Options{}, "true"); err == nil {
+		t.Fatal("expected ssh to fail")
+	}
+	if got := testutil.ToFloat64(commandsTotal.WithLabelValues("ssh", "3")) - before; got != 1 {
+		t.Errorf("ssh with exit code 3 = %v, want 1", got)
+	}
+
+	before = testutil.ToFloat64(commandsTotal.WithLabelValues("inspect", "error"))
+	if _, err := e.Inspect(context.Background(), machine, executor.Options{}); err == nil {
+		t.Fatal("expected inspect to fail")
+	}
+	if got := testutil.ToFloat64(commandsTotal.WithLabelValues("inspect", "error")) - before; got != 1 {
+		t.Errorf("inspect errors = %v, want 1", got)
+	}
+}
+
+func TestObserveCloudAPICall(t *testing.T) {
+	calls := testutil.ToFloat64(cloudAPICallsTotal.WithLabelValues(cloudProviderAWS, "CreateVpc"))
+	errs := testutil.ToFloat64(cloudAPIErrorsTotal.WithLabelValues(cloudProviderAWS, "CreateVpc"))
+	observeCloudAPICall(cloudProviderAWS, "CreateVpc", nil)
+	observeCloudAPICall(cloudProviderAWS, "CreateVpc", errors.New("VpcLimitExceeded"))
+	if got := testutil.ToFloat64(cloudAPICallsTotal.WithLabelValues(cloudProviderAWS, "CreateVpc")) - calls; got != 2 {
+		t.Errorf("calls = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(cloudAPIErrorsTotal.WithLabelValues(cloudProviderAWS, "CreateVpc")) - errs; got != 1 {
+		t.Errorf("errors = %v, want 1", got)
+	}
+}
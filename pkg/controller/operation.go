@@ -0,0 +1,196 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// operationPollInterval is how often a Machine with an operation in flight is re-queued.
+var operationPollInterval = 10 * time.Second
+
+type operationState int
+
+const (
+	// operationUnknown means the runner has no record of the operation, it was
+	// started by a previous operator process.
+	operationUnknown operationState = iota
+	operationRunning
+	operationSucceeded
+	operationFailed
+)
+
+type operation struct {
+	id     string
+	cancel context.CancelFunc
+	done   bool
+	err    error
+}
+
+// OperationRunner runs long running docker-machine commands in the background,
+// so that reconcilers return at once and poll for the result on a later reconcile.
+// Operations are only kept in memory. The ID of an operation is recorded in the
+// Machine status, so an operation that is unknown to the runner was interrupted
+// by an operator restart.
+type OperationRunner struct {
+	mu  sync.Mutex
+	ops map[string]*operation
+}
+
+func NewOperationRunner() *OperationRunner {
+	return &OperationRunner{ops: map[string]*operation{}}
+}
+
+// Run starts fn in the background with the given timeout and returns the ID of
+// the operation. Only one operation runs at a time for a key.
+func (o *OperationRunner) Run(key string, timeout time.Duration, fn func(ctx context.Context) error) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if op, ok := o.ops[key]; ok && !op.done {
+		return "", fmt.Errorf("operation %s is still running for %s", op.id, key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	op := &operation{id: string(uuid.NewUUID()), cancel: cancel}
+	o.ops[key] = op
+
+	go func() {
+		defer cancel()
+		err := fn(ctx)
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		op.done = true
+		op.err = err
+	}()
+	return op.id, nil
+}
+
+// Result returns the state of the operation and its error once it has failed.
+func (o *OperationRunner) Result(key, id string) (operationState, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	op, ok := o.ops[key]
+	if !ok || op.id != id {
+		return operationUnknown, nil
+	}
+	switch {
+	case !op.done:
+		return operationRunning, nil
+	case op.err != nil:
+		return operationFailed, op.err
+	default:
+		return operationSucceeded, nil
+	}
+}
+
+// Cancel stops the operation. It returns true while the operation is still running.
+func (o *OperationRunner) Cancel(key, id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	op, ok := o.ops[key]
+	if !ok || op.id != id {
+		return false
+	}
+	op.cancel()
+	return !op.done
+}
+
+// Forget drops a finished operation.
+func (o *OperationRunner) Forget(key, id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if op, ok := o.ops[key]; ok && op.id == id && op.done {
+		delete(o.ops, key)
+	}
+}
+
+// CancelAll stops all running operations, e.g. when the operator shuts down.
+func (o *OperationRunner) CancelAll() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, op := range o.ops {
+		op.cancel()
+	}
+}
+
+func (r *machineRequest) operationKey() string {
+	return path.Join(r.machineObj.Namespace, r.machineObj.Name, string(r.machineObj.UID))
+}
+
+// startOperation runs fn in the background and records the operation in the Machine status.
+// fn gets its own copy of the request, the Machine object of r keeps being used by the reconciler.
+func (r *machineRequest) startOperation(typ api.MachineOperationType, timeout time.Duration, fn func(op *machineRequest) error) error {
+	clone := *r
+	clone.machineObj = r.machineObj.DeepCopy()
+
+	id, err := r.Operations.Run(r.operationKey(), timeout, func(ctx context.Context) error {
+		clone.ctx = ctx
+		start := time.Now()
+		err := fn(&clone)
+		operationDuration.WithLabelValues(string(typ), clone.machineObj.Spec.Driver.Name, metricResult(err)).Observe(time.Since(start).Seconds())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.machineObj.Status.Operation = &api.MachineOperation{
+		ID:        id,
+		Type:      typ,
+		StartedAt: metav1.Now(),
+	}
+	r.Log.Info("Started operation", "Type", typ, "ID", id)
+	return nil
+}
+
+// operationResult returns the state of the operation recorded in the Machine status.
+// A finished operation is cleared from the status. The runner keeps its result
+// until the status is saved, so that the next reconcile gets it again if the
+// save fails.
+func (r *machineRequest) operationResult() (operationState, error) {
+	op := r.machineObj.Status.Operation
+	state, err := r.Operations.Result(r.operationKey(), op.ID)
+	if state == operationSucceeded || state == operationFailed {
+		r.finishedOperation = op.ID
+		r.machineObj.Status.Operation = nil
+	}
+	return state, err
+}
+
+// forgetFinishedOperation drops the operation cleared from the status from the
+// runner, once the status is saved.
+func (r *machineRequest) forgetFinishedOperation() {
+	if r.finishedOperation == "" {
+		return
+	}
+	r.Operations.Forget(r.operationKey(), r.finishedOperation)
+	r.finishedOperation = ""
+}
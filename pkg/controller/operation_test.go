@@ -0,0 +1,148 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+func waitForOperation(t *testing.T, o *OperationRunner, key, id string) (operationState, error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		state, err := o.Result(key, id)
+		if state != operationRunning {
+			return state, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("operation %s did not finish", id)
+	return operationUnknown, nil
+}
+
+func TestOperationRunner(t *testing.T) {
+	o := NewOperationRunner()
+	release := make(chan struct{})
+
+	id, err := o.Run("demo/vm", time.Minute, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := o.Result("demo/vm", id); state != operationRunning {
+		t.Errorf("state = %v, want running", state)
+	}
+	if _, err := o.Run("demo/vm", time.Minute, func(ctx context.Context) error { return nil }); err == nil {
+		t.Error("expected a second operation for the same key to be rejected")
+	}
+	if state, _ := o.Result("demo/vm", "unknown-id"); state != operationUnknown {
+		t.Errorf("state of an operation of a previous process = %v, want unknown", state)
+	}
+
+	close(release)
+	if state, err := waitForOperation(t, o, "demo/vm", id); state != operationSucceeded || err != nil {
+		t.Errorf("state = %v, err = %v, want succeeded", state, err)
+	}
+
+	o.Forget("demo/vm", id)
+	if state, _ := o.Result("demo/vm", id); state != operationUnknown {
+		t.Errorf("state of a forgotten operation = %v, want unknown", state)
+	}
+}
+
+func TestOperationRunnerFailure(t *testing.T) {
+	o := NewOperationRunner()
+	errCreate := errors.New("create failed")
+
+	id, err := o.Run("demo/vm", time.Minute, func(ctx context.Context) error { return errCreate })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := waitForOperation(t, o, "demo/vm", id); state != operationFailed || !errors.Is(err, errCreate) {
+		t.Errorf("state = %v, err = %v, want failed with %v", state, err, errCreate)
+	}
+}
+
+func TestOperationRunnerCancel(t *testing.T) {
+	o := NewOperationRunner()
+
+	id, err := o.Run("demo/vm", time.Minute, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Cancel("demo/vm", id)
+	if state, err := waitForOperation(t, o, "demo/vm", id); state != operationFailed || !errors.Is(err, context.Canceled) {
+		t.Errorf("state = %v, err = %v, want canceled", state, err)
+	}
+	if o.Cancel("demo/vm", id) {
+		t.Error("a finished operation should not be reported as running")
+	}
+}
+
+func TestOperationRunnerTimeout(t *testing.T) {
+	o := NewOperationRunner()
+
+	id, err := o.Run("demo/vm", 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := waitForOperation(t, o, "demo/vm", id); state != operationFailed || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("state = %v, err = %v, want deadline exceeded", state, err)
+	}
+}
+
+func TestOperationResultKeptUntilStatusSaved(t *testing.T) {
+	r, _ := newFakeExecutorRequest(t)
+	if err := r.startOperation(api.MachineOperationStop, time.Minute, func(*machineRequest) error {
+		return errors.New("instance is locked")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	op := r.machineObj.Status.Operation
+	waitForOperation(t, r.Operations, r.operationKey(), op.ID)
+
+	if state, err := r.operationResult(); state != operationFailed || err == nil {
+		t.Fatalf("state = %v, err = %v, want failed", state, err)
+	}
+	if r.machineObj.Status.Operation != nil {
+		t.Error("the finished operation is kept in the status")
+	}
+
+	// the status save failed, the next reconcile reads the stored operation again
+	r.machineObj.Status.Operation = op
+	if state, err := r.operationResult(); state != operationFailed || err == nil {
+		t.Errorf("state = %v, err = %v, want the result of the operation again", state, err)
+	}
+
+	r.forgetFinishedOperation()
+	if state, _ := r.Operations.Result(r.operationKey(), op.ID); state != operationUnknown {
+		t.Errorf("state of a saved operation = %v, want it forgotten", state)
+	}
+}
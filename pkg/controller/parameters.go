@@ -0,0 +1,269 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+const driverHelpTimeout = time.Minute
+
+var (
+	// helpFlagPattern matches a flag of the docker-machine help output, e.g.
+	//   --amazonec2-security-group "docker-machine" [--amazonec2-security-group option --amazonec2-security-group option]	AWS VPC security group [$AWS_SECURITY_GROUP]
+	helpFlagPattern = regexp.MustCompile(`^\s*--([A-Za-z0-9][A-Za-z0-9-]*)(?:,\s*-\w+)*(?:\s+"([^"]*)")?(\s+\[--[^\]]*\])?\s*(.*)$`)
+	helpEnvPattern  = regexp.MustCompile(`\s*\[\$[^\]]*\]$`)
+	intPattern      = regexp.MustCompile(`^-?[0-9]+$`)
+)
+
+// helpIgnoredFlags are the flags of docker-machine create that are not driver parameters.
+var helpIgnoredFlags = map[string]bool{"driver": true, "help": true}
+
+// secretFlagWords mark the flags that carry credentials.
+var secretFlagWords = map[string]bool{"secret": true, "password": true, "passwd": true, "token": true}
+
+// parseDriverHelp extracts the parameters from the output of
+// docker-machine create --driver <name> --help. The help output does not tell
+// string and bool flags apart, both are reported as string parameters.
+func parseDriverHelp(out []byte) []api.DriverParameter {
+	var params []api.DriverParameter
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := helpFlagPattern.FindStringSubmatch(scanner.Text())
+		if m == nil || helpIgnoredFlags[m[1]] || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+
+		p := api.DriverParameter{
+			Name:        m[1],
+			Type:        api.DriverParameterTypeString,
+			Default:     m[2],
+			Secret:      isSecretFlag(m[1]),
+			Description: strings.TrimSpace(helpEnvPattern.ReplaceAllString(m[4], "")),
+		}
+		switch {
+		case m[3] != "":
+			p.Type = api.DriverParameterTypeStringSlice
+		case intPattern.MatchString(m[2]):
+			p.Type = api.DriverParameterTypeInt
+		}
+		if p.Secret {
+			p.Default = ""
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func isSecretFlag(name string) bool {
+	for _, w := range strings.Split(name, "-") {
+		if secretFlagWords[w] {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, "-access-key") || strings.HasSuffix(name, "-api-key")
+}
+
+// DriverParameters returns the parameter schema of the driver. The parameters
+// declared in the spec are merged by name into the discovered ones.
+func DriverParameters(driver *api.Driver) []api.DriverParameter {
+	index := map[string]int{}
+	params := make([]api.DriverParameter, 0, len(driver.Status.Parameters)+len(driver.Spec.Parameters))
+	for _, p := range driver.Status.Parameters {
+		index[p.Name] = len(params)
+		params = append(params, *p.DeepCopy())
+	}
+	for _, p := range driver.Spec.Parameters {
+		i, ok := index[p.Name]
+		if !ok {
+			index[p.Name] = len(params)
+			params = append(params, *p.DeepCopy())
+			continue
+		}
+		merged := &params[i]
+		if p.Type != "" {
+			merged.Type = p.Type
+		}
+		if p.Default != "" {
+			merged.Default = p.Default
+		}
+		if p.Enum != nil {
+			merged.Enum = append([]string(nil), p.Enum...)
+		}
+		if p.Description != "" {
+			merged.Description = p.Description
+		}
+		merged.Required = merged.Required || p.Required
+		merged.Secret = merged.Secret || p.Secret
+	}
+	return params
+}
+
+// ValidateParameters checks the parameters and flags of the Machine spec against
+// the parameter schema of the driver. Nothing is checked if the schema of the
+// driver is not known.
+func ValidateParameters(driver *api.Driver, spec *api.MachineSpec, specPath *field.Path) field.ErrorList {
+	schema := DriverParameters(driver)
+	if len(schema) == 0 {
+		return nil
+	}
+	known := make(map[string]api.DriverParameter, len(schema))
+	for _, p := range schema {
+		known[p.Name] = p
+	}
+
+	var errs field.ErrorList
+	paramsPath := specPath.Child("parameters")
+	names := make([]string, 0, len(spec.Parameters))
+	for name := range spec.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := []string{spec.Parameters[name]}
+		if known[name].Type == api.DriverParameterTypeStringSlice {
+			values = splitList(spec.Parameters[name])
+		}
+		errs = append(errs, validateParameter(known, name, values, paramsPath.Key(name))...)
+	}
+
+	set := make(map[string]bool, len(spec.Parameters)+len(spec.Flags))
+	for name := range spec.Parameters {
+		set[name] = true
+	}
+	flagsPath := specPath.Child("flags")
+	for i, f := range spec.Flags {
+		path := flagsPath.Index(i)
+		set[f.Name] = f.Bool == nil || *f.Bool
+		if f.Bool != nil {
+			if p, ok := known[f.Name]; ok && p.Type != api.DriverParameterTypeBool && p.Type != api.DriverParameterTypeString && p.Type != "" {
+				errs = append(errs, field.Invalid(path.Child("bool"), *f.Bool, fmt.Sprintf("flag of type %s takes a value", p.Type)))
+				continue
+			}
+		}
+		errs = append(errs, validateParameter(known, f.Name, f.Values, path)...)
+	}
+
+	for _, p := range schema {
+		if p.Required && !set[p.Name] {
+			errs = append(errs, field.Required(paramsPath.Key(p.Name), fmt.Sprintf("parameter is required by driver %s", driver.Name)))
+		}
+	}
+	return errs
+}
+
+func validateParameter(known map[string]api.DriverParameter, name string, values []string, path *field.Path) field.ErrorList {
+	p, ok := known[name]
+	if !ok {
+		return field.ErrorList{field.NotFound(path, name)}
+	}
+	if p.Secret {
+		return field.ErrorList{field.Forbidden(path, "secret parameters must be set in the auth Secret")}
+	}
+
+	var errs field.ErrorList
+	for _, value := range values {
+		switch p.Type {
+		case api.DriverParameterTypeInt:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				errs = append(errs, field.Invalid(path, value, "must be an integer"))
+				continue
+			}
+		case api.DriverParameterTypeBool:
+			if _, err := strconv.ParseBool(value); err != nil {
+				errs = append(errs, field.Invalid(path, value, "must be a boolean"))
+				continue
+			}
+		}
+		if len(p.Enum) > 0 && !contains(p.Enum, value) {
+			errs = append(errs, field.NotSupported(path, value, p.Enum))
+		}
+	}
+	return errs
+}
+
+// discoverParameters records the parameters reported by docker-machine for the
+// installed driver. Failures are reported in the ParametersDiscovered condition
+// and do not affect the readiness of the Driver.
+func (r *DriverReconciler) discoverParameters(ctx context.Context, logger logr.Logger, driver *api.Driver) {
+	if cutil.IsConditionTrue(driver.Status.Conditions, string(api.DriverConditionTypeParametersDiscovered)) {
+		return
+	}
+
+	helpCtx, cancel := context.WithTimeout(ctx, driverHelpTimeout)
+	defer cancel()
+	res, err := r.commands().Execute(helpCtx, nil, executor.Command{
+		Name:      "help",
+		Args:      []string{"create", "--driver", driver.Name, "--help"},
+		DriverDir: namespaceDriverDir(r.DriverDir, driver.Namespace),
+	})
+	var params []api.DriverParameter
+	if err == nil {
+		params = parseDriverHelp(res.Stdout)
+		if len(params) == 0 {
+			err = fmt.Errorf("no parameters found in the help output")
+		}
+	}
+	if err != nil {
+		logger.Info("Failed to discover driver parameters", "Name", driver.Name, "Error", err.Error())
+		cutil.MarkFalse(driver, api.DriverConditionTypeParametersDiscovered, api.ReasonDiscoveryFailed, kmapi.ConditionSeverityWarning,
+			"failed to discover driver parameters. err: %s", err.Error())
+		r.event(driver, core.EventTypeWarning, EventReasonDiscoveryFailed, "Failed to discover driver parameters: %v", err)
+		return
+	}
+
+	driver.Status.Parameters = params
+	cutil.MarkTrue(driver, api.DriverConditionTypeParametersDiscovered)
+	r.event(driver, core.EventTypeNormal, EventReasonParametersDiscovered, "Discovered %d driver parameters", len(params))
+}
+
+// areParametersValid checks the parameters and flags of a Machine that is not created yet
+// against the schema of its Driver, and records the result in the ParametersValid condition.
+func (r *machineRequest) areParametersValid() bool {
+	if r.driver == nil {
+		return true
+	}
+	errs := ValidateParameters(r.driver, &r.machineObj.Spec, field.NewPath("spec"))
+	if len(errs) > 0 {
+		r.Log.Info("invalid parameters", "driver", r.driver.Name, "errors", errs.ToAggregate().Error())
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypeParametersValid, api.ReasonInvalidParameters, kmapi.ConditionSeverityError,
+			"%s", errs.ToAggregate().Error())
+		r.warning(EventReasonInvalidParameters, "Invalid parameters: %v", errs.ToAggregate())
+		return false
+	}
+	cutil.MarkTrue(r.machineObj, api.MachineConditionTypeParametersValid)
+	return true
+}
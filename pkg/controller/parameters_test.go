@@ -0,0 +1,212 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+const testDriverHelp = `Usage: docker-machine create [OPTIONS] [arg...]
+
+Create a machine
+
+Description:
+   Run 'docker-machine create --driver name --help' to include the create flags for that driver in the help text.
+
+Options:
+
+   --amazonec2-access-key 										AWS Access Key [$AWS_ACCESS_KEY_ID]
+   --amazonec2-block-duration-minutes "0"								AWS spot instance duration in minutes (60, 120, 180, 240, 300, or 360) [$AWS_SPOT_BLOCK_DURATION_MINUTES]
+   --amazonec2-instance-type "t2.micro"								AWS instance type [$AWS_INSTANCE_TYPE]
+   --amazonec2-open-port [--amazonec2-open-port option --amazonec2-open-port option]			Make the specified port number accessible from the Internet
+   --amazonec2-private-address-only									Only use a private IP address [$AWS_PRIVATE_ADDRESS_ONLY]
+   --amazonec2-secret-key 										AWS Secret Key [$AWS_SECRET_ACCESS_KEY]
+   --amazonec2-security-group "docker-machine" [--amazonec2-security-group option --amazonec2-security-group option]	AWS VPC security group [$AWS_SECURITY_GROUP]
+   --amazonec2-session-token 										AWS Session Token [$AWS_SESSION_TOKEN]
+   --driver, -d "virtualbox"										Driver to create machine with. [$MACHINE_DRIVER]
+   --engine-install-url "https://get.docker.com"							Custom URL to use for engine installation [$MACHINE_DOCKER_INSTALL_URL]
+`
+
+func TestParseDriverHelp(t *testing.T) {
+	got := parseDriverHelp([]byte(testDriverHelp))
+	want := []api.DriverParameter{
+		{Name: "amazonec2-access-key", Type: api.DriverParameterTypeString, Secret: true, Description: "AWS Access Key"},
+		{Name: "amazonec2-block-duration-minutes", Type: api.DriverParameterTypeInt, Default: "0", Description: "AWS spot instance duration in minutes (60, 120, 180, 240, 300, or 360)"},
+		{Name: "amazonec2-instance-type", Type: api.DriverParameterTypeString, Default: "t2.micro", Description: "AWS instance type"},
+		{Name: "amazonec2-open-port", Type: api.DriverParameterTypeStringSlice, Description: "Make the specified port number accessible from the Internet"},
+		{Name: "amazonec2-private-address-only", Type: api.DriverParameterTypeString, Description: "Only use a private IP address"},
+		{Name: "amazonec2-secret-key", Type: api.DriverParameterTypeString, Secret: true, Description: "AWS Secret Key"},
+		{Name: "amazonec2-security-group", Type: api.DriverParameterTypeStringSlice, Default: "docker-machine", Description: "AWS VPC security group"},
+		{Name: "amazonec2-session-token", Type: api.DriverParameterTypeString, Secret: true, Description: "AWS Session Token"},
+		{Name: "engine-install-url", Type: api.DriverParameterTypeString, Default: "https://get.docker.com", Description: "Custom URL to use for engine installation"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDriverHelp() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDriverParametersMerge(t *testing.T) {
+	driver := &api.Driver{
+		Spec: api.DriverSpec{Parameters: []api.DriverParameter{
+			{Name: "amazonec2-instance-type", Required: true, Enum: []string{"t2.micro", "t3.large"}},
+			{Name: "amazonec2-custom", Type: api.DriverParameterTypeBool},
+		}},
+		Status: api.DriverStatus{Parameters: []api.DriverParameter{
+			{Name: "amazonec2-instance-type", Type: api.DriverParameterTypeString, Default: "t2.micro", Description: "AWS instance type"},
+		}},
+	}
+	got := DriverParameters(driver)
+	want := []api.DriverParameter{
+		{Name: "amazonec2-instance-type", Type: api.DriverParameterTypeString, Required: true, Default: "t2.micro", Enum: []string{"t2.micro", "t3.large"}, Description: "AWS instance type"},
+		{Name: "amazonec2-custom", Type: api.DriverParameterTypeBool},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DriverParameters() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	spec := &api.MachineSpec{Parameters: map[string]string{"amazonec2-instance-type": "m5.large", "amazonec2-custom": "yes"}}
+	errs := ValidateParameters(driver, spec, field.NewPath("spec"))
+	if len(errs) != 2 || errs[0].Type != field.ErrorTypeInvalid || errs[1].Type != field.ErrorTypeNotSupported {
+		t.Errorf("ValidateParameters() = %v", errs)
+	}
+	if errs := ValidateParameters(&api.Driver{}, &api.MachineSpec{Parameters: map[string]string{"anything": "goes"}}, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("parameters must not be validated without a schema, got %v", errs)
+	}
+}
+
+// helpExecutor returns the help output of docker-machine create.
+type helpExecutor struct {
+	out  string
+	err  error
+	args []string
+}
+
+func (e *helpExecutor) Execute(_ context.Context, _ *api.Machine, cmd executor.Command) (*executor.Result, error) {
+	e.args = cmd.Args
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &executor.Result{Stdout: []byte(e.out)}, nil
+}
+
+func TestDiscoverParameters(t *testing.T) {
+	driver := &api.Driver{ObjectMeta: metav1.ObjectMeta{Name: AWSDriver}}
+
+	failing := &helpExecutor{err: errors.New("executable file not found")}
+	(&DriverReconciler{Commands: failing}).discoverParameters(context.Background(), klog.Background(), driver)
+	if cutil.IsConditionTrue(driver.Status.Conditions, string(api.DriverConditionTypeParametersDiscovered)) || driver.Status.Parameters != nil {
+		t.Fatalf("discovery must fail, got %+v", driver.Status)
+	}
+
+	help := &helpExecutor{out: testDriverHelp}
+	(&DriverReconciler{Commands: help}).discoverParameters(context.Background(), klog.Background(), driver)
+	if got := strings.Join(help.args, " "); got != "create --driver amazonec2 --help" {
+		t.Errorf("args = %q", got)
+	}
+	if !cutil.IsConditionTrue(driver.Status.Conditions, string(api.DriverConditionTypeParametersDiscovered)) || len(driver.Status.Parameters) != 9 {
+		t.Fatalf("discovery must succeed, got %+v", driver.Status)
+	}
+}
+
+func TestValidateFlags(t *testing.T) {
+	enabled, disabled := true, false
+	driver := &api.Driver{
+		ObjectMeta: metav1.ObjectMeta{Name: AWSDriver},
+		Status: api.DriverStatus{Parameters: []api.DriverParameter{
+			{Name: "amazonec2-instance-type", Type: api.DriverParameterTypeString, Required: true},
+			{Name: "amazonec2-private-address-only", Type: api.DriverParameterTypeString},
+			{Name: "amazonec2-volume-size", Type: api.DriverParameterTypeInt},
+			{Name: "engine-opt", Type: api.DriverParameterTypeStringSlice},
+		}},
+	}
+	tests := []struct {
+		name    string
+		spec    api.MachineSpec
+		wantErr string
+	}{
+		{
+			name: "valid",
+			spec: api.MachineSpec{Flags: []api.MachineFlag{
+				{Name: "amazonec2-instance-type", Values: []string{"t2.micro"}},
+				{Name: "amazonec2-private-address-only", Bool: &enabled},
+				{Name: "engine-opt", Values: []string{"log-driver=json-file", "log-opt=max-size=10m"}},
+			}},
+		},
+		{
+			name: "required set by a flag",
+			spec: api.MachineSpec{
+				Parameters: map[string]string{"amazonec2-volume-size": "50"},
+				Flags:      []api.MachineFlag{{Name: "amazonec2-instance-type", Values: []string{"t2.micro"}}},
+			},
+		},
+		{
+			name: "required removed by a flag",
+			spec: api.MachineSpec{
+				Parameters: map[string]string{"amazonec2-instance-type": "t2.micro"},
+				Flags:      []api.MachineFlag{{Name: "amazonec2-instance-type", Bool: &disabled}},
+			},
+			wantErr: "spec.parameters[amazonec2-instance-type]: Required value",
+		},
+		{
+			name: "unknown flag",
+			spec: api.MachineSpec{Flags: []api.MachineFlag{
+				{Name: "amazonec2-instance-type", Values: []string{"t2.micro"}},
+				{Name: "engine-options", Values: []string{"a"}},
+			}},
+			wantErr: "spec.flags[1]: Not found",
+		},
+		{
+			name: "bool flag of an int parameter",
+			spec: api.MachineSpec{Flags: []api.MachineFlag{
+				{Name: "amazonec2-instance-type", Values: []string{"t2.micro"}},
+				{Name: "amazonec2-volume-size", Bool: &enabled},
+			}},
+			wantErr: "spec.flags[1].bool: Invalid value",
+		},
+		{
+			name: "invalid int value",
+			spec: api.MachineSpec{Flags: []api.MachineFlag{
+				{Name: "amazonec2-instance-type", Values: []string{"t2.micro"}},
+				{Name: "amazonec2-volume-size", Values: []string{"50", "large"}},
+			}},
+			wantErr: "must be an integer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateParameters(driver, &tt.spec, field.NewPath("spec")).ToAggregate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
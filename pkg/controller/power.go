@@ -0,0 +1,191 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+const (
+	powerOperationTimeout = 10 * time.Minute
+	powerStatusTimeout    = time.Minute
+
+	// transitional states reported by docker-machine status
+	powerStateStarting api.MachinePowerState = "Starting"
+	powerStateStopping api.MachinePowerState = "Stopping"
+	// powerStateSaved is reported for machines that are suspended to disk, they are started like stopped machines
+	powerStateSaved api.MachinePowerState = "Saved"
+)
+
+// desiredPowerState returns spec.powerState, machines are running by default.
+func desiredPowerState(machine *api.Machine) api.MachinePowerState {
+	if machine.Spec.PowerState == "" {
+		return api.MachinePowerStateRunning
+	}
+	return machine.Spec.PowerState
+}
+
+func isPowerOperation(typ api.MachineOperationType) bool {
+	switch typ {
+	case api.MachineOperationStart, api.MachineOperationStop, api.MachineOperationRestart:
+		return true
+	}
+	return false
+}
+
+// queryPowerState returns the state reported by docker-machine status, it is
+// queried once per reconcile.
+func (r *machineRequest) queryPowerState() (api.MachinePowerState, error) {
+	if r.powerState != "" {
+		return r.powerState, nil
+	}
+	ctx, cancel := context.WithTimeout(r.ctx, powerStatusTimeout)
+	defer cancel()
+	out, err := r.Executor.Status(ctx, r.machineObj, r.executorOptions())
+	if err != nil {
+		return "", err
+	}
+	r.powerState = api.MachinePowerState(out)
+	return r.powerState, nil
+}
+
+// reconcilePowerState drives a created machine to spec.powerState and restarts
+// it when the restart annotation changes. It returns true while a power
+// operation is in flight.
+func (r *machineRequest) reconcilePowerState() (bool, error) {
+	if op := r.machineObj.Status.Operation; op != nil && isPowerOperation(op.Type) {
+		inProgress, err := r.checkPowerOperation(op.Type)
+		if inProgress || err != nil {
+			return inProgress, err
+		}
+	}
+
+	state, err := r.queryPowerState()
+	if err != nil {
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypePowerStateSynced, api.ReasonPowerStateUnknown, kmapi.ConditionSeverityWarning,
+			"failed to get the state of the machine: %v", err)
+		return false, err
+	}
+	restart := r.machineObj.Annotations[api.MachineRestartAnnotation]
+
+	switch prev := r.machineObj.Status.PowerState; {
+	case prev == "":
+		// first look at the machine, an annotation set at creation time does not restart it
+		r.machineObj.Status.ObservedRestart = restart
+	case prev != state:
+		r.Log.Info("Machine power state changed out of band", "From", prev, "To", state)
+		r.event(core.EventTypeNormal, EventReasonPowerStateChanged, "Machine state changed from %s to %s", prev, state)
+		if state == api.MachinePowerStateRunning {
+			// the address of the machine may change when it is started again
+			r.machineObj.Status.Connection = nil
+		}
+	}
+	r.machineObj.Status.PowerState = state
+
+	desired := desiredPowerState(r.machineObj)
+	var typ api.MachineOperationType
+	switch {
+	case state == powerStateStarting || state == powerStateStopping:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypePowerStateSynced, api.ReasonPowerStateChanging, kmapi.ConditionSeverityInfo,
+			"machine is %s", strings.ToLower(string(state)))
+		return true, nil
+	case desired == api.MachinePowerStateStopped && state == api.MachinePowerStateRunning:
+		typ = api.MachineOperationStop
+	case desired == api.MachinePowerStateRunning && (state == api.MachinePowerStateStopped || state == powerStateSaved):
+		typ = api.MachineOperationStart
+	case desired == api.MachinePowerStateRunning && state == api.MachinePowerStateRunning && restart != r.machineObj.Status.ObservedRestart:
+		typ = api.MachineOperationRestart
+	case desired == state:
+		// a restart requested while the machine is stopped is done by the next start
+		r.machineObj.Status.ObservedRestart = restart
+		cutil.MarkTrue(r.machineObj, api.MachineConditionTypePowerStateSynced)
+		return false, nil
+	default:
+		// e.g. Error or Timeout, docker-machine can not change the state of the machine
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypePowerStateSynced, api.ReasonPowerStateUnknown, kmapi.ConditionSeverityWarning,
+			"machine is %s, want %s", state, desired)
+		return false, nil
+	}
+
+	if err := r.startOperation(typ, powerOperationTimeout, func(op *machineRequest) error {
+		return op.runPowerOperation(typ)
+	}); err != nil {
+		return false, err
+	}
+	r.machineObj.Status.ObservedRestart = restart
+	cutil.MarkFalse(r.machineObj, api.MachineConditionTypePowerStateSynced, api.ReasonPowerStateChanging, kmapi.ConditionSeverityInfo,
+		"%s operation is in progress", typ)
+	r.event(core.EventTypeNormal, powerEventReasons[typ].started, "%s machine", powerEventReasons[typ].started)
+	return true, nil
+}
+
+// powerEventReasons are the reasons of the events of the power operations.
+var powerEventReasons = map[api.MachineOperationType]struct{ started, done string }{
+	api.MachineOperationStart:   {EventReasonStarting, EventReasonStarted},
+	api.MachineOperationStop:    {EventReasonStopping, EventReasonStopped},
+	api.MachineOperationRestart: {EventReasonRestarting, EventReasonRestarted},
+}
+
+func (r *machineRequest) runPowerOperation(typ api.MachineOperationType) error {
+	switch typ {
+	case api.MachineOperationStart:
+		return r.Executor.Start(r.ctx, r.machineObj, r.executorOptions())
+	case api.MachineOperationStop:
+		return r.Executor.Stop(r.ctx, r.machineObj, r.executorOptions())
+	default:
+		return r.Executor.Restart(r.ctx, r.machineObj, r.executorOptions())
+	}
+}
+
+// checkPowerOperation records the result of the power operation in the Machine status.
+func (r *machineRequest) checkPowerOperation(typ api.MachineOperationType) (bool, error) {
+	state, err := r.operationResult()
+	switch state {
+	case operationRunning:
+		return true, nil
+	case operationUnknown:
+		// interrupted by an operator restart, docker-machine status tells how far it got
+		r.Log.Info("Power operation was interrupted", "Type", typ, "ID", r.machineObj.Status.Operation.ID)
+		r.machineObj.Status.Operation = nil
+		return false, nil
+	case operationFailed:
+		cutil.MarkFalse(r.machineObj, api.MachineConditionTypePowerStateSynced, api.ReasonPowerOperationFailed, kmapi.ConditionSeverityError,
+			"%s operation failed: %v", typ, err)
+		r.warning(EventReasonPowerOperationFailed, "%s operation failed: %v", typ, err)
+		return false, err
+	}
+
+	// the state queried before the operation finished is outdated
+	r.powerState = ""
+	if typ == api.MachineOperationStop {
+		r.machineObj.Status.PowerState = api.MachinePowerStateStopped
+	} else {
+		r.machineObj.Status.PowerState = api.MachinePowerStateRunning
+		// the address of the machine may change when it is started again
+		r.machineObj.Status.Connection = nil
+	}
+	r.event(core.EventTypeNormal, powerEventReasons[typ].done, "%s machine", powerEventReasons[typ].done)
+	return false, nil
+}
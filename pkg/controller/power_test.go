@@ -0,0 +1,162 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
+
+	"github.com/go-logr/logr"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	cutil "kmodules.xyz/client-go/conditions"
+)
+
+func newFakeExecutorRequest(t *testing.T) (*machineRequest, *fake.Executor) {
+	t.Helper()
+	f := fake.NewExecutor()
+	r := &machineRequest{
+		MachineReconciler: &MachineReconciler{
+			WorkDir:    t.TempDir(),
+			Operations: NewOperationRunner(),
+			Executor:   f,
+			Recorder:   record.NewFakeRecorder(20),
+		},
+		ctx: context.Background(),
+		Log: logr.Discard(),
+		machineObj: &api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "demo", UID: "uid"},
+			Spec:       api.MachineSpec{Driver: &core.LocalObjectReference{Name: GoogleDriver}},
+		},
+		redactor: newRedactor(),
+	}
+	f.SetMachine("demo/vm", fake.Machine{Driver: GoogleDriver, State: fake.StateRunning, IP: "10.0.0.1"})
+	return r, f
+}
+
+// reconcilePower runs reconcilePowerState until the power operation it starts, if any, has finished.
+func reconcilePower(t *testing.T, r *machineRequest) error {
+	t.Helper()
+	r.powerState = ""
+	inProgress, err := r.reconcilePowerState()
+	if err != nil || !inProgress {
+		return err
+	}
+	waitForOperation(t, r.Operations, r.operationKey(), r.machineObj.Status.Operation.ID)
+	r.powerState = ""
+	_, err = r.reconcilePowerState()
+	return err
+}
+
+func TestReconcilePowerState(t *testing.T) {
+	r, f := newFakeExecutorRequest(t)
+	r.machineObj.Annotations = map[string]string{api.MachineRestartAnnotation: "1"}
+
+	if err := reconcilePower(t, r); err != nil {
+		t.Fatal(err)
+	}
+	if r.machineObj.Status.PowerState != api.MachinePowerStateRunning || r.machineObj.Status.ObservedRestart != "1" {
+		t.Errorf("status = %q/%q, want Running/1", r.machineObj.Status.PowerState, r.machineObj.Status.ObservedRestart)
+	}
+	if n := len(f.ActionsFor(fake.VerbRestart, "demo/vm")); n != 0 {
+		t.Errorf("a new machine was restarted %d times", n)
+	}
+
+	expectTransition := func(step string, powerState api.MachinePowerState, wantVerb, wantState string) {
+		t.Helper()
+		before := len(f.ActionsFor(wantVerb, "demo/vm"))
+		r.machineObj.Spec.PowerState = powerState
+		if err := reconcilePower(t, r); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if n := len(f.ActionsFor(wantVerb, "demo/vm")); n != before+1 {
+			t.Errorf("%s: ran %s %d times, want once", step, wantVerb, n-before)
+		}
+		if m, _ := f.Machine("demo/vm"); m.State != wantState {
+			t.Errorf("%s: machine is %s, want %s", step, m.State, wantState)
+		}
+		if string(r.machineObj.Status.PowerState) != wantState || r.machineObj.Status.Operation != nil {
+			t.Errorf("%s: status = %q, operation %v", step, r.machineObj.Status.PowerState, r.machineObj.Status.Operation)
+		}
+		if !cutil.IsConditionTrue(r.machineObj.Status.Conditions, string(api.MachineConditionTypePowerStateSynced)) {
+			t.Errorf("%s: power state is not synced", step)
+		}
+	}
+
+	expectTransition("stop", api.MachinePowerStateStopped, fake.VerbStop, fake.StateStopped)
+
+	f.SetMachine("demo/vm", fake.Machine{Driver: GoogleDriver, State: fake.StateRunning, IP: "10.0.0.1"})
+	expectTransition("revert an out of band start", api.MachinePowerStateStopped, fake.VerbStop, fake.StateStopped)
+
+	expectTransition("start", api.MachinePowerStateRunning, fake.VerbStart, fake.StateRunning)
+	if r.machineObj.Status.Connection != nil {
+		t.Error("connection details are kept after a start")
+	}
+
+	r.machineObj.Annotations[api.MachineRestartAnnotation] = "2"
+	expectTransition("restart", api.MachinePowerStateRunning, fake.VerbRestart, fake.StateRunning)
+	if r.machineObj.Status.ObservedRestart != "2" {
+		t.Errorf("observed restart = %q, want 2", r.machineObj.Status.ObservedRestart)
+	}
+}
+
+func TestReconcilePowerStateFailure(t *testing.T) {
+	r, f := newFakeExecutorRequest(t)
+	if err := reconcilePower(t, r); err != nil {
+		t.Fatal(err)
+	}
+
+	f.PrependReaction(fake.VerbStop, fake.Fail(errors.New("instance is locked")))
+	r.machineObj.Spec.PowerState = api.MachinePowerStateStopped
+	if err := reconcilePower(t, r); err == nil {
+		t.Fatal("expected the failed stop to be reported")
+	}
+	if reason := cutil.GetReason(r.machineObj, api.MachineConditionTypePowerStateSynced); reason != api.ReasonPowerOperationFailed {
+		t.Errorf("reason = %q, want %q", reason, api.ReasonPowerOperationFailed)
+	}
+	if r.machineObj.Status.PowerState != api.MachinePowerStateRunning {
+		t.Errorf("power state = %q, want Running", r.machineObj.Status.PowerState)
+	}
+}
+
+func TestReconcilePowerStateOutOfBandStart(t *testing.T) {
+	r, f := newFakeExecutorRequest(t)
+	r.machineObj.Status.PowerState = api.MachinePowerStateStopped
+	r.machineObj.Status.Connection = &api.MachineConnection{PublicIP: "10.0.0.9", DockerURL: "tcp://10.0.0.9:2376"}
+
+	if err := reconcilePower(t, r); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(f.ActionsFor(fake.VerbStart, "demo/vm")); n != 0 {
+		t.Errorf("a running machine was started %d times", n)
+	}
+	if r.machineObj.Status.Connection != nil {
+		t.Fatalf("connection details %+v are kept after an out of band start", r.machineObj.Status.Connection)
+	}
+
+	if err := r.updateConnectionInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if conn := r.machineObj.Status.Connection; conn == nil || conn.PublicIP != "10.0.0.1" {
+		t.Errorf("connection = %+v, want the address of the started machine", conn)
+	}
+}
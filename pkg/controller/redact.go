@@ -0,0 +1,185 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	redactedValue = "******"
+	// shorter values are not redacted, they would mangle unrelated output
+	minRedactedLength = 4
+)
+
+// redactor scrubs known secret values from text before it is logged or
+// written to the Machine status.
+type redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+func newRedactor() *redactor {
+	return &redactor{}
+}
+
+// add registers secret values. The base64 encoding of each value and the string
+// fields of JSON documents, e.g. service account keys, are registered as well.
+func (rd *redactor) add(values ...string) {
+	var secrets []string
+	for _, v := range values {
+		secrets = append(secrets, v, base64.StdEncoding.EncodeToString([]byte(v)))
+		var doc map[string]interface{}
+		if json.Unmarshal([]byte(v), &doc) == nil {
+			for _, field := range doc {
+				if s, ok := field.(string); ok {
+					secrets = append(secrets, s)
+				}
+			}
+		}
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	for _, s := range secrets {
+		s = strings.TrimSpace(s)
+		if len(s) < minRedactedLength || contains(rd.secrets, s) {
+			continue
+		}
+		rd.secrets = append(rd.secrets, s)
+	}
+	// replace longer values first, so that a value containing another one is fully redacted
+	sort.SliceStable(rd.secrets, func(i, j int) bool {
+		return len(rd.secrets[i]) > len(rd.secrets[j])
+	})
+}
+
+func (rd *redactor) redact(s string) string {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	for _, secret := range rd.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+	}
+	return s
+}
+
+func (rd *redactor) redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return rd.redact(val)
+	case []byte:
+		return rd.redact(string(val))
+	case error:
+		return rd.redact(val.Error())
+	case fmt.Stringer:
+		return rd.redact(val.String())
+	}
+	return v
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// addAuthSecret registers the values of the auth Secret of the Machine.
+func (r *machineRequest) addAuthSecret() error {
+	if r.machineObj.Spec.AuthSecret == nil {
+		return nil
+	}
+	secret, err := r.getSecret(r.machineObj.Spec.AuthSecret)
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, v := range secret.Data {
+		r.redactor.add(string(v))
+	}
+	return nil
+}
+
+// redactStatus scrubs secret values from the messages in the Machine status.
+func (r *machineRequest) redactStatus() {
+	for i := range r.machineObj.Status.Conditions {
+		r.machineObj.Status.Conditions[i].Message = r.redactor.redact(r.machineObj.Status.Conditions[i].Message)
+	}
+	if res := r.machineObj.Status.ScriptResult; res != nil {
+		res.Message = r.redactor.redact(res.Message)
+	}
+}
+
+// redactingLogSink scrubs secret values from log messages and values.
+type redactingLogSink struct {
+	logr.LogSink
+	rd *redactor
+}
+
+var _ logr.LogSink = redactingLogSink{}
+
+func newRedactingLogger(l logr.Logger, rd *redactor) logr.Logger {
+	sink := l.GetSink()
+	if sink == nil {
+		return l
+	}
+	// account for the extra frame of the wrapper
+	if cd, ok := sink.(logr.CallDepthLogSink); ok {
+		sink = cd.WithCallDepth(1)
+	}
+	return logr.New(redactingLogSink{LogSink: sink, rd: rd})
+}
+
+func (s redactingLogSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.LogSink.Info(level, s.rd.redact(msg), s.redactValues(keysAndValues)...)
+}
+
+func (s redactingLogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if err != nil {
+		err = errors.New(s.rd.redact(err.Error()))
+	}
+	s.LogSink.Error(err, s.rd.redact(msg), s.redactValues(keysAndValues)...)
+}
+
+func (s redactingLogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return redactingLogSink{LogSink: s.LogSink.WithValues(s.redactValues(keysAndValues)...), rd: s.rd}
+}
+
+func (s redactingLogSink) WithName(name string) logr.LogSink {
+	return redactingLogSink{LogSink: s.LogSink.WithName(name), rd: s.rd}
+}
+
+func (s redactingLogSink) redactValues(keysAndValues []interface{}) []interface{} {
+	out := make([]interface{}, len(keysAndValues))
+	for i, v := range keysAndValues {
+		out[i] = s.rd.redactValue(v)
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"os"
+	"path/filepath"
+
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cu "kmodules.xyz/client-go/client"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	machineStorageEnv        = "MACHINE_STORAGE_PATH"
+	machineStoreSecretSuffix = "-machine-store"
+	machineConfigFile        = "config.json"
+	machineLabel             = "docker-machine.klusters.dev/machine"
+)
+
+// DefaultMachineStoragePath returns the docker-machine store used when no
+// --storage-path is given, the same way docker-machine computes it.
+func DefaultMachineStoragePath() string {
+	if p := os.Getenv(machineStorageEnv); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
+	return filepath.Join(home, ".docker", "machine")
+}
+
+// machineStoreDir returns the docker-machine store directory of the Machine.
+func (r *machineRequest) machineStoreDir() string {
+	return filepath.Join(r.StoragePath, "machines", executor.MachineName(r.machineObj))
+}
+
+func (r *machineRequest) machineStoreSecretKey() types.NamespacedName {
+	return types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name + machineStoreSecretSuffix}
+}
+
+// saveMachineStore copies the docker-machine store directory of the Machine
+// into a Secret owned by the Machine. It is a no-op if the directory does not exist.
+func (r *machineRequest) saveMachineStore() error {
+	data, err := readMachineStore(r.machineStoreDir())
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	key := r.machineStoreSecretKey()
+	secret := &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+	}
+	var ownerErr error
+	_, err = cu.CreateOrPatch(r.ctx, r.KBClient, secret, func(object client.Object, createOp bool) client.Object {
+		s := object.(*core.Secret)
+		if s.Labels == nil {
+			s.Labels = map[string]string{}
+		}
+		s.Labels[machineLabel] = r.machineObj.Name
+		s.Type = core.SecretTypeOpaque
+		s.Data = data
+		ownerErr = controllerutil.SetControllerReference(r.machineObj, s, r.Scheme)
+		return s
+	})
+	if err != nil {
+		return err
+	}
+	return ownerErr
+}
+
+// ownsMachineStore reports whether the store Secret of the Machine holds a
+// docker-machine store, i.e. whether a machine that docker-machine already
+// knows was created for this Machine and not for another one of the same name.
+func (r *machineRequest) ownsMachineStore() (bool, error) {
+	var secret core.Secret
+	err := r.KBClient.Get(r.ctx, r.machineStoreSecretKey(), &secret)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return metav1.IsControlledBy(&secret, r.machineObj) && len(secret.Data[machineConfigFile]) > 0, nil
+}
+
+// restoreMachineStore writes the docker-machine store directory of the Machine
+// back to disk from its Secret, if it is missing locally. This lets the operator
+// manage machines that were created before it was restarted.
+func (r *machineRequest) restoreMachineStore() error {
+	dir := r.machineStoreDir()
+	if _, err := os.Stat(filepath.Join(dir, machineConfigFile)); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	var secret core.Secret
+	err := r.KBClient.Get(r.ctx, r.machineStoreSecretKey(), &secret)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Log.Info("Restoring docker-machine store", "Dir", dir)
+	return writeMachineStore(dir, secret.Data)
+}
+
+// deleteMachineStore removes the docker-machine store of the Machine, locally
+// and in its Secret, so that the machine is not restored before it is created again.
+func (r *machineRequest) deleteMachineStore() error {
+	if err := os.RemoveAll(r.machineStoreDir()); err != nil {
+		return err
+	}
+	key := r.machineStoreSecretKey()
+	err := r.KBClient.Delete(r.ctx, &core.Secret{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}})
+	return client.IgnoreNotFound(err)
+}
+
+func readMachineStore(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		// cloud drivers keep a flat store directory, nested files are not persisted
+		if !e.Type().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		data[e.Name()] = content
+	}
+	return data, nil
+}
+
+func writeMachineStore(dir string, data map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for name, content := range data {
+		if name != filepath.Base(name) {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Machine store", func() {
+	var (
+		ctx     context.Context
+		machine *api.Machine
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		ns := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "store-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		machine = &api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: ns.Name},
+			Spec: api.MachineSpec{
+				Driver:     &core.LocalObjectReference{Name: GoogleDriver},
+				AuthSecret: &kmapi.ObjectReference{Name: "cred", Namespace: ns.Name},
+			},
+		}
+		Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+	})
+
+	newReconciler := func(storagePath string) *machineRequest {
+		r := (&MachineReconciler{
+			KBClient:    k8sClient,
+			Scheme:      scheme.Scheme,
+			StoragePath: storagePath,
+		}).newMachineRequest(ctx)
+		r.machineObj = machine.DeepCopy()
+		return r
+	}
+
+	It("restores the machine store after an operator restart", func() {
+		files := map[string]string{
+			"config.json": `{"Name":"vm"}`,
+			"id_rsa":      "private-key",
+			"ca.pem":      "ca",
+		}
+
+		By("saving the store written by docker-machine create")
+		before := newReconciler(GinkgoT().TempDir())
+		Expect(os.MkdirAll(before.machineStoreDir(), 0o700)).To(Succeed())
+		for name, content := range files {
+			Expect(os.WriteFile(filepath.Join(before.machineStoreDir(), name), []byte(content), 0o600)).To(Succeed())
+		}
+		Expect(before.saveMachineStore()).To(Succeed())
+
+		var secret core.Secret
+		Expect(k8sClient.Get(ctx, before.machineStoreSecretKey(), &secret)).To(Succeed())
+		Expect(metav1.IsControlledBy(&secret, machine)).To(BeTrue())
+		Expect(secret.Data).To(HaveLen(len(files)))
+
+		By("restoring the store into an empty storage path")
+		after := newReconciler(GinkgoT().TempDir())
+		Expect(after.restoreMachineStore()).To(Succeed())
+		for name, content := range files {
+			path := filepath.Join(after.machineStoreDir(), name)
+			Expect(os.ReadFile(path)).To(BeEquivalentTo(content))
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o600)))
+		}
+	})
+
+	It("does not touch an existing local store", func() {
+		r := newReconciler(GinkgoT().TempDir())
+		Expect(os.MkdirAll(r.machineStoreDir(), 0o700)).To(Succeed())
+		config := filepath.Join(r.machineStoreDir(), machineConfigFile)
+		Expect(os.WriteFile(config, []byte("local"), 0o600)).To(Succeed())
+
+		Expect(k8sClient.Create(ctx, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: r.machineStoreSecretKey().Name, Namespace: machine.Namespace},
+			Data:       map[string][]byte{machineConfigFile: []byte("remote")},
+		})).To(Succeed())
+
+		Expect(r.restoreMachineStore()).To(Succeed())
+		Expect(os.ReadFile(config)).To(BeEquivalentTo("local"))
+	})
+
+	It("only owns a store saved for the Machine", func() {
+		r := newReconciler(GinkgoT().TempDir())
+		Expect(r.ownsMachineStore()).To(BeFalse())
+
+		By("finding a store Secret the Machine does not control")
+		secret := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: r.machineStoreSecretKey().Name, Namespace: machine.Namespace},
+			Data:       map[string][]byte{machineConfigFile: []byte(`{"Name":"other"}`)},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		Expect(r.ownsMachineStore()).To(BeFalse())
+		Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+
+		By("saving the store of the Machine")
+		Expect(os.MkdirAll(r.machineStoreDir(), 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(r.machineStoreDir(), machineConfigFile), []byte(`{"Name":"vm"}`), 0o600)).To(Succeed())
+		Expect(r.saveMachineStore()).To(Succeed())
+		Expect(r.ownsMachineStore()).To(BeTrue())
+	})
+
+	It("writes the connection Secret only into a Secret the Machine controls", func() {
+		r := newReconciler(GinkgoT().TempDir())
+		store := map[string][]byte{}
+		for _, name := range connectionSecretFiles {
+			store[name] = []byte(name)
+		}
+		Expect(writeMachineStore(r.machineStoreDir(), store)).To(Succeed())
+		r.machineObj.Status.Connection = &api.MachineConnection{DockerURL: "tcp://203.0.113.10:2376"}
+
+		By("creating a Secret owned by the Machine")
+		r.machineObj.Spec.WriteConnectionSecretToRef = &core.LocalObjectReference{Name: "conn"}
+		Expect(r.writeConnectionSecret()).To(Succeed())
+		var secret core.Secret
+		Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: "conn"}, &secret)).To(Succeed())
+		Expect(metav1.IsControlledBy(&secret, machine)).To(BeTrue())
+		Expect(r.writeConnectionSecret()).To(Succeed())
+
+		By("leaving a Secret of the user alone")
+		user := &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "user", Namespace: machine.Namespace},
+			Data:       map[string][]byte{"token": []byte("user")},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		r.machineObj.Spec.WriteConnectionSecretToRef = &core.LocalObjectReference{Name: "user"}
+		Expect(r.writeConnectionSecret()).NotTo(Succeed())
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(user), &secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(user.Data))
+		Expect(secret.OwnerReferences).To(BeEmpty())
+	})
+
+	It("ignores machines without a saved store", func() {
+		r := newReconciler(GinkgoT().TempDir())
+		Expect(r.restoreMachineStore()).To(Succeed())
+		_, err := os.Stat(r.machineStoreDir())
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		err = k8sClient.Get(ctx, r.machineStoreSecretKey(), &core.Secret{})
+		Expect(kerr.IsNotFound(err)).To(BeTrue())
+	})
+})
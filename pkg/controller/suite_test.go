@@ -1,3 +1,5 @@
+//go:build envtest
+
 /*
 Copyright 2023.
 
@@ -17,32 +19,50 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	//+kubebuilder:scaffold:imports
 
 	dockermachinev1alpha1 "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor/fake"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+const (
+	timeout  = 30 * time.Second
+	interval = 250 * time.Millisecond
+)
+
 var (
 	cfg       *rest.Config
 	k8sClient client.Client
 	testEnv   *envtest.Environment
+	// fakeExecutor serves the docker-machine operations of the Machine reconciler
+	fakeExecutor *fake.Executor
+	// tmpDir holds the driver, storage and work directories of the reconcilers
+	tmpDir      string
+	stopManager context.CancelFunc
 )
 
+// TestControllers runs against the envtest binaries in KUBEBUILDER_ASSETS. The suite is
+// built with the envtest tag only, run it with make envtest-tests.
 func TestControllers(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -54,7 +74,7 @@ var _ = BeforeSuite(func() {
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "crds")},
 		ErrorIfCRDPathMissing: true,
 	}
 
@@ -72,10 +92,60 @@ var _ = BeforeSuite(func() {
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
+
+	By("starting the reconcilers")
+	operationPollInterval = interval
+	scriptPollInterval = interval
+	resyncInterval = interval
+	fakeExecutor = fake.NewExecutor()
+	tmpDir, err = os.MkdirTemp("", "docker-machine-operator-")
+	Expect(err).NotTo(HaveOccurred())
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:  scheme.Scheme,
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect((&DriverReconciler{
+		KBClient:  mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		DriverDir: filepath.Join(tmpDir, "drivers"),
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&MachineReconciler{
+		KBClient:                mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		StoragePath:             filepath.Join(tmpDir, "storage"),
+		WorkDir:                 filepath.Join(tmpDir, "machines"),
+		DriverDir:               filepath.Join(tmpDir, "drivers"),
+		MaxConcurrentReconciles: 8,
+		Executor:                fakeExecutor,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&MachineSetReconciler{
+		KBClient: mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&MachineDeploymentReconciler{
+		KBClient: mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, stopManager = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 })
 
 var _ = AfterSuite(func() {
+	if cfg == nil {
+		return
+	}
 	By("tearing down the test environment")
+	if stopManager != nil {
+		stopManager()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
+	Expect(os.RemoveAll(tmpDir)).To(Succeed())
 })
@@ -17,15 +17,16 @@ limitations under the License.
 package controller
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"time"
+	"path/filepath"
 
 	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
 
+	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	kutil "kmodules.xyz/client-go"
 	kmapi "kmodules.xyz/client-go/api/v1"
@@ -46,10 +47,11 @@ const (
 const (
 	defaultUserName    = "docker-user"
 	defaultAWSUserName = "ubuntu"
-	tempDirectory      = "tmp"
+	// DefaultWorkDir is the directory under which every Machine gets its own working directory.
+	DefaultWorkDir = "/tmp/docker-machine-operator/machines"
 )
 
-func (r *MachineReconciler) ensureFinalizer() error {
+func (r *machineRequest) ensureFinalizer() error {
 	finalizerName := api.GetFinalizer()
 	if !controllerutil.ContainsFinalizer(r.machineObj, finalizerName) {
 		if err := r.patchFinalizer(kutil.VerbCreated, finalizerName); err != nil {
@@ -61,24 +63,69 @@ func (r *MachineReconciler) ensureFinalizer() error {
 	return nil
 }
 
-func (r *MachineReconciler) removeFinalizerAfterCleanup() error {
+// removeFinalizerAfterCleanup returns true while the cleanup is in progress.
+func (r *machineRequest) removeFinalizerAfterCleanup() (bool, error) {
 	finalizerName := api.GetFinalizer()
 	if controllerutil.ContainsFinalizer(r.machineObj, finalizerName) {
 		if err := r.updateMachineStatus(types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name}); err != nil {
-			return err
+			return false, err
 		}
-		if err := r.cleanupMachineResources(); err != nil {
-			return err
+		done, err := r.cleanupMachine()
+		if err != nil || !done {
+			return !done && err == nil, err
 		}
 		if err := r.patchFinalizer(kutil.VerbDeleted, finalizerName); err != nil {
-			return err
+			return false, err
 		}
 		r.Log.Info(fmt.Sprintf("Finalizer %v removed", finalizerName))
 	}
-	return nil
+	return false, nil
 }
 
-func (r *MachineReconciler) patchFinalizer(verbType kutil.VerbType, finalizerName string) error {
+// cleanupMachine runs the cleanup of the Machine resources in the background.
+// It returns true once the cleanup has finished.
+func (r *machineRequest) cleanupMachine() (bool, error) {
+	key := types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name}
+	if r.machineObj.Spec.Driver == nil {
+		// nothing is created without a driver, e.g. if the class of the Machine never existed
+		return true, nil
+	}
+	if op := r.machineObj.Status.Operation; op != nil && op.Type != api.MachineOperationDelete {
+		// stop the operation in flight and wait for it to exit before deleting the machine
+		if r.Operations.Cancel(r.operationKey(), op.ID) {
+			return false, nil
+		}
+		r.finishedOperation = op.ID
+		r.machineObj.Status.Operation = nil
+	}
+
+	if r.machineObj.Status.Operation != nil {
+		state, err := r.operationResult()
+		switch state {
+		case operationRunning:
+			return false, nil
+		case operationSucceeded:
+			r.event(core.EventTypeNormal, EventReasonDeleted, "Deleted machine")
+			return true, r.updateMachineStatus(key)
+		case operationFailed:
+			r.warning(EventReasonDeleteFailed, "Failed to delete machine: %v", err)
+			// the cleanup is started again on the next reconcile
+			return false, err
+		}
+		// interrupted by an operator restart, the cleanup is idempotent and started again
+		r.Log.Info("Restarting interrupted delete operation", "ID", r.machineObj.Status.Operation.ID)
+	}
+
+	if err := r.startOperation(api.MachineOperationDelete, machineDeletionTimeout, func(op *machineRequest) error {
+		return op.cleanupMachineResources()
+	}); err != nil {
+		return false, err
+	}
+	r.event(core.EventTypeNormal, EventReasonDeleting, "Deleting machine")
+	return false, r.updateMachineStatus(key)
+}
+
+func (r *machineRequest) patchFinalizer(verbType kutil.VerbType, finalizerName string) error {
 	_, err := cu.CreateOrPatch(context.TODO(), r.KBClient, r.machineObj, func(object client.Object, createOp bool) client.Object {
 		mc := object.(*api.Machine)
 		switch verbType {
@@ -89,10 +136,11 @@ func (r *MachineReconciler) patchFinalizer(verbType kutil.VerbType, finalizerNam
 		}
 		return mc
 	})
+	r.applyEffectiveSpec()
 	return err
 }
 
-func (r *MachineReconciler) cleanupMachineResources() error {
+func (r *machineRequest) cleanupMachineResources() error {
 	var err error
 	err = r.deleteFiles()
 	if err != nil {
@@ -118,44 +166,49 @@ func (r *MachineReconciler) cleanupMachineResources() error {
 	return nil
 }
 
-func (r *MachineReconciler) deleteFiles() error {
-	err := os.Remove(r.getScriptFilePath())
-	if err != nil && os.IsExist(err) {
+// deleteFiles removes the working directory of the Machine, along with the
+// parent directories that are left empty.
+func (r *machineRequest) deleteFiles() error {
+	dir := r.getWorkDir()
+	if err := os.RemoveAll(dir); err != nil {
 		return err
 	}
+	for i := 0; i < 2; i++ {
+		dir = filepath.Dir(dir)
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
 
-	resultFilePath := tempDirectory + "result.txt"
-	err = os.Remove(resultFilePath)
-	if err != nil && os.IsExist(err) {
+func (r *machineRequest) deleteDockerMachine() error {
+	err := r.Executor.Remove(r.ctx, r.machineObj, r.executorOptions())
+	if err != nil && !errors.Is(err, executor.ErrMachineNotFound) {
+		r.Log.Info("Error machine deletion", "Error: ", err.Error())
 		return err
 	}
 	return nil
 }
 
-func (r *MachineReconciler) deleteDockerMachine() error {
-	args := []string{"rm", r.machineObj.Name, "-y"}
-	cmd := exec.Command("docker-machine", args...)
-	var commandOutput, commandError bytes.Buffer
-	cmd.Stdout = &commandOutput
-	cmd.Stderr = &commandError
-
-	err := cmd.Run()
-	if err != nil {
-		r.Log.Info("Error machine deletion", "Error: ", commandError.String(), "Output: ", commandOutput.String())
-		return client.IgnoreNotFound(err)
+// executorOptions locate the docker-machine state of the Machine for the Executor.
+func (r *machineRequest) executorOptions() executor.Options {
+	return executor.Options{
+		WorkDir:     r.getWorkDir(),
+		StoreSecret: r.machineStoreSecretKey().Name,
+		DriverDir:   namespaceDriverDir(r.DriverDir, r.machineObj.Namespace),
 	}
-	return nil
 }
 
 // reconciled returns an empty result with nil error to signal a successful reconcile
 // to the controller manager
-func (r *MachineReconciler) reconciled() (ctrl.Result, error) {
+func (r *machineRequest) reconciled() (ctrl.Result, error) {
 	return ctrl.Result{}, nil
 }
 
 // requeueWithError is a wrapper around logging an error message
 // then passes the error through to the controller manager
-func (r *MachineReconciler) requeueWithError(msg string, err error) (ctrl.Result, error) {
+func (r *machineRequest) requeueWithError(msg string, err error) (ctrl.Result, error) {
 	updErr := r.updateMachineStatus(types.NamespacedName{Namespace: r.machineObj.Namespace, Name: r.machineObj.Name})
 	if updErr != nil {
 		return ctrl.Result{}, updErr
@@ -165,21 +218,26 @@ func (r *MachineReconciler) requeueWithError(msg string, err error) (ctrl.Result
 	return ctrl.Result{}, err
 }
 
-func (r *MachineReconciler) updateMachineStatus(namespacedName client.ObjectKey) error {
+func (r *machineRequest) updateMachineStatus(namespacedName client.ObjectKey) error {
 	machine := &api.Machine{}
 	if err := r.KBClient.Get(r.ctx, namespacedName, machine); err != nil {
 		return err
 	}
 	cutil.SetSummary(r.machineObj, cutil.WithConditions(api.ConditionsOrder()...))
+	r.redactStatus()
+	r.machineObj.Status.ObservedGeneration = r.machineObj.Generation
 	r.machineObj.Status.Phase = api.GetPhase(r.machineObj)
 
 	if err := r.committer(r.ctx, machine, r.machineObj); err != nil {
 		return err
 	}
+	r.forgetFinishedOperation()
+	// the patch returns the stored spec of the Machine
+	r.applyEffectiveSpec()
 	return nil
 }
 
-func (r *MachineReconciler) setInitialConditions() error {
+func (r *machineRequest) setInitialConditions() error {
 	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeMachineReady, api.ReasonMachineCreating, kmapi.ConditionSeverityError,
 		"Waiting for Machine to become ready")
 	cutil.MarkFalse(r.machineObj, api.MachineConditionTypeClusterOperationComplete, api.ReasonWaitingForScriptRun, kmapi.ConditionSeverityError,
@@ -192,15 +250,11 @@ func (r *MachineReconciler) setInitialConditions() error {
 }
 
 // isMarkedForDeletion determines if the object is marked for deletion
-func (r *MachineReconciler) isMarkedForDeletion() bool {
+func (r *machineRequest) isMarkedForDeletion() bool {
 	return !r.machineObj.GetDeletionTimestamp().IsZero()
 }
 
-func (r *MachineReconciler) SetLoggerWithReq(req ctrl.Request) {
-	r.Log = ctrl.Log.WithValues(api.ResourceKindMachine, req.NamespacedName)
-}
-
-func (r *MachineReconciler) patchAnnotation(key, value string) error {
+func (r *machineRequest) patchAnnotation(key, value string) error {
 	_, err := cu.CreateOrPatch(context.TODO(), r.KBClient, r.machineObj, func(object client.Object, createOp bool) client.Object {
 		mc := object.(*api.Machine)
 		anno := mc.GetAnnotations()
@@ -211,9 +265,29 @@ func (r *MachineReconciler) patchAnnotation(key, value string) error {
 		mc.SetAnnotations(anno)
 		return mc
 	})
+	r.applyEffectiveSpec()
 	return err
 }
 
+// removeAnnotations removes the annotations from the Machine. Unlike
+// patchAnnotation, it keeps the status of the Machine object of the request.
+func (r *machineRequest) removeAnnotations(keys ...string) error {
+	_, err := cu.CreateOrPatch(r.ctx, r.KBClient, r.machineObj.DeepCopy(), func(object client.Object, createOp bool) client.Object {
+		mc := object.(*api.Machine)
+		for _, key := range keys {
+			delete(mc.Annotations, key)
+		}
+		return mc
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(r.machineObj.Annotations, key)
+	}
+	return nil
+}
+
 func stringToP(st string) *string {
 	return &st
 }
@@ -226,22 +300,8 @@ func stringPSlice(sl []string) []*string {
 	return ret
 }
 
-func waitForState(retry, timeout time.Duration, getStatus func() (bool, error)) error {
-	for t := time.Second * 0; t <= timeout; t += retry {
-		fmt.Println("getting state")
-		res, err := getStatus()
-		if err != nil {
-			return err
-		}
-		if res {
-			return nil
-		}
-		fmt.Println("retrying")
-		time.Sleep(retry)
-	}
-	return fmt.Errorf("failed to get desired status")
-}
-
-func (r *MachineReconciler) getScriptFilePath() string {
-	return fmt.Sprintf("/%s/%s-%s-startup.sh", tempDirectory, r.machineObj.Namespace, r.machineObj.Name)
+// getWorkDir returns the working directory of the Machine. It includes the UID,
+// so a re-created Machine with the same name never sees files of the old one.
+func (r *machineRequest) getWorkDir() string {
+	return filepath.Join(r.WorkDir, r.machineObj.Namespace, r.machineObj.Name, string(r.machineObj.UID))
 }
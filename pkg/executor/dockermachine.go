@@ -0,0 +1,143 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+// DockerMachine implements Executor with the docker-machine CLI.
+type DockerMachine struct {
+	// Commands runs the commands that change a machine: create, rm, ssh, scp, start, stop and restart.
+	Commands CommandExecutor
+	// Queries runs the commands that only read the local docker-machine store:
+	// inspect, url, ip, status and read-only ssh. Commands is used if nil.
+	Queries CommandExecutor
+}
+
+var _ Executor = &DockerMachine{}
+
+func NewDockerMachine(commands, queries CommandExecutor) *DockerMachine {
+	return &DockerMachine{Commands: commands, Queries: queries}
+}
+
+func (d *DockerMachine) Create(ctx context.Context, machine *api.Machine, opts CreateOptions) error {
+	args := append([]string{"create", "--driver", opts.Driver}, opts.Args...)
+	cmd := newCommand(opts.Options, "create", append(args, MachineName(machine))...)
+	cmd.Env = opts.Env
+	cmd.SecretFiles = opts.SecretFiles
+	_, err := d.run(ctx, d.Commands, machine, cmd)
+	return err
+}
+
+func (d *DockerMachine) Remove(ctx context.Context, machine *api.Machine, opts Options) error {
+	_, err := d.run(ctx, d.Commands, machine, newCommand(opts, "rm", "rm", MachineName(machine), "-y"))
+	return err
+}
+
+func (d *DockerMachine) SSH(ctx context.Context, machine *api.Machine, opts Options, command ...string) ([]byte, error) {
+	e := d.Commands
+	if opts.ReadOnly {
+		e = d.queries()
+	}
+	return d.run(ctx, e, machine, newCommand(opts, "ssh", append([]string{"ssh", MachineName(machine)}, command...)...))
+}
+
+func (d *DockerMachine) SCP(ctx context.Context, machine *api.Machine, opts Options, src, dst string) error {
+	_, err := d.run(ctx, d.Commands, machine, newCommand(opts, "scp", "scp", src, dst))
+	return err
+}
+
+func (d *DockerMachine) Inspect(ctx context.Context, machine *api.Machine, opts Options) ([]byte, error) {
+	return d.run(ctx, d.queries(), machine, newCommand(opts, "inspect", "inspect", MachineName(machine)))
+}
+
+func (d *DockerMachine) URL(ctx context.Context, machine *api.Machine, opts Options) (string, error) {
+	out, err := d.run(ctx, d.queries(), machine, newCommand(opts, "url", "url", MachineName(machine)))
+	return strings.TrimSpace(string(out)), err
+}
+
+func (d *DockerMachine) IP(ctx context.Context, machine *api.Machine, opts Options) (string, error) {
+	out, err := d.run(ctx, d.queries(), machine, newCommand(opts, "ip", "ip", MachineName(machine)))
+	return strings.TrimSpace(string(out)), err
+}
+
+func (d *DockerMachine) Status(ctx context.Context, machine *api.Machine, opts Options) (string, error) {
+	out, err := d.run(ctx, d.queries(), machine, newCommand(opts, "status", "status", MachineName(machine)))
+	return strings.TrimSpace(string(out)), err
+}
+
+func (d *DockerMachine) Start(ctx context.Context, machine *api.Machine, opts Options) error {
+	_, err := d.run(ctx, d.Commands, machine, newCommand(opts, "start", "start", MachineName(machine)))
+	return err
+}
+
+func (d *DockerMachine) Stop(ctx context.Context, machine *api.Machine, opts Options) error {
+	_, err := d.run(ctx, d.Commands, machine, newCommand(opts, "stop", "stop", MachineName(machine)))
+	return err
+}
+
+func (d *DockerMachine) Restart(ctx context.Context, machine *api.Machine, opts Options) error {
+	_, err := d.run(ctx, d.Commands, machine, newCommand(opts, "restart", "restart", MachineName(machine)))
+	return err
+}
+
+func (d *DockerMachine) queries() CommandExecutor {
+	if d.Queries != nil {
+		return d.Queries
+	}
+	return d.Commands
+}
+
+func (d *DockerMachine) run(ctx context.Context, e CommandExecutor, machine *api.Machine, cmd Command) ([]byte, error) {
+	res, err := e.Execute(ctx, machine, cmd)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return res.Stdout, nil
+}
+
+func newCommand(opts Options, name string, args ...string) Command {
+	return Command{
+		Name:        name,
+		Args:        args,
+		WorkDir:     opts.WorkDir,
+		StoreSecret: opts.StoreSecret,
+		DriverDir:   opts.DriverDir,
+	}
+}
+
+// classifyError wraps the errors docker-machine reports for known and unknown machines,
+// e.g. 'Host already exists: "vm"' and 'Host does not exist: "vm"'.
+func classifyError(err error) error {
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	switch {
+	case strings.Contains(exitErr.Stderr, "already exists"):
+		return fmt.Errorf("%w: %w", ErrMachineExists, err)
+	case strings.Contains(exitErr.Stderr, "does not exist"):
+		return fmt.Errorf("%w: %w", ErrMachineNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recorder records the commands and fails them with stderr if set.
+type recorder struct {
+	cmds   []Command
+	stdout string
+	stderr string
+}
+
+func (r *recorder) Execute(_ context.Context, _ *api.Machine, cmd Command) (*Result, error) {
+	r.cmds = append(r.cmds, cmd)
+	res := &Result{Stdout: []byte(r.stdout), Stderr: []byte(r.stderr)}
+	if r.stderr != "" {
+		res.ExitCode = 1
+		return res, &ExitError{Command: cmd.Name, ExitCode: 1, Stderr: r.stderr}
+	}
+	return res, nil
+}
+
+func TestDockerMachine(t *testing.T) {
+	machine := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "vm"}}
+	opts := Options{WorkDir: "/work/vm", StoreSecret: "vm-store"}
+	commands, queries := &recorder{}, &recorder{stdout: "Running\n"}
+	d := NewDockerMachine(commands, queries)
+	ctx := context.Background()
+
+	err := d.Create(ctx, machine, CreateOptions{
+		Options:     opts,
+		Driver:      "google",
+		Args:        []string{"--google-project", "p"},
+		SecretFiles: []SecretFile{{Env: "GOOGLE_APPLICATION_CREDENTIALS"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SSH(ctx, machine, opts, "cat", "/tmp/result.txt"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := d.Status(ctx, machine, opts)
+	if err != nil || state != "Running" {
+		t.Fatalf("Status() = %q, %v", state, err)
+	}
+	readOnly := opts
+	readOnly.ReadOnly = true
+	if _, err := d.SSH(ctx, machine, readOnly, "cat", "/tmp/result.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	wantArgs := [][]string{
+		{"create", "--driver", "google", "--google-project", "p", "demo-vm"},
+		{"ssh", "demo-vm", "cat", "/tmp/result.txt"},
+	}
+	for i, cmd := range commands.cmds {
+		if !reflect.DeepEqual(cmd.Args, wantArgs[i]) || cmd.WorkDir != opts.WorkDir || cmd.StoreSecret != opts.StoreSecret {
+			t.Errorf("command %d = %+v", i, cmd)
+		}
+	}
+	if len(commands.cmds[0].SecretFiles) != 1 {
+		t.Errorf("secret files were not passed to create")
+	}
+	if len(commands.cmds) != len(wantArgs) {
+		t.Errorf("unexpected commands %+v", commands.cmds)
+	}
+	if len(queries.cmds) != 2 || !reflect.DeepEqual(queries.cmds[0].Args, []string{"status", "demo-vm"}) {
+		t.Errorf("status was not run as a query: %+v", queries.cmds)
+	}
+	if len(queries.cmds) == 2 && !reflect.DeepEqual(queries.cmds[1].Args, []string{"ssh", "demo-vm", "cat", "/tmp/result.txt"}) {
+		t.Errorf("read-only ssh was not run as a query: %+v", queries.cmds[1])
+	}
+}
+
+func TestMachineName(t *testing.T) {
+	a := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "workers-0"}}
+	b := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "workers-0"}}
+	if MachineName(a) == MachineName(b) {
+		t.Errorf("Machines of different namespaces share the docker-machine name %s", MachineName(a))
+	}
+	if got := MachineName(a); got != "team-a-workers-0" {
+		t.Errorf("MachineName() = %s", got)
+	}
+}
+
+func TestDockerMachineErrors(t *testing.T) {
+	machine := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "vm"}}
+	ctx := context.Background()
+
+	d := NewDockerMachine(&recorder{stderr: `Error creating machine: Host already exists: "vm"`}, nil)
+	err := d.Create(ctx, machine, CreateOptions{Driver: "google"})
+	var exitErr *ExitError
+	if !errors.Is(err, ErrMachineExists) || !errors.As(err, &exitErr) {
+		t.Errorf("Create() = %v, want ErrMachineExists", err)
+	}
+
+	d = NewDockerMachine(&recorder{stderr: `Host does not exist: "vm"`}, nil)
+	if err := d.Remove(ctx, machine, Options{}); !errors.Is(err, ErrMachineNotFound) {
+		t.Errorf("Remove() = %v, want ErrMachineNotFound", err)
+	}
+
+	d = NewDockerMachine(&recorder{stderr: "connection refused"}, nil)
+	if _, err := d.URL(ctx, machine, Options{}); err == nil || errors.Is(err, ErrMachineNotFound) || errors.Is(err, ErrMachineExists) {
+		t.Errorf("URL() = %v", err)
+	}
+}
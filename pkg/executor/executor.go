@@ -0,0 +1,178 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+)
+
+const (
+	// ModeLocal runs docker-machine as a child process of the operator.
+	ModeLocal = "local"
+	// ModeJob runs docker-machine in a Kubernetes Job per command.
+	ModeJob = "job"
+)
+
+// Command is a docker-machine invocation for a Machine.
+type Command struct {
+	// Name is a short name of the command, e.g. create. Job executors use it in the Job name.
+	Name string
+	// Args are passed to docker-machine. $(VAR) references to the variables in
+	// Env and SecretFiles are expanded, the same way Kubernetes expands container args.
+	Args []string
+	Env  []EnvVar
+	// SecretFiles are written to files only readable by the command.
+	SecretFiles []SecretFile
+	// WorkDir is the working directory of the Machine on the operator host.
+	// Local executors write the secret files below it.
+	WorkDir string
+	// StoreSecret is the Secret that holds the docker-machine store of the Machine.
+	// Job executors mount it into the pod, so that docker-machine finds the machine.
+	StoreSecret string
+	// DriverDir holds the non-builtin driver binaries the command may use.
+	// Local executors prepend it to PATH of the command only.
+	DriverDir string
+}
+
+// EnvVar is an environment variable of a Command, either a plain value or a key of a Secret.
+type EnvVar struct {
+	Name      string
+	Value     string
+	SecretRef *SecretKeyRef
+}
+
+// SecretFile makes a key of a Secret available to the command as a file. The
+// variable Env holds the path of the file.
+type SecretFile struct {
+	Env       string
+	SecretRef SecretKeyRef
+}
+
+// SecretKeyRef selects a key of a Secret.
+type SecretKeyRef struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// Result holds the output of a finished Command.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// ExitError is returned when docker-machine exits with a non-zero code.
+type ExitError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("docker-machine %s exited with code %d: %s", e.Command, e.ExitCode, strings.TrimSpace(e.Stderr))
+}
+
+// CommandExecutor runs docker-machine commands for Machines. Execute blocks until
+// the command finishes or ctx is done. The Result is returned along with an
+// *ExitError when the command fails.
+type CommandExecutor interface {
+	Execute(ctx context.Context, machine *api.Machine, cmd Command) (*Result, error)
+}
+
+var (
+	// ErrMachineExists is returned by Executor.Create when docker-machine already knows the machine.
+	ErrMachineExists = errors.New("machine already exists")
+	// ErrMachineNotFound is returned when docker-machine does not know the machine.
+	ErrMachineNotFound = errors.New("machine does not exist")
+)
+
+// MachineName returns the docker-machine name of the Machine. It includes the
+// namespace, as docker-machine keeps the machines of all namespaces in one store.
+func MachineName(machine *api.Machine) string {
+	if machine.Namespace == "" {
+		return machine.Name
+	}
+	return machine.Namespace + "-" + machine.Name
+}
+
+// Options locate the docker-machine state of a Machine, see Command.
+type Options struct {
+	WorkDir     string
+	StoreSecret string
+	DriverDir   string
+	// ReadOnly marks an SSH command that only reads from the machine, e.g. the
+	// poll of the script result. It runs like the queries of the local store.
+	ReadOnly bool
+}
+
+// CreateOptions configure the machine created by Executor.Create.
+type CreateOptions struct {
+	Options
+	// Driver is the docker-machine driver of the machine.
+	Driver string
+	// Args are the driver flags. They may reference the variables in Env and SecretFiles.
+	Args        []string
+	Env         []EnvVar
+	SecretFiles []SecretFile
+}
+
+// Executor runs the docker-machine operations of Machines. Failed operations
+// return ErrMachineExists or ErrMachineNotFound where applicable, along with
+// an *ExitError when docker-machine exited with a non-zero code.
+type Executor interface {
+	Create(ctx context.Context, machine *api.Machine, opts CreateOptions) error
+	Remove(ctx context.Context, machine *api.Machine, opts Options) error
+	// SSH runs command on the machine and returns its output.
+	SSH(ctx context.Context, machine *api.Machine, opts Options, command ...string) ([]byte, error)
+	// SCP copies files from and to the machine, remote paths are prefixed with
+	// the machine name, e.g. vm:/tmp/result.txt.
+	SCP(ctx context.Context, machine *api.Machine, opts Options, src, dst string) error
+	// Inspect returns the docker-machine inspect document of the machine.
+	Inspect(ctx context.Context, machine *api.Machine, opts Options) ([]byte, error)
+	// URL returns the docker host URL of the machine.
+	URL(ctx context.Context, machine *api.Machine, opts Options) (string, error)
+	IP(ctx context.Context, machine *api.Machine, opts Options) (string, error)
+	// Status returns the state of the machine, e.g. Running or Stopped.
+	Status(ctx context.Context, machine *api.Machine, opts Options) (string, error)
+	Start(ctx context.Context, machine *api.Machine, opts Options) error
+	Stop(ctx context.Context, machine *api.Machine, opts Options) error
+	Restart(ctx context.Context, machine *api.Machine, opts Options) error
+}
+
+var varRef = regexp.MustCompile(`\$\(([A-Za-z_][A-Za-z0-9_]*)\)`)
+
+// expandArgs replaces $(VAR) references with the values in vars. Unknown
+// references are left as is.
+func expandArgs(args []string, vars map[string]string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = varRef.ReplaceAllStringFunc(arg, func(ref string) string {
+			if v, ok := vars[varRef.FindStringSubmatch(ref)[1]]; ok {
+				return v
+			}
+			return ref
+		})
+	}
+	return out
+}
@@ -0,0 +1,401 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+// Package fake provides a scriptable in-memory executor.Executor for tests.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+)
+
+// verbs of the actions recorded by the Executor
+const (
+	VerbCreate  = "create"
+	VerbRemove  = "rm"
+	VerbSSH     = "ssh"
+	VerbSCP     = "scp"
+	VerbInspect = "inspect"
+	VerbURL     = "url"
+	VerbIP      = "ip"
+	VerbStatus  = "status"
+	VerbStart   = "start"
+	VerbStop    = "stop"
+	VerbRestart = "restart"
+)
+
+// states reported by Executor.Status
+const (
+	StateRunning = "Running"
+	StateStopped = "Stopped"
+)
+
+// Action is a call to the Executor.
+type Action struct {
+	Verb string
+	// Machine is the namespace/name of the Machine.
+	Machine string
+	Args    []string
+	Options executor.Options
+	// Create holds the options of a create action.
+	Create *executor.CreateOptions
+}
+
+// ReactionFunc handles an action. A reaction that returns handled=false passes
+// the action on to the next reaction and finally to the default behavior.
+type ReactionFunc func(ctx context.Context, action Action) (handled bool, out []byte, err error)
+
+// Machine is the state of a fake machine.
+type Machine struct {
+	Driver string
+	State  string
+	IP     string
+	// Files holds the files on the machine by path. ssh cat and scp read and write them.
+	Files map[string][]byte
+}
+
+type reaction struct {
+	verb string
+	fn   ReactionFunc
+}
+
+// Executor is an in-memory executor.Executor. Machines are created running
+// with an address from 10.0.0.0/24, "ssh <machine> cat <path>" prints Files.
+// Reactions script failures, delays and custom outputs.
+type Executor struct {
+	mu        sync.Mutex
+	machines  map[string]*Machine
+	actions   []Action
+	reactions []reaction
+	nextIP    int
+}
+
+var _ executor.Executor = &Executor{}
+
+func NewExecutor() *Executor {
+	return &Executor{machines: map[string]*Machine{}}
+}
+
+// PrependReaction runs fn before the reactions added earlier for actions with
+// the verb. The verb "*" matches all actions.
+func (f *Executor) PrependReaction(verb string, fn ReactionFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reactions = append([]reaction{{verb: verb, fn: fn}}, f.reactions...)
+}
+
+// Actions returns the actions recorded so far.
+func (f *Executor) Actions() []Action {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Action(nil), f.actions...)
+}
+
+// ActionsFor returns the recorded actions with the verb for the Machine namespace/name.
+func (f *Executor) ActionsFor(verb, machine string) []Action {
+	var out []Action
+	for _, a := range f.Actions() {
+		if a.Verb == verb && a.Machine == machine {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Machine returns a copy of the fake machine of the Machine namespace/name.
+func (f *Executor) Machine(key string) (Machine, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.machines[key]
+	if !ok {
+		return Machine{}, false
+	}
+	out := *m
+	out.Files = make(map[string][]byte, len(m.Files))
+	for k, v := range m.Files {
+		out.Files[k] = v
+	}
+	return out, true
+}
+
+// SetMachine adds or replaces the fake machine of the Machine namespace/name,
+// e.g. to simulate a machine stopped out of band.
+func (f *Executor) SetMachine(key string, m Machine) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m.Files == nil {
+		m.Files = map[string][]byte{}
+	}
+	f.machines[key] = &m
+}
+
+// SetFile writes a file on the fake machine of the Machine namespace/name.
+func (f *Executor) SetFile(key, path string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.machines[key]
+	if !ok {
+		return notFound(key)
+	}
+	m.Files[path] = data
+	return nil
+}
+
+// Fail returns a reaction that fails the actions with err.
+func Fail(err error) ReactionFunc {
+	return func(context.Context, Action) (bool, []byte, error) {
+		return true, nil, err
+	}
+}
+
+// ExitWith returns a reaction that fails the actions like docker-machine exiting with code and stderr.
+func ExitWith(code int, stderr string) ReactionFunc {
+	return func(_ context.Context, a Action) (bool, []byte, error) {
+		return true, nil, &executor.ExitError{Command: a.Verb, ExitCode: code, Stderr: stderr}
+	}
+}
+
+// Block returns a reaction that blocks the actions until their context is done,
+// e.g. to simulate a timeout.
+func Block() ReactionFunc {
+	return func(ctx context.Context, _ Action) (bool, []byte, error) {
+		<-ctx.Done()
+		return true, nil, ctx.Err()
+	}
+}
+
+// ForMachine limits fn to the actions for the Machine namespace/name.
+func ForMachine(machine string, fn ReactionFunc) ReactionFunc {
+	return func(ctx context.Context, a Action) (bool, []byte, error) {
+		if a.Machine != machine {
+			return false, nil, nil
+		}
+		return fn(ctx, a)
+	}
+}
+
+// Once limits fn to the first action it handles.
+func Once(fn ReactionFunc) ReactionFunc {
+	var mu sync.Mutex
+	done := false
+	return func(ctx context.Context, a Action) (bool, []byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return false, nil, nil
+		}
+		handled, out, err := fn(ctx, a)
+		done = handled
+		return handled, out, err
+	}
+}
+
+func (f *Executor) Create(ctx context.Context, machine *api.Machine, opts executor.CreateOptions) error {
+	_, err := f.do(ctx, Action{Verb: VerbCreate, Machine: key(machine), Args: opts.Args, Options: opts.Options, Create: &opts}, func(k string) ([]byte, error) {
+		if _, ok := f.machines[k]; ok {
+			return nil, fmt.Errorf("%w: host already exists: %q", executor.ErrMachineExists, executor.MachineName(machine))
+		}
+		f.nextIP++
+		f.machines[k] = &Machine{
+			Driver: opts.Driver,
+			State:  StateRunning,
+			IP:     fmt.Sprintf("10.0.0.%d", (f.nextIP-1)%254+1),
+			Files:  map[string][]byte{},
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (f *Executor) Remove(ctx context.Context, machine *api.Machine, opts executor.Options) error {
+	_, err := f.do(ctx, Action{Verb: VerbRemove, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		if _, ok := f.machines[k]; !ok {
+			return nil, notFound(k)
+		}
+		delete(f.machines, k)
+		return nil, nil
+	})
+	return err
+}
+
+func (f *Executor) SSH(ctx context.Context, machine *api.Machine, opts executor.Options, command ...string) ([]byte, error) {
+	return f.do(ctx, Action{Verb: VerbSSH, Machine: key(machine), Args: command, Options: opts}, func(k string) ([]byte, error) {
+		m, err := f.running(VerbSSH, k)
+		if err != nil {
+			return nil, err
+		}
+		if len(command) == 2 && command[0] == "cat" {
+			data, ok := m.Files[command[1]]
+			if !ok {
+				return nil, &executor.ExitError{Command: VerbSSH, ExitCode: 1, Stderr: fmt.Sprintf("cat: %s: No such file or directory", command[1])}
+			}
+			return data, nil
+		}
+		return nil, nil
+	})
+}
+
+func (f *Executor) SCP(ctx context.Context, machine *api.Machine, opts executor.Options, src, dst string) error {
+	_, err := f.do(ctx, Action{Verb: VerbSCP, Machine: key(machine), Args: []string{src, dst}, Options: opts}, func(k string) ([]byte, error) {
+		m, err := f.running(VerbSCP, k)
+		if err != nil {
+			return nil, err
+		}
+		prefix := executor.MachineName(machine) + ":"
+		switch {
+		case strings.HasPrefix(dst, prefix):
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return nil, err
+			}
+			m.Files[path.Clean(strings.TrimPrefix(dst, prefix))] = data
+		case strings.HasPrefix(src, prefix):
+			data, ok := m.Files[path.Clean(strings.TrimPrefix(src, prefix))]
+			if !ok {
+				return nil, &executor.ExitError{Command: VerbSCP, ExitCode: 1, Stderr: fmt.Sprintf("scp: %s: No such file or directory", src)}
+			}
+			return nil, os.WriteFile(dst, data, 0o600)
+		default:
+			return nil, fmt.Errorf("neither %s nor %s is on machine %s", src, dst, executor.MachineName(machine))
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func (f *Executor) Inspect(ctx context.Context, machine *api.Machine, opts executor.Options) ([]byte, error) {
+	return f.do(ctx, Action{Verb: VerbInspect, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		m, ok := f.machines[k]
+		if !ok {
+			return nil, notFound(k)
+		}
+		return json.Marshal(map[string]interface{}{
+			"DriverName": m.Driver,
+			"Driver": map[string]interface{}{
+				"MachineName": executor.MachineName(machine),
+				"IPAddress":   m.IP,
+				"SSHUser":     "docker",
+				"SSHPort":     22,
+			},
+		})
+	})
+}
+
+func (f *Executor) URL(ctx context.Context, machine *api.Machine, opts executor.Options) (string, error) {
+	out, err := f.do(ctx, Action{Verb: VerbURL, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		m, err := f.running(VerbURL, k)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("tcp://%s:2376", m.IP)), nil
+	})
+	return strings.TrimSpace(string(out)), err
+}
+
+func (f *Executor) IP(ctx context.Context, machine *api.Machine, opts executor.Options) (string, error) {
+	out, err := f.do(ctx, Action{Verb: VerbIP, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		m, err := f.running(VerbIP, k)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(m.IP), nil
+	})
+	return strings.TrimSpace(string(out)), err
+}
+
+func (f *Executor) Status(ctx context.Context, machine *api.Machine, opts executor.Options) (string, error) {
+	out, err := f.do(ctx, Action{Verb: VerbStatus, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		m, ok := f.machines[k]
+		if !ok {
+			return nil, notFound(k)
+		}
+		return []byte(m.State), nil
+	})
+	return strings.TrimSpace(string(out)), err
+}
+
+func (f *Executor) Start(ctx context.Context, machine *api.Machine, opts executor.Options) error {
+	return f.setState(ctx, VerbStart, machine, opts, StateRunning)
+}
+
+func (f *Executor) Stop(ctx context.Context, machine *api.Machine, opts executor.Options) error {
+	return f.setState(ctx, VerbStop, machine, opts, StateStopped)
+}
+
+func (f *Executor) Restart(ctx context.Context, machine *api.Machine, opts executor.Options) error {
+	return f.setState(ctx, VerbRestart, machine, opts, StateRunning)
+}
+
+func (f *Executor) setState(ctx context.Context, verb string, machine *api.Machine, opts executor.Options, state string) error {
+	_, err := f.do(ctx, Action{Verb: verb, Machine: key(machine), Options: opts}, func(k string) ([]byte, error) {
+		m, ok := f.machines[k]
+		if !ok {
+			return nil, notFound(k)
+		}
+		m.State = state
+		return nil, nil
+	})
+	return err
+}
+
+// do records the action and runs the first reaction that handles it, or the
+// default behavior with the lock held.
+func (f *Executor) do(ctx context.Context, a Action, fallback func(key string) ([]byte, error)) ([]byte, error) {
+	f.mu.Lock()
+	f.actions = append(f.actions, a)
+	reactions := append([]reaction(nil), f.reactions...)
+	f.mu.Unlock()
+
+	for _, r := range reactions {
+		if r.verb != "*" && r.verb != a.Verb {
+			continue
+		}
+		if handled, out, err := r.fn(ctx, a); handled {
+			return out, err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fallback(a.Machine)
+}
+
+// running returns the machine if it is running. It must be called with the lock held.
+func (f *Executor) running(verb, key string) (*Machine, error) {
+	m, ok := f.machines[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	if m.State != StateRunning {
+		return nil, &executor.ExitError{Command: verb, ExitCode: 1, Stderr: fmt.Sprintf("%s is not running", key)}
+	}
+	return m, nil
+}
+
+func key(machine *api.Machine) string {
+	return path.Join(machine.Namespace, machine.Name)
+}
+
+func notFound(key string) error {
+	return fmt.Errorf("%w: host does not exist: %q", executor.ErrMachineNotFound, key)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/executor"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExecutorLifecycle(t *testing.T) {
+	f := NewExecutor()
+	machine := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "vm"}}
+	ctx := context.Background()
+	opts := executor.Options{}
+
+	if err := f.Create(ctx, machine, executor.CreateOptions{Driver: "google"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Create(ctx, machine, executor.CreateOptions{Driver: "google"}); !errors.Is(err, executor.ErrMachineExists) {
+		t.Errorf("second Create() = %v, want ErrMachineExists", err)
+	}
+	if state, err := f.Status(ctx, machine, opts); err != nil || state != StateRunning {
+		t.Errorf("Status() = %q, %v", state, err)
+	}
+	if u, err := f.URL(ctx, machine, opts); err != nil || u != "tcp://10.0.0.1:2376" {
+		t.Errorf("URL() = %q, %v", u, err)
+	}
+
+	if _, err := f.SSH(ctx, machine, opts, "cat", "/tmp/result.txt"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if err := f.SetFile("demo/vm", "/tmp/result.txt", []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := f.SSH(ctx, machine, opts, "cat", "/tmp/result.txt"); err != nil || string(out) != "0" {
+		t.Errorf("SSH() = %q, %v", out, err)
+	}
+
+	if err := f.Stop(ctx, machine, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.URL(ctx, machine, opts); err == nil {
+		t.Error("expected an error for a stopped machine")
+	}
+	if err := f.Restart(ctx, machine, opts); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := f.Status(ctx, machine, opts); err != nil || state != StateRunning {
+		t.Errorf("Status() after Restart() = %q, %v", state, err)
+	}
+
+	if err := f.Remove(ctx, machine, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove(ctx, machine, opts); !errors.Is(err, executor.ErrMachineNotFound) {
+		t.Errorf("second Remove() = %v, want ErrMachineNotFound", err)
+	}
+	if n := len(f.ActionsFor(VerbRemove, "demo/vm")); n != 2 {
+		t.Errorf("recorded %d rm actions, want 2", n)
+	}
+}
+
+func TestExecutorReactions(t *testing.T) {
+	f := NewExecutor()
+	machine := &api.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "demo", Name: "vm"}}
+	boom := errors.New("boom")
+	f.PrependReaction(VerbCreate, Once(Fail(boom)))
+
+	if err := f.Create(context.Background(), machine, executor.CreateOptions{}); !errors.Is(err, boom) {
+		t.Errorf("first Create() = %v, want %v", err, boom)
+	}
+	if _, ok := f.Machine("demo/vm"); ok {
+		t.Error("failed create added the machine")
+	}
+	if err := f.Create(context.Background(), machine, executor.CreateOptions{}); err != nil {
+		t.Errorf("second Create() = %v", err)
+	}
+
+	f.PrependReaction("*", Block())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Status(ctx, machine, executor.Options{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Status() = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,568 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	LabelMachine     = "docker-machine.klusters.dev/machine"
+	LabelCommand     = "docker-machine.klusters.dev/command"
+	AnnotationArgSum = "docker-machine.klusters.dev/command-hash"
+
+	jobContainerName = "docker-machine"
+	storeMountPath   = "/var/run/docker-machine-operator/store"
+	filesMountPath   = "/var/run/docker-machine-operator/files"
+	storageEnv       = "MACHINE_STORAGE_PATH"
+	machineNameEnv   = "MACHINE_NAME"
+	storeSecretEnv   = "STORE_SECRET"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	sectionStdout     = "@@stdout"
+	sectionStderr     = "@@stderr"
+	sectionStoreSaved = "@@store-saved"
+	sectionExit       = "@@exit"
+
+	defaultPollInterval = 2 * time.Second
+	jobTTL              = int32(3600)
+	// maxJobDeadline bounds the pods of commands without a deadline, in seconds
+	maxJobDeadline = int64(3600)
+)
+
+// jobScript runs docker-machine with the store of the Machine and prints the
+// output as base64 encoded sections, so that the operator can read it from the
+// pod logs. The resulting store holds the keys and the driver credentials of
+// the machine, so it is never printed: the Job writes it to the store Secret
+// with its ServiceAccount, see ensureStoreAccess.
+const jobScript = `store="$MACHINE_STORAGE_PATH/machines/$MACHINE_NAME"
+mkdir -p "$store"
+if [ -d ` + storeMountPath + ` ]; then
+  cp -L ` + storeMountPath + `/* "$store"/ 2>/dev/null
+  chmod 600 "$store"/* 2>/dev/null
+fi
+docker-machine "$@" >/tmp/stdout 2>/tmp/stderr
+rc=$?
+saved=""
+if [ -n "$` + storeSecretEnv + `" ]; then
+  data=""
+  for f in "$store"/*; do
+    name=$(basename "$f")
+    case "$name" in *[!A-Za-z0-9._-]*) continue ;; esac
+    [ -f "$f" ] || continue
+    data="$data${data:+,}\"$name\":\"$(base64 -w0 "$f")\""
+  done
+  sa=` + serviceAccountDir + `
+  if printf '[{"op":"add","path":"/data","value":{%s}}]' "$data" | curl -sSf -o /dev/null -X PATCH \
+    --cacert "$sa/ca.crt" -H "Authorization: Bearer $(cat "$sa/token")" \
+    -H "Content-Type: application/json-patch+json" --data-binary @- \
+    "https://kubernetes.default.svc/api/v1/namespaces/$(cat "$sa/namespace")/secrets/$` + storeSecretEnv + `" 2>>/tmp/stderr; then
+    saved=1
+  fi
+fi
+echo ` + sectionStdout + `
+base64 /tmp/stdout
+echo ` + sectionStderr + `
+base64 /tmp/stderr
+if [ -n "$saved" ]; then echo ` + sectionStoreSaved + `; fi
+echo "` + sectionExit + ` $rc"
+exit $rc
+`
+
+// JobExecutor runs every docker-machine command in a Kubernetes Job in the
+// namespace of the Machine. The pod mounts the store Secret of the Machine and
+// the Secrets referenced by the command. The output is read back from the pod
+// logs. The Job writes the resulting docker-machine store to the store Secret,
+// which is then copied to StoragePath, so that the operator sees the same
+// store as the Job.
+type JobExecutor struct {
+	// KubeClient is used without a cache, so that Jobs are seen right after they are created.
+	KubeClient kubernetes.Interface
+	Scheme     *runtime.Scheme
+	// Image must contain docker-machine, curl and the drivers used by the Machines.
+	Image string
+	// StoragePath is the docker-machine store of the operator. Jobs use the same
+	// path, as docker-machine records absolute paths in the store.
+	StoragePath string
+	// PollInterval is how often the Job status is checked.
+	PollInterval time.Duration
+	// Resources are the resource requests and limits of the Job pods.
+	Resources core.ResourceRequirements
+}
+
+var _ CommandExecutor = &JobExecutor{}
+
+func (e *JobExecutor) Execute(ctx context.Context, machine *api.Machine, cmd Command) (*Result, error) {
+	job, err := e.newJob(machine, cmd, activeDeadlineSeconds(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if cmd.StoreSecret != "" {
+		if err := e.ensureStoreAccess(ctx, machine, cmd.StoreSecret); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.ensureJob(ctx, machine, job); err != nil {
+		return nil, err
+	}
+
+	finished, err := e.waitForJob(ctx, job)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the command was canceled or timed out
+			_ = e.deleteJob(context.Background(), job)
+		}
+		return nil, err
+	}
+
+	logs, err := e.podLogs(ctx, finished)
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseJobOutput(logs)
+	if err != nil {
+		return nil, fmt.Errorf("job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	if cmd.StoreSecret != "" {
+		if !out.storeSaved {
+			return nil, fmt.Errorf("job %s/%s did not save the docker-machine store to secret %s", job.Namespace, job.Name, cmd.StoreSecret)
+		}
+		secret, err := e.KubeClient.CoreV1().Secrets(machine.Namespace).Get(ctx, cmd.StoreSecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if err := syncStore(filepath.Join(e.StoragePath, "machines", MachineName(machine)), secret.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.deleteJob(ctx, finished); err != nil {
+		return nil, err
+	}
+
+	res := &Result{Stdout: out.stdout, Stderr: out.stderr, ExitCode: out.exitCode}
+	if res.ExitCode != 0 {
+		return res, &ExitError{Command: cmd.Name, ExitCode: res.ExitCode, Stderr: string(res.Stderr)}
+	}
+	return res, nil
+}
+
+// ensureJob creates the Job. A running Job of the same command, e.g. started
+// before an operator restart, is followed instead. Other Jobs of the same name
+// are left over and replaced.
+func (e *JobExecutor) ensureJob(ctx context.Context, machine *api.Machine, job *batch.Job) error {
+	existing, err := e.KubeClient.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+	switch {
+	case kerr.IsNotFound(err):
+	case err != nil:
+		return err
+	case existing.DeletionTimestamp == nil && !jobFinished(existing) &&
+		existing.Annotations[AnnotationArgSum] == job.Annotations[AnnotationArgSum]:
+		*job = *existing
+		return nil
+	default:
+		if err := e.deleteJob(ctx, existing); err != nil {
+			return err
+		}
+		if err := e.waitForDeletion(ctx, existing); err != nil {
+			return err
+		}
+	}
+
+	if err := controllerutil.SetControllerReference(machine, job, e.Scheme); err != nil {
+		return err
+	}
+	created, err := e.KubeClient.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	*job = *created
+	return nil
+}
+
+// ensureStoreAccess creates the store Secret of the Machine, if missing, and a
+// ServiceAccount of the same name that may only read and patch that Secret.
+// The objects are owned by the Machine.
+func (e *JobExecutor) ensureStoreAccess(ctx context.Context, machine *api.Machine, name string) error {
+	meta := func() metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      name,
+			Namespace: machine.Namespace,
+			Labels:    map[string]string{LabelMachine: machine.Name},
+		}
+	}
+	secret := &core.Secret{ObjectMeta: meta(), Type: core.SecretTypeOpaque}
+	sa := &core.ServiceAccount{ObjectMeta: meta(), AutomountServiceAccountToken: ptr.To(false)}
+	role := &rbac.Role{
+		ObjectMeta: meta(),
+		Rules: []rbac.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "patch"},
+		}},
+	}
+	binding := &rbac.RoleBinding{
+		ObjectMeta: meta(),
+		RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "Role", Name: name},
+		Subjects:   []rbac.Subject{{Kind: rbac.ServiceAccountKind, Name: name, Namespace: machine.Namespace}},
+	}
+	for _, obj := range []metav1.Object{secret, sa, role, binding} {
+		if err := controllerutil.SetControllerReference(machine, obj, e.Scheme); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if _, err = e.KubeClient.CoreV1().Secrets(machine.Namespace).Create(ctx, secret, metav1.CreateOptions{}); ignoreAlreadyExists(err) != nil {
+		return err
+	}
+	if _, err = e.KubeClient.CoreV1().ServiceAccounts(machine.Namespace).Create(ctx, sa, metav1.CreateOptions{}); ignoreAlreadyExists(err) != nil {
+		return err
+	}
+	if _, err = e.KubeClient.RbacV1().Roles(machine.Namespace).Create(ctx, role, metav1.CreateOptions{}); ignoreAlreadyExists(err) != nil {
+		return err
+	}
+	if _, err = e.KubeClient.RbacV1().RoleBindings(machine.Namespace).Create(ctx, binding, metav1.CreateOptions{}); ignoreAlreadyExists(err) != nil {
+		return err
+	}
+	return nil
+}
+
+func ignoreAlreadyExists(err error) error {
+	if kerr.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *JobExecutor) waitForJob(ctx context.Context, job *batch.Job) (*batch.Job, error) {
+	ticker := time.NewTicker(e.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		cur, err := e.KubeClient.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if jobFinished(cur) {
+			return cur, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *JobExecutor) waitForDeletion(ctx context.Context, job *batch.Job) error {
+	ticker := time.NewTicker(e.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		_, err := e.KubeClient.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *JobExecutor) pollInterval() time.Duration {
+	if e.PollInterval == 0 {
+		return defaultPollInterval
+	}
+	return e.PollInterval
+}
+
+func (e *JobExecutor) podLogs(ctx context.Context, job *batch.Job) ([]byte, error) {
+	pods, err := e.KubeClient.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job.Name})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no pod found for job %s/%s", job.Namespace, job.Name)
+	}
+	return e.KubeClient.CoreV1().Pods(job.Namespace).
+		GetLogs(pods.Items[0].Name, &core.PodLogOptions{Container: jobContainerName}).
+		DoRaw(ctx)
+}
+
+func (e *JobExecutor) deleteJob(ctx context.Context, job *batch.Job) error {
+	err := e.KubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+	})
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func jobFinished(job *batch.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batch.JobComplete || c.Type == batch.JobFailed) && c.Status == core.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobName returns a stable name per Machine and command, so that an in-flight
+// Job can be found again.
+func jobName(machine, command string) string {
+	name := machine + "-" + command
+	if len(name) <= 63 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:54] + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+func commandHash(cmd Command) string {
+	h := sha256.New()
+	for _, arg := range cmd.Args {
+		_, _ = h.Write([]byte(arg))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// activeDeadlineSeconds returns how long the pod of a command may run, the
+// time left until the deadline of ctx. The pod is stopped along with the
+// command, e.g. when an operation times out.
+func activeDeadlineSeconds(ctx context.Context) int64 {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return maxJobDeadline
+	}
+	return max(int64(math.Ceil(time.Until(deadline).Seconds())), 1)
+}
+
+func (e *JobExecutor) newJob(machine *api.Machine, cmd Command, activeDeadline int64) (*batch.Job, error) {
+	container := core.Container{
+		Name:      jobContainerName,
+		Image:     e.Image,
+		Command:   []string{"/bin/sh", "-c", jobScript, "docker-machine"},
+		Args:      cmd.Args,
+		Resources: *e.Resources.DeepCopy(),
+		Env: []core.EnvVar{
+			{Name: storageEnv, Value: e.StoragePath},
+			{Name: machineNameEnv, Value: MachineName(machine)},
+		},
+		VolumeMounts: []core.VolumeMount{
+			{Name: "storage", MountPath: e.StoragePath},
+		},
+		SecurityContext: &core.SecurityContext{
+			AllowPrivilegeEscalation: ptr.To(false),
+		},
+	}
+	volumes := []core.Volume{
+		{Name: "storage", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{}}},
+	}
+
+	for _, v := range cmd.Env {
+		env := core.EnvVar{Name: v.Name, Value: v.Value}
+		if ref := v.SecretRef; ref != nil {
+			if ref.Namespace != machine.Namespace {
+				return nil, fmt.Errorf("secret %s/%s of %s must be in the namespace of the machine", ref.Namespace, ref.Name, v.Name)
+			}
+			env.ValueFrom = &core.EnvVarSource{SecretKeyRef: &core.SecretKeySelector{
+				LocalObjectReference: core.LocalObjectReference{Name: ref.Name},
+				Key:                  ref.Key,
+			}}
+		}
+		container.Env = append(container.Env, env)
+	}
+
+	for i, f := range cmd.SecretFiles {
+		ref := f.SecretRef
+		if ref.Namespace != machine.Namespace {
+			return nil, fmt.Errorf("secret %s/%s of %s must be in the namespace of the machine", ref.Namespace, ref.Name, f.Env)
+		}
+		name := fmt.Sprintf("file-%d", i)
+		dir := path.Join(filesMountPath, name)
+		fileName := path.Base(ref.Key)
+		volumes = append(volumes, core.Volume{
+			Name: name,
+			VolumeSource: core.VolumeSource{Secret: &core.SecretVolumeSource{
+				SecretName:  ref.Name,
+				Items:       []core.KeyToPath{{Key: ref.Key, Path: fileName}},
+				DefaultMode: ptr.To(int32(0o400)),
+			}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, core.VolumeMount{Name: name, MountPath: dir, ReadOnly: true})
+		container.Env = append(container.Env, core.EnvVar{Name: f.Env, Value: path.Join(dir, fileName)})
+	}
+
+	var serviceAccount string
+	if cmd.StoreSecret != "" {
+		// the ServiceAccount of ensureStoreAccess, its token is only used to save the store
+		serviceAccount = cmd.StoreSecret
+		container.Env = append(container.Env, core.EnvVar{Name: storeSecretEnv, Value: cmd.StoreSecret})
+		volumes = append(volumes, core.Volume{
+			Name: "store",
+			VolumeSource: core.VolumeSource{Secret: &core.SecretVolumeSource{
+				SecretName:  cmd.StoreSecret,
+				DefaultMode: ptr.To(int32(0o400)),
+				Optional:    ptr.To(true),
+			}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, core.VolumeMount{Name: "store", MountPath: storeMountPath, ReadOnly: true})
+	}
+
+	labels := map[string]string{
+		LabelMachine: machine.Name,
+		LabelCommand: cmd.Name,
+	}
+	return &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        jobName(machine.Name, cmd.Name),
+			Namespace:   machine.Namespace,
+			Labels:      labels,
+			Annotations: map[string]string{AnnotationArgSum: commandHash(cmd)},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit:            ptr.To(int32(0)),
+			TTLSecondsAfterFinished: ptr.To(jobTTL),
+			ActiveDeadlineSeconds:   ptr.To(activeDeadline),
+			Template: core.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: core.PodSpec{
+					RestartPolicy:                core.RestartPolicyNever,
+					ServiceAccountName:           serviceAccount,
+					AutomountServiceAccountToken: ptr.To(serviceAccount != ""),
+					Containers:                   []core.Container{container},
+					Volumes:                      volumes,
+				},
+			},
+		},
+	}, nil
+}
+
+type jobOutput struct {
+	stdout     []byte
+	stderr     []byte
+	storeSaved bool
+	exitCode   int
+}
+
+// parseJobOutput reads the sections printed by jobScript.
+func parseJobOutput(logs []byte) (*jobOutput, error) {
+	sections := map[string]*strings.Builder{}
+	var cur *strings.Builder
+	exitCode := -1
+	storeSaved := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(logs))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == sectionStdout || line == sectionStderr:
+			cur = &strings.Builder{}
+			sections[line] = cur
+		case line == sectionStoreSaved:
+			storeSaved = true
+			cur = nil
+		case strings.HasPrefix(line, sectionExit+" "):
+			code, err := strconv.Atoi(strings.TrimPrefix(line, sectionExit+" "))
+			if err != nil {
+				return nil, fmt.Errorf("invalid exit code: %w", err)
+			}
+			exitCode = code
+			cur = nil
+		case cur != nil:
+			cur.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if exitCode < 0 {
+		return nil, errors.New("command did not finish")
+	}
+
+	out := &jobOutput{storeSaved: storeSaved, exitCode: exitCode}
+	var err error
+	if out.stdout, err = decodeSection(sections[sectionStdout]); err != nil {
+		return nil, err
+	}
+	if out.stderr, err = decodeSection(sections[sectionStderr]); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decodeSection(b *strings.Builder) ([]byte, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(b.String())
+}
+
+// syncStore makes the local store directory match the store of the Job.
+func syncStore(dir string, store map[string][]byte) error {
+	if len(store) == 0 {
+		return os.RemoveAll(dir)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for name, data := range store {
+		if name != filepath.Base(name) {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
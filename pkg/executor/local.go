@@ -0,0 +1,143 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LocalExecutor runs docker-machine as a child process of the operator.
+type LocalExecutor struct {
+	// Client reads the Secrets referenced by commands.
+	Client client.Reader
+	// Binary is the docker-machine binary, looked up in PATH if empty.
+	Binary string
+}
+
+var _ CommandExecutor = &LocalExecutor{}
+
+func (e *LocalExecutor) Execute(ctx context.Context, _ *api.Machine, cmd Command) (*Result, error) {
+	vars := map[string]string{}
+	env := os.Environ()
+	for _, v := range cmd.Env {
+		value := v.Value
+		if v.SecretRef != nil {
+			data, err := e.secretValue(ctx, *v.SecretRef)
+			if err != nil {
+				return nil, err
+			}
+			value = string(data)
+		}
+		vars[v.Name] = value
+		env = append(env, v.Name+"="+value)
+	}
+
+	if len(cmd.SecretFiles) > 0 {
+		dir, err := e.secretDir(cmd.WorkDir)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir) // nolint:errcheck
+
+		for i, f := range cmd.SecretFiles {
+			data, err := e.secretValue(ctx, f.SecretRef)
+			if err != nil {
+				return nil, err
+			}
+			path := filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(f.SecretRef.Key)))
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				return nil, err
+			}
+			vars[f.Env] = path
+			env = append(env, f.Env+"="+path)
+		}
+	}
+
+	if cmd.DriverDir != "" {
+		env = prependPath(env, cmd.DriverDir)
+	}
+
+	binary := e.Binary
+	if binary == "" {
+		binary = "docker-machine"
+	}
+	c := exec.CommandContext(ctx, binary, expandArgs(cmd.Args, vars)...)
+	c.Env = env
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	err := c.Run()
+	res := &Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		res.ExitCode = exitErr.ExitCode()
+		return res, &ExitError{Command: cmd.Name, ExitCode: res.ExitCode, Stderr: stderr.String()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (e *LocalExecutor) secretValue(ctx context.Context, ref SecretKeyRef) ([]byte, error) {
+	var secret core.Secret
+	if err := e.Client.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, &secret); err != nil {
+		return nil, err
+	}
+	data, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+	return data, nil
+}
+
+// prependPath returns env with dir in front of its PATH.
+func prependPath(env []string, dir string) []string {
+	for i, v := range env {
+		if path, ok := strings.CutPrefix(v, "PATH="); ok {
+			if path != "" {
+				dir += string(os.PathListSeparator) + path
+			}
+			env[i] = "PATH=" + dir
+			return env
+		}
+	}
+	return append(env, "PATH="+dir)
+}
+
+// secretDir creates a directory only readable by the operator for the secret files of a command.
+func (e *LocalExecutor) secretDir(workDir string) (string, error) {
+	if workDir != "" {
+		if err := os.MkdirAll(workDir, 0o700); err != nil {
+			return "", err
+		}
+	}
+	return os.MkdirTemp(workDir, ".secrets-")
+}
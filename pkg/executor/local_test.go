@@ -0,0 +1,164 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// secretReader serves Secrets from memory.
+type secretReader map[client.ObjectKey]*core.Secret
+
+func (r secretReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	s, ok := r[key]
+	if !ok {
+		return kerr.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	s.DeepCopyInto(obj.(*core.Secret))
+	return nil
+}
+
+func (r secretReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return errors.New("not implemented")
+}
+
+// fakeDockerMachine writes a docker-machine stand-in that prints its arguments,
+// the credential and the content and permissions of the startup script file.
+func fakeDockerMachine(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "docker-machine")
+	script := `#!/bin/sh
+if [ "$1" = "fail" ]; then echo "boom" >&2; exit 3; fi
+echo "args: $*"
+echo "key: $AWS_SECRET_ACCESS_KEY"
+echo "script: $(cat "$STARTUP_SCRIPT")"
+echo "mode: $(stat -c %a "$STARTUP_SCRIPT")"
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLocalExecutor(t *testing.T) {
+	workDir := filepath.Join(t.TempDir(), "vm")
+	e := &LocalExecutor{
+		Binary: fakeDockerMachine(t),
+		Client: secretReader{
+			{Namespace: "demo", Name: "cred"}:   {Data: map[string][]byte{"amazonec2-secret-key": []byte("s3cr3t")}},
+			{Namespace: "demo", Name: "script"}: {Data: map[string][]byte{"userdata": []byte("echo hello")}},
+		},
+	}
+	cmd := Command{
+		Name: "create",
+		Args: []string{"create", "--amazonec2-userdata", "$(STARTUP_SCRIPT)", "vm"},
+		Env: []EnvVar{
+			{Name: "AWS_SECRET_ACCESS_KEY", SecretRef: &SecretKeyRef{Namespace: "demo", Name: "cred", Key: "amazonec2-secret-key"}},
+		},
+		SecretFiles: []SecretFile{
+			{Env: "STARTUP_SCRIPT", SecretRef: SecretKeyRef{Namespace: "demo", Name: "script", Key: "userdata"}},
+		},
+		WorkDir: workDir,
+	}
+
+	res, err := e.Execute(context.Background(), &api.Machine{}, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(res.Stdout)
+	for _, want := range []string{"key: s3cr3t", "script: echo hello", "mode: 600"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+	if strings.Contains(out, "args: create --amazonec2-userdata $(STARTUP_SCRIPT)") || strings.Contains(out, "s3cr3t vm") {
+		t.Errorf("unexpected arguments in %q", out)
+	}
+
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("secret files were not removed: %v", entries)
+	}
+}
+
+func TestLocalExecutorExitError(t *testing.T) {
+	e := &LocalExecutor{Binary: fakeDockerMachine(t), Client: secretReader{}}
+
+	res, err := e.Execute(context.Background(), &api.Machine{}, Command{Name: "fail", Args: []string{"fail"}})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected an ExitError, got %v", err)
+	}
+	if exitErr.ExitCode != 3 || res.ExitCode != 3 || strings.TrimSpace(string(res.Stderr)) != "boom" {
+		t.Errorf("unexpected result %+v, error %v", res, exitErr)
+	}
+}
+
+func TestLocalExecutorMissingSecret(t *testing.T) {
+	e := &LocalExecutor{Binary: fakeDockerMachine(t), Client: secretReader{}}
+	cmd := Command{
+		Name: "create",
+		Args: []string{"create"},
+		Env:  []EnvVar{{Name: "AWS_SECRET_ACCESS_KEY", SecretRef: &SecretKeyRef{Namespace: "demo", Name: "cred", Key: "key"}}},
+	}
+	if _, err := e.Execute(context.Background(), &api.Machine{}, cmd); !kerr.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestLocalExecutorDriverDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker-machine")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho \"path: $PATH\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "/usr/bin")
+	e := &LocalExecutor{Binary: path, Client: secretReader{}}
+
+	res, err := e.Execute(context.Background(), &api.Machine{}, Command{Name: "ls", Args: []string{"ls"}, DriverDir: "/drivers/demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(res.Stdout)), "path: /drivers/demo:/usr/bin"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if got := os.Getenv("PATH"); got != "/usr/bin" {
+		t.Errorf("PATH of the operator changed to %s", got)
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	got := expandArgs([]string{"--file", "$(FILE)", "$(UNKNOWN)", "x$(FILE)y"}, map[string]string{"FILE": "/tmp/f"})
+	want := []string{"--file", "/tmp/f", "$(UNKNOWN)", "x/tmp/fy"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expandArgs() = %v, want %v", got, want)
+	}
+}
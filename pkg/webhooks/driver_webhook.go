@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/validate-docker-machine-klusters-dev-v1alpha1-driver,mutating=false,failurePolicy=fail,sideEffects=None,groups=docker-machine.klusters.dev,resources=drivers,verbs=create;update,versions=v1alpha1,name=vdriver.docker-machine.klusters.dev,admissionReviewVersions=v1
+
+var checksumPattern = regexp.MustCompile(`^(sha256:)?[0-9a-fA-F]{64}$`)
+
+// DriverWebhook validates Drivers.
+type DriverWebhook struct{}
+
+var _ admission.CustomValidator = &DriverWebhook{}
+
+// SetupWithManager registers the validating webhook of Drivers.
+func (w *DriverWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&api.Driver{}).
+		WithValidator(w).
+		Complete()
+}
+
+func (w *DriverWebhook) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	driver, ok := obj.(*api.Driver)
+	if !ok {
+		return nil, fmt.Errorf("expected a Driver, got %T", obj)
+	}
+	return validateDriver(driver)
+}
+
+func (w *DriverWebhook) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	driver, ok := newObj.(*api.Driver)
+	if !ok {
+		return nil, fmt.Errorf("expected a Driver, got %T", newObj)
+	}
+	if !driver.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+	return validateDriver(driver)
+}
+
+func (w *DriverWebhook) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateDriver(driver *api.Driver) (admission.Warnings, error) {
+	var errs field.ErrorList
+	var warnings admission.Warnings
+	specPath := field.NewPath("spec")
+
+	if driver.Spec.Builtin {
+		if driver.Spec.DownloadURL != "" || driver.Spec.Checksum != "" {
+			warnings = append(warnings, "downloadURL and checksum are ignored for builtin drivers")
+		}
+		return warnings, nil
+	}
+
+	urlPath := specPath.Child("downloadURL")
+	if driver.Spec.DownloadURL == "" {
+		errs = append(errs, field.Required(urlPath, "downloadURL is required for non-builtin drivers"))
+	} else if u, err := url.Parse(driver.Spec.DownloadURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, field.Invalid(urlPath, driver.Spec.DownloadURL, "must be an http or https URL"))
+	}
+
+	checksumPath := specPath.Child("checksum")
+	if driver.Spec.Checksum == "" {
+		errs = append(errs, field.Required(checksumPath, "checksum is required for non-builtin drivers"))
+	} else if !checksumPattern.MatchString(driver.Spec.Checksum) {
+		errs = append(errs, field.Invalid(checksumPath, driver.Spec.Checksum, "must be a hex encoded sha256 digest, optionally prefixed with sha256:"))
+	}
+
+	if len(errs) > 0 {
+		return warnings, kerr.NewInvalid(api.GroupVersion.WithKind("Driver").GroupKind(), driver.Name, errs)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,303 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/controller"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+//+kubebuilder:webhook:path=/mutate-docker-machine-klusters-dev-v1alpha1-machine,mutating=true,failurePolicy=fail,sideEffects=None,groups=docker-machine.klusters.dev,resources=machines,verbs=create;update,versions=v1alpha1,name=mmachine.docker-machine.klusters.dev,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-docker-machine-klusters-dev-v1alpha1-machine,mutating=false,failurePolicy=fail,sideEffects=None,groups=docker-machine.klusters.dev,resources=machines,verbs=create;update,versions=v1alpha1,name=vmachine.docker-machine.klusters.dev,admissionReviewVersions=v1
+
+// MachineWebhook defaults and validates Machines.
+type MachineWebhook struct {
+	// Client reads the auth Secrets and Drivers of Machines.
+	Client client.Reader
+}
+
+var (
+	_ admission.CustomDefaulter = &MachineWebhook{}
+	_ admission.CustomValidator = &MachineWebhook{}
+)
+
+// SetupWithManager registers the defaulting and validating webhooks of Machines.
+func (w *MachineWebhook) SetupWithManager(mgr ctrl.Manager) error {
+	if w.Client == nil {
+		w.Client = mgr.GetAPIReader()
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&api.Machine{}).
+		WithDefaulter(w).
+		WithValidator(w).
+		Complete()
+}
+
+// Default fills the namespace of the Secret references and the driver
+// parameters the operator would otherwise pick at creation time.
+func (w *MachineWebhook) Default(ctx context.Context, obj runtime.Object) error {
+	machine, ok := obj.(*api.Machine)
+	if !ok {
+		return fmt.Errorf("expected a Machine, got %T", obj)
+	}
+	if ref := machine.Spec.AuthSecret; ref != nil && ref.Namespace == "" {
+		ref.Namespace = machine.Namespace
+	}
+	if ref := machine.Spec.ScriptRef; ref != nil && ref.Namespace == "" {
+		ref.Namespace = machine.Namespace
+	}
+	spec := &machine.Spec
+	if machine.Spec.ClassRef != nil {
+		// a class that does not exist yet is reported by the validation
+		if effective, err := controller.EffectiveMachineSpec(ctx, w.Client, machine); err == nil {
+			spec = effective
+		}
+	}
+	if spec.Driver == nil {
+		return nil
+	}
+
+	switch spec.Driver.Name {
+	case controller.AWSDriver:
+		if _, ok := controller.SpecParameter(spec, controller.AWSAMIParam); !ok {
+			if ami := controller.DefaultAMIID(controller.AWSRegion(spec)); ami != "" {
+				setParameter(machine, controller.AWSAMIParam, ami)
+			}
+		}
+	case controller.AzureDriver:
+		if _, ok := spec.Parameters[controller.AzureResourceGroupParam]; !ok {
+			setParameter(machine, controller.AzureResourceGroupParam, controller.DefaultAzureResourceGroup)
+		}
+	}
+	return nil
+}
+
+func setParameter(machine *api.Machine, key, value string) {
+	if machine.Spec.Parameters == nil {
+		machine.Spec.Parameters = map[string]string{}
+	}
+	machine.Spec.Parameters[key] = value
+}
+
+func (w *MachineWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	machine, ok := obj.(*api.Machine)
+	if !ok {
+		return nil, fmt.Errorf("expected a Machine, got %T", obj)
+	}
+	return w.validate(ctx, machine, nil)
+}
+
+func (w *MachineWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	machine, ok := newObj.(*api.Machine)
+	if !ok {
+		return nil, fmt.Errorf("expected a Machine, got %T", newObj)
+	}
+	old, ok := oldObj.(*api.Machine)
+	if !ok {
+		return nil, fmt.Errorf("expected a Machine, got %T", oldObj)
+	}
+	if !machine.DeletionTimestamp.IsZero() {
+		// let the operator remove its finalizer
+		return nil, nil
+	}
+	if equality.Semantic.DeepEqual(old.Spec, machine.Spec) {
+		// e.g. the finalizer and the annotations of the operator, they must not
+		// fail because the Driver schema or the class changed since the Machine was created
+		return nil, nil
+	}
+	return w.validate(ctx, machine, old)
+}
+
+func (w *MachineWebhook) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (w *MachineWebhook) validate(ctx context.Context, machine, old *api.Machine) (admission.Warnings, error) {
+	var errs field.ErrorList
+	var warnings admission.Warnings
+	specPath := field.NewPath("spec")
+
+	// the fields not set by the Machine are validated once its class exists
+	spec := &machine.Spec
+	classMissing := false
+	if ref := machine.Spec.ClassRef; ref != nil {
+		effective, err := controller.EffectiveMachineSpec(ctx, w.Client, machine)
+		switch {
+		case ref.Name == "":
+			errs = append(errs, field.Required(specPath.Child("classRef", "name"), "name of the class is required"))
+			classMissing = true
+		case kerr.IsNotFound(err):
+			warnings = append(warnings, fmt.Sprintf("%s %s is not found, the Machine waits for it", ref.Kind, ref.Name))
+			classMissing = true
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("failed to read %s %s: %v", ref.Kind, ref.Name, err))
+			classMissing = true
+		default:
+			spec = effective
+		}
+	}
+
+	driverPath := specPath.Child("driver", "name")
+	driver := ""
+	if spec.Driver != nil {
+		driver = spec.Driver.Name
+	}
+	if driver == "" && !classMissing {
+		errs = append(errs, field.Required(driverPath, "driver is required"))
+	}
+	if old != nil && driver != "" {
+		if oldDriver := storedDriverName(old); oldDriver != "" && oldDriver != driver {
+			errs = append(errs, field.Forbidden(driverPath, "the driver of an existing Machine can not be changed"))
+		}
+	}
+
+	// only the fields that changed are validated against the Driver and the auth Secret
+	classChanged := old == nil || !equality.Semantic.DeepEqual(old.Spec.ClassRef, machine.Spec.ClassRef)
+	paramsChanged := classChanged || !equality.Semantic.DeepEqual(old.Spec.Parameters, machine.Spec.Parameters) ||
+		!equality.Semantic.DeepEqual(old.Spec.Flags, machine.Spec.Flags)
+	authChanged := classChanged || !equality.Semantic.DeepEqual(old.Spec.AuthSecret, machine.Spec.AuthSecret) ||
+		!equality.Semantic.DeepEqual(old.Spec.Driver, machine.Spec.Driver)
+
+	paramsPath := specPath.Child("parameters")
+	for key := range machine.Spec.Parameters {
+		if key == "" {
+			errs = append(errs, field.Invalid(paramsPath, key, "parameter names must not be empty"))
+			continue
+		}
+		if err := validateFlagName(paramsPath.Key(key), key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	flagsPath := specPath.Child("flags")
+	for i, f := range machine.Spec.Flags {
+		path := flagsPath.Index(i)
+		if err := validateFlagName(path.Child("name"), f.Name); err != nil {
+			errs = append(errs, err)
+		}
+		switch {
+		case f.Bool != nil && len(f.Values) > 0:
+			errs = append(errs, field.Invalid(path, f.Name, "a flag has either values or bool"))
+		case f.Bool == nil && len(f.Values) == 0:
+			errs = append(errs, field.Required(path.Child("values"), "values or bool is required"))
+		}
+	}
+	if driver == controller.AWSDriver && paramsChanged && controller.AWSRegion(spec) == "" {
+		errs = append(errs, field.Required(paramsPath.Key(controller.AWSRegionParam), "the region is required for the amazonec2 driver"))
+	}
+
+	if driver != "" && paramsChanged {
+		paramErrs, err := w.validateParameters(ctx, machine.Namespace, spec, driver, specPath)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, paramErrs...)
+	}
+
+	if ref := spec.ScriptRef; ref != nil && ref.Name == "" {
+		errs = append(errs, field.Required(specPath.Child("scriptRef", "name"), "name of the script Secret is required"))
+	}
+
+	authPath := specPath.Child("authSecret")
+	switch ref := spec.AuthSecret; {
+	case (ref == nil || ref.Name == "") && !classMissing:
+		errs = append(errs, field.Required(authPath.Child("name"), "auth Secret is required"))
+	case ref != nil && ref.Name != "" && driver != "" && authChanged:
+		warn, err := w.validateAuthSecret(ctx, machine.Namespace, ref, driver)
+		if err != nil {
+			errs = append(errs, field.Invalid(authPath, ref.Name, err.Error()))
+		}
+		if warn != "" {
+			warnings = append(warnings, warn)
+		}
+	}
+
+	if len(errs) > 0 {
+		return warnings, kerr.NewInvalid(api.GroupVersion.WithKind("Machine").GroupKind(), machine.Name, errs)
+	}
+	return warnings, nil
+}
+
+// validateAuthSecret checks the keys of the auth Secret against the driver. A
+// Secret that does not exist yet only gives a warning, it may be created after the Machine.
+func (w *MachineWebhook) validateAuthSecret(ctx context.Context, namespace string, ref *kmapi.ObjectReference, driver string) (string, error) {
+	key := ref.ObjectKey()
+	if key.Namespace == "" {
+		key.Namespace = namespace
+	}
+	var secret core.Secret
+	err := w.Client.Get(ctx, key, &secret)
+	if kerr.IsNotFound(err) {
+		return fmt.Sprintf("auth secret %s is not found, the Machine waits for it", key), nil
+	}
+	if err != nil {
+		return fmt.Sprintf("failed to read auth secret %s: %v", key, err), nil
+	}
+	return "", controller.ValidateAuthSecret(driver, &secret)
+}
+
+// storedDriverName returns the driver of a stored Machine, the one of its
+// class once the Machine is reconciled.
+func storedDriverName(machine *api.Machine) string {
+	switch {
+	case machine.Spec.Driver != nil:
+		return machine.Spec.Driver.Name
+	case machine.Status.EffectiveSpec != nil && machine.Status.EffectiveSpec.Driver != nil:
+		return machine.Status.EffectiveSpec.Driver.Name
+	}
+	return ""
+}
+
+// validateFlagName checks that name is a flag without the leading dashes.
+func validateFlagName(path *field.Path, name string) *field.Error {
+	switch {
+	case name == "":
+		return field.Required(path, "flag names must not be empty")
+	case strings.HasPrefix(name, "-"):
+		return field.Invalid(path, name, fmt.Sprintf("parameter names are flags without the leading dashes, use %q", strings.TrimLeft(name, "-")))
+	case strings.ContainsAny(name, "= \t\n"):
+		return field.Invalid(path, name, "parameter names must not contain '=' or white space")
+	}
+	return nil
+}
+
+// validateParameters checks the parameters and flags against the schema of the Driver.
+// A Driver that does not exist yet is not an error, the Machine waits for it.
+func (w *MachineWebhook) validateParameters(ctx context.Context, namespace string, spec *api.MachineSpec, driver string, fldPath *field.Path) (field.ErrorList, error) {
+	var d api.Driver
+	err := w.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: driver}, &d)
+	if kerr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return controller.ValidateParameters(&d, spec, fldPath), nil
+}
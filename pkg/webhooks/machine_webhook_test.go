@@ -0,0 +1,371 @@
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/controller"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// objectReader serves Secrets, Drivers and classes from memory.
+type objectReader map[client.ObjectKey]client.Object
+
+func (r objectReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	o, ok := r[key]
+	if !ok || reflect.TypeOf(o) != reflect.TypeOf(obj) {
+		return kerr.NewNotFound(schema.GroupResource{}, key.Name)
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(o.DeepCopyObject()).Elem())
+	return nil
+}
+
+func (r objectReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return errors.New("not implemented")
+}
+
+func newMachine(driver string, params map[string]string) *api.Machine {
+	return &api.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "demo"},
+		Spec: api.MachineSpec{
+			Driver:     &core.LocalObjectReference{Name: driver},
+			AuthSecret: &kmapi.ObjectReference{Name: "cred"},
+			Parameters: params,
+		},
+	}
+}
+
+func newClassMachine(kind, class string) *api.Machine {
+	return &api.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vm", Namespace: "demo"},
+		Spec: api.MachineSpec{
+			ClassRef: &api.MachineClassReference{Kind: kind, Name: class},
+		},
+	}
+}
+
+func TestMachineDefault(t *testing.T) {
+	w := &MachineWebhook{}
+
+	aws := newMachine(controller.AWSDriver, map[string]string{controller.AWSRegionParam: "us-east-1"})
+	if err := w.Default(context.Background(), aws); err != nil {
+		t.Fatal(err)
+	}
+	if aws.Spec.AuthSecret.Namespace != "demo" {
+		t.Errorf("auth secret namespace = %q, want demo", aws.Spec.AuthSecret.Namespace)
+	}
+	if got := aws.Spec.Parameters[controller.AWSAMIParam]; got == "" || got != controller.DefaultAMIID("us-east-1") {
+		t.Errorf("ami = %q", got)
+	}
+
+	flags := newMachine(controller.AWSDriver, nil)
+	flags.Spec.Flags = []api.MachineFlag{{Name: controller.AWSRegionParam, Values: []string{"eu-west-1"}}}
+	if err := w.Default(context.Background(), flags); err != nil {
+		t.Fatal(err)
+	}
+	if got := flags.Spec.Parameters[controller.AWSAMIParam]; got == "" || got != controller.DefaultAMIID("eu-west-1") {
+		t.Errorf("ami = %q, want the one of the region flag", got)
+	}
+
+	custom := newMachine(controller.AWSDriver, map[string]string{controller.AWSRegionParam: "us-east-1", controller.AWSAMIParam: "ami-custom"})
+	if err := w.Default(context.Background(), custom); err != nil {
+		t.Fatal(err)
+	}
+	if got := custom.Spec.Parameters[controller.AWSAMIParam]; got != "ami-custom" {
+		t.Errorf("ami = %q, want the one set by the user", got)
+	}
+
+	azure := newMachine(controller.AzureDriver, nil)
+	if err := w.Default(context.Background(), azure); err != nil {
+		t.Fatal(err)
+	}
+	if got := azure.Spec.Parameters[controller.AzureResourceGroupParam]; got != controller.DefaultAzureResourceGroup {
+		t.Errorf("resource group = %q", got)
+	}
+}
+
+func TestMachineValidate(t *testing.T) {
+	w := &MachineWebhook{Client: objectReader{
+		{Namespace: "demo", Name: "cred"}: &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cred", Namespace: "demo"},
+			Data:       map[string][]byte{"amazonec2-access-key": []byte("id")},
+		},
+		{Namespace: "demo", Name: "vultr"}: &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: "vultr", Namespace: "demo"},
+			Spec: api.DriverSpec{
+				Parameters: []api.DriverParameter{{Name: "vultr-region", Required: true}},
+			},
+			Status: api.DriverStatus{
+				Parameters: []api.DriverParameter{
+					{Name: "vultr-api-key", Type: api.DriverParameterTypeString, Secret: true},
+					{Name: "vultr-region", Type: api.DriverParameterTypeString},
+					{Name: "vultr-os-id", Type: api.DriverParameterTypeInt, Default: "387"},
+				},
+			},
+		},
+		{Namespace: "demo", Name: "do"}: &api.MachineClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "do", Namespace: "demo"},
+			Spec: api.MachineClassSpec{
+				Driver:     &core.LocalObjectReference{Name: "digitalocean"},
+				AuthSecret: &kmapi.ObjectReference{Name: "cred"},
+			},
+		},
+		{Name: "aws"}: &api.ClusterMachineClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws"},
+			Spec: api.MachineClassSpec{
+				Driver:     &core.LocalObjectReference{Name: controller.AWSDriver},
+				AuthSecret: &kmapi.ObjectReference{Name: "cred"},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name     string
+		machine  *api.Machine
+		old      *api.Machine
+		wantErr  string
+		warnings int
+	}{
+		{
+			name:    "no driver",
+			machine: newMachine("", nil),
+			wantErr: "spec.driver.name: Required value",
+		},
+		{
+			name:    "aws without region",
+			machine: newMachine(controller.AWSDriver, nil),
+			wantErr: "spec.parameters[amazonec2-region]: Required value",
+		},
+		{
+			name:    "aws auth secret without secret key",
+			machine: newMachine(controller.AWSDriver, map[string]string{controller.AWSRegionParam: "us-east-1"}),
+			wantErr: "missing amazonec2-secret-key",
+		},
+		{
+			name: "aws with the region in flags",
+			machine: func() *api.Machine {
+				m := newMachine(controller.AWSDriver, nil)
+				m.Spec.Flags = []api.MachineFlag{{Name: controller.AWSRegionParam, Values: []string{"us-east-1"}}}
+				return m
+			}(),
+			wantErr: "missing amazonec2-secret-key",
+		},
+		{
+			name:    "parameter with dashes",
+			machine: newMachine("digitalocean", map[string]string{"--digitalocean-size": "s-1vcpu-1gb"}),
+			wantErr: `use "digitalocean-size"`,
+		},
+		{
+			name:    "driver change",
+			machine: newMachine("digitalocean", nil),
+			old:     newMachine("linode", nil),
+			wantErr: "spec.driver.name: Forbidden",
+		},
+		{
+			name:    "valid driver parameters",
+			machine: newMachine("vultr", map[string]string{"vultr-region": "ams", "vultr-os-id": "270"}),
+		},
+		{
+			name:    "missing required driver parameter",
+			machine: newMachine("vultr", map[string]string{"vultr-os-id": "270"}),
+			wantErr: "spec.parameters[vultr-region]: Required value",
+		},
+		{
+			name:    "unknown driver parameter",
+			machine: newMachine("vultr", map[string]string{"vultr-region": "ams", "vultr-size": "small"}),
+			wantErr: "spec.parameters[vultr-size]: Not found",
+		},
+		{
+			name:    "secret driver parameter",
+			machine: newMachine("vultr", map[string]string{"vultr-region": "ams", "vultr-api-key": "key"}),
+			wantErr: "spec.parameters[vultr-api-key]: Forbidden",
+		},
+		{
+			name:    "invalid driver parameter type",
+			machine: newMachine("vultr", map[string]string{"vultr-region": "ams", "vultr-os-id": "ubuntu"}),
+			wantErr: "must be an integer",
+		},
+		{
+			name:    "unchanged driver parameters",
+			machine: newMachine("vultr", map[string]string{"vultr-size": "small"}),
+			old:     newMachine("vultr", map[string]string{"vultr-size": "small"}),
+		},
+		{
+			name: "flag with values and bool",
+			machine: func() *api.Machine {
+				m := newMachine("digitalocean", nil)
+				enabled := true
+				m.Spec.Flags = []api.MachineFlag{{Name: "engine-opt", Values: []string{"a=b"}, Bool: &enabled}}
+				return m
+			}(),
+			wantErr: "spec.flags[0]: Invalid value",
+		},
+		{
+			name: "flag without values",
+			machine: func() *api.Machine {
+				m := newMachine("digitalocean", nil)
+				m.Spec.Flags = []api.MachineFlag{{Name: "--engine-opt"}}
+				return m
+			}(),
+			wantErr: `spec.flags[0].name: Invalid value: "--engine-opt"`,
+		},
+		{
+			name: "required driver parameter set by a flag",
+			machine: func() *api.Machine {
+				m := newMachine("vultr", nil)
+				m.Spec.Flags = []api.MachineFlag{{Name: "vultr-region", Values: []string{"ams"}}}
+				return m
+			}(),
+		},
+		{
+			name:    "driver and auth secret set by the class",
+			machine: newClassMachine(api.ResourceKindMachineClass, "do"),
+		},
+		{
+			name:    "cluster class without region",
+			machine: newClassMachine(api.ResourceKindClusterMachineClass, "aws"),
+			wantErr: "spec.parameters[amazonec2-region]: Required value",
+		},
+		{
+			name:     "missing class",
+			machine:  newClassMachine(api.ResourceKindMachineClass, "other"),
+			warnings: 1,
+		},
+		{
+			name:    "class without name",
+			machine: newClassMachine(api.ResourceKindMachineClass, ""),
+			wantErr: "spec.classRef.name: Required value",
+		},
+		{
+			name:    "driver change by the class",
+			machine: newClassMachine(api.ResourceKindMachineClass, "do"),
+			old:     newMachine("linode", nil),
+			wantErr: "spec.driver.name: Forbidden",
+		},
+		{
+			name:     "missing auth secret",
+			machine:  func() *api.Machine { m := newMachine("digitalocean", nil); m.Spec.AuthSecret.Name = "other"; return m }(),
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := w.validate(context.Background(), tt.machine, tt.old)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v", warnings)
+			}
+		})
+	}
+}
+
+func TestMachineValidateUpdate(t *testing.T) {
+	w := &MachineWebhook{Client: objectReader{
+		{Namespace: "demo", Name: "vultr"}: &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: "vultr", Namespace: "demo"},
+			Status: api.DriverStatus{
+				Parameters: []api.DriverParameter{
+					{Name: "vultr-region", Type: api.DriverParameterTypeString, Required: true},
+				},
+			},
+		},
+	}}
+	// created before the schema of the Driver was discovered
+	old := newMachine("vultr", map[string]string{"vultr-size": "small"})
+
+	tests := []struct {
+		name    string
+		update  func(*api.Machine)
+		wantErr string
+	}{
+		{
+			name:   "finalizer",
+			update: func(m *api.Machine) { m.Finalizers = []string{api.GetFinalizer()} },
+		},
+		{
+			name: "annotation",
+			update: func(m *api.Machine) {
+				m.Annotations = map[string]string{"docker-machine-operator/aws-vpc": "vpc-1"}
+			},
+		},
+		{
+			name:   "unrelated spec field",
+			update: func(m *api.Machine) { m.Spec.ScriptKey = "vultr-userdata" },
+		},
+		{
+			name:    "parameters",
+			update:  func(m *api.Machine) { m.Spec.Parameters["vultr-os-id"] = "270" },
+			wantErr: "spec.parameters[vultr-region]: Required value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machine := old.DeepCopy()
+			tt.update(machine)
+			_, err := w.ValidateUpdate(context.Background(), old, machine)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateDriver(t *testing.T) {
+	checksum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		spec    api.DriverSpec
+		wantErr string
+	}{
+		{name: "builtin", spec: api.DriverSpec{Builtin: true}},
+		{name: "valid", spec: api.DriverSpec{DownloadURL: "https://example.com/driver", Checksum: "sha256:" + checksum}},
+		{name: "no url", spec: api.DriverSpec{Checksum: checksum}, wantErr: "spec.downloadURL: Required value"},
+		{name: "bad url", spec: api.DriverSpec{DownloadURL: "ftp://example.com/driver", Checksum: checksum}, wantErr: "must be an http or https URL"},
+		{name: "bad checksum", spec: api.DriverSpec{DownloadURL: "https://example.com/driver", Checksum: "md5:abc"}, wantErr: "sha256 digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateDriver(&api.Driver{ObjectMeta: metav1.ObjectMeta{Name: "d"}, Spec: tt.spec})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+//go:build envtest
+
+/*
+Copyright AppsCode Inc. and Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"strings"
+
+	api "go.klusters.dev/docker-machine-operator/api/v1alpha1"
+	"go.klusters.dev/docker-machine-operator/pkg/controller"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Admission webhooks", func() {
+	var (
+		ctx context.Context
+		ns  string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		namespace := &core.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "webhooks-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+		ns = namespace.Name
+
+		Expect(k8sClient.Create(ctx, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws-cred", Namespace: ns},
+			Data: map[string][]byte{
+				"amazonec2-access-key": []byte("id"),
+				"amazonec2-secret-key": []byte("secret"),
+			},
+		})).To(Succeed())
+	})
+
+	machine := func(name, driver string, params map[string]string) *api.Machine {
+		return &api.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Spec: api.MachineSpec{
+				Driver:     &core.LocalObjectReference{Name: driver},
+				AuthSecret: &kmapi.ObjectReference{Name: "aws-cred"},
+				Parameters: params,
+			},
+		}
+	}
+
+	It("defaults the AMI and the Secret namespace of AWS Machines", func() {
+		mc := machine("aws", controller.AWSDriver, map[string]string{controller.AWSRegionParam: "us-east-1"})
+		Expect(k8sClient.Create(ctx, mc)).To(Succeed())
+
+		var got api.Machine
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(mc), &got)).To(Succeed())
+		Expect(got.Spec.AuthSecret.Namespace).To(Equal(ns))
+		Expect(got.Spec.Parameters).To(HaveKeyWithValue(controller.AWSAMIParam, controller.DefaultAMIID("us-east-1")))
+	})
+
+	It("rejects invalid Machines", func() {
+		err := k8sClient.Create(ctx, machine("no-region", controller.AWSDriver, nil))
+		Expect(kerr.IsInvalid(err)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring(controller.AWSRegionParam))
+
+		err = k8sClient.Create(ctx, machine("dashes", controller.AWSDriver, map[string]string{
+			controller.AWSRegionParam:   "us-east-1",
+			"--amazonec2-instance-type": "t3.medium",
+		}))
+		Expect(kerr.IsInvalid(err)).To(BeTrue(), "%v", err)
+	})
+
+	It("rejects a driver change on an existing Machine", func() {
+		mc := machine("immutable", controller.AWSDriver, map[string]string{controller.AWSRegionParam: "us-east-1"})
+		Expect(k8sClient.Create(ctx, mc)).To(Succeed())
+
+		mc.Spec.Driver.Name = controller.GoogleDriver
+		err := k8sClient.Update(ctx, mc)
+		Expect(kerr.IsInvalid(err)).To(BeTrue(), "%v", err)
+		Expect(strings.ToLower(err.Error())).To(ContainSubstring("can not be changed"))
+	})
+
+	It("rejects non-builtin Drivers without a checksum", func() {
+		err := k8sClient.Create(ctx, &api.Driver{
+			ObjectMeta: metav1.ObjectMeta{Name: "linode", Namespace: ns},
+			Spec:       api.DriverSpec{DownloadURL: "https://example.com/docker-machine-driver-linode"},
+		})
+		Expect(kerr.IsInvalid(err)).To(BeTrue(), "%v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.checksum"))
+	})
+})